@@ -46,12 +46,24 @@ func main() {
 		log.Fatalf("Failed to load config: %v", err)
 	}
 
-	dbStorage, err := storage.NewDataBaseStorage(cfg.DataBaseURI)
-	if err != nil {
-		sugar.Fatalf("failed to connect to database: %v", err)
+	var st storage.Storage
+	if cfg.InMemory || cfg.DataBaseURI == "" {
+		// Данные в памяти теряются при перезапуске - только для разработки и тестов
+		sugar.Warn("using in-memory storage, data will be lost on restart")
+		st = storage.NewMemStorage()
+	} else {
+		// sqlite://... - SQLite, иначе PostgreSQL
+		dbStorage, err := storage.New(cfg.DataBaseURI)
+		if err != nil {
+			sugar.Fatalf("failed to connect to database: %v", err)
+		}
+		st = dbStorage
 	}
 
-	applictaion := app.NewApp(dbStorage, sugar, cfg.Accural)
+	applictaion, err := app.NewApp(st, sugar, cfg)
+	if err != nil {
+		sugar.Fatalf("failed to initialize app: %v", err)
+	}
 	if err := applictaion.Run(ctx, cfg.RunAddr); err != nil {
 		sugar.Fatalln(err)
 	}
@@ -0,0 +1,42 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"log"
+
+	"github.com/NailUsmanov/gophermart/internal/reconcile"
+	"github.com/NailUsmanov/gophermart/internal/storage"
+	"github.com/NailUsmanov/gophermart/pkg/config"
+	"go.uber.org/zap"
+)
+
+// Утилита разовой сверки балансов: go run ./cmd/reconcile -d <DSN> [-repair]
+var flagRepair = flag.Bool("repair", false, "overwrite drifted balances with values recomputed from the ledger")
+
+func main() {
+	logger, err := zap.NewDevelopment()
+	if err != nil {
+		panic(err)
+	}
+	defer logger.Sync()
+	sugar := logger.Sugar()
+
+	cfg, err := config.NewConfig()
+	if err != nil {
+		log.Fatalf("Failed to load config: %v", err)
+	}
+
+	dbStorage, err := storage.New(cfg.DataBaseURI)
+	if err != nil {
+		sugar.Fatalf("failed to connect to database: %v", err)
+	}
+
+	drifts, err := reconcile.NewReconciler(dbStorage, sugar, *flagRepair).RunOnce(context.Background())
+	if err != nil {
+		sugar.Fatalf("reconciliation failed: %v", err)
+	}
+	if len(drifts) > 0 && !*flagRepair {
+		sugar.Fatalf("found %d drifted balances, rerun with -repair to fix them", len(drifts))
+	}
+}
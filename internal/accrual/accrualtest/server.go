@@ -0,0 +1,187 @@
+// Package accrualtest - поддельный сервер accrual на httptest для тестов клиента и воркера.
+//
+//	srv := accrualtest.NewServer()
+//	defer srv.Close()
+//	srv.SetOrder("12345678903", accrualtest.Processing(), accrualtest.Processed("729.98"))
+//	client := accrual.NewClient(srv.URL, time.Second)
+//
+// Незнакомый заказ получает 204, как незарегистрированный в accrual.
+package accrualtest
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/NailUsmanov/gophermart/internal/accrual"
+)
+
+// Response - один ответ сервера по заказу
+type Response struct {
+	// Code - HTTP-код ответа, 0 - 200
+	Code   int
+	Status accrual.Status
+	// Accrual - начисление как есть в JSON (например, "729.98"), пусто - поле не пишется
+	Accrual string
+	// Number - номер заказа в ответе, пусто - номер из запроса
+	Number string
+}
+
+func Registered() Response { return Response{Status: accrual.StatusRegistered} }
+func Processing() Response { return Response{Status: accrual.StatusProcessing} }
+func Invalid() Response    { return Response{Status: accrual.StatusInvalid} }
+func Processed(amount string) Response {
+	return Response{Status: accrual.StatusProcessed, Accrual: amount}
+}
+
+// Failure - ответ с ошибкой сервера
+func Failure(code int) Response { return Response{Code: code} }
+
+// Request - запрос, полученный сервером
+type Request struct {
+	Number string
+	At     time.Time
+}
+
+type Server struct {
+	*httptest.Server
+
+	mu        sync.Mutex
+	responses map[string][]Response
+	throttle  *throttle
+	delay     time.Duration
+	requests  []Request
+	inFlight  map[string]int
+	active    int
+	// maxActive - наибольшее число одновременных запросов
+	maxActive int
+	// overlaps - сколько раз один заказ запрашивали параллельно
+	overlaps int
+}
+
+type throttle struct {
+	retryAfter string
+	limit      int
+}
+
+func NewServer() *Server {
+	s := &Server{
+		responses: make(map[string][]Response),
+		inFlight:  make(map[string]int),
+	}
+	s.Server = httptest.NewServer(http.HandlerFunc(s.handle))
+	return s
+}
+
+// SetOrder задает ответы по заказу: каждый запрос забирает следующий, последний повторяется
+func (s *Server) SetOrder(number string, responses ...Response) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.responses[number] = responses
+}
+
+// Throttle отвечает 429 на следующий запрос. retryAfter пишется в Retry-After как есть,
+// limit > 0 - в тело "No more than N requests per minute allowed"
+func (s *Server) Throttle(retryAfter string, limit int) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.throttle = &throttle{retryAfter: retryAfter, limit: limit}
+}
+
+// SetDelay задерживает каждый ответ, чтобы запросы пересекались во времени
+func (s *Server) SetDelay(d time.Duration) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.delay = d
+}
+
+// Requests возвращает все полученные запросы в порядке поступления
+func (s *Server) Requests() []Request {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]Request(nil), s.requests...)
+}
+
+// MaxConcurrent - наибольшее число одновременно обрабатываемых запросов
+func (s *Server) MaxConcurrent() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.maxActive
+}
+
+// Overlaps - сколько раз заказ запрашивали, пока предыдущий запрос по нему еще не завершился
+func (s *Server) Overlaps() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.overlaps
+}
+
+func (s *Server) handle(w http.ResponseWriter, r *http.Request) {
+	number, ok := strings.CutPrefix(r.URL.Path, "/api/orders/")
+	if !ok || r.Method != http.MethodGet {
+		http.NotFound(w, r)
+		return
+	}
+
+	s.mu.Lock()
+	s.requests = append(s.requests, Request{Number: number, At: time.Now()})
+	s.active++
+	s.maxActive = max(s.maxActive, s.active)
+	s.inFlight[number]++
+	if s.inFlight[number] > 1 {
+		s.overlaps++
+	}
+	th := s.throttle
+	s.throttle = nil
+	var (
+		resp  Response
+		found bool
+	)
+	if queue := s.responses[number]; len(queue) > 0 {
+		resp, found = queue[0], true
+		if len(queue) > 1 {
+			s.responses[number] = queue[1:]
+		}
+	}
+	delay := s.delay
+	s.mu.Unlock()
+
+	defer func() {
+		s.mu.Lock()
+		s.active--
+		s.inFlight[number]--
+		s.mu.Unlock()
+	}()
+	if delay > 0 {
+		time.Sleep(delay)
+	}
+
+	switch {
+	case th != nil:
+		if th.retryAfter != "" {
+			w.Header().Set("Retry-After", th.retryAfter)
+		}
+		w.Header().Set("Content-Type", "text/plain")
+		w.WriteHeader(http.StatusTooManyRequests)
+		if th.limit > 0 {
+			fmt.Fprintf(w, "No more than %d requests per minute allowed", th.limit)
+		}
+	case !found:
+		w.WriteHeader(http.StatusNoContent)
+	case resp.Code != 0 && resp.Code != http.StatusOK:
+		w.WriteHeader(resp.Code)
+	default:
+		if resp.Number == "" {
+			resp.Number = number
+		}
+		w.Header().Set("Content-Type", "application/json")
+		if resp.Accrual != "" {
+			fmt.Fprintf(w, `{"order":%q,"status":%q,"accrual":%s}`, resp.Number, resp.Status, resp.Accrual)
+			return
+		}
+		fmt.Fprintf(w, `{"order":%q,"status":%q}`, resp.Number, resp.Status)
+	}
+}
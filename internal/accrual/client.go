@@ -0,0 +1,187 @@
+// Package accrual - клиент внешней системы расчета начислений.
+//
+// GetOrder возвращает статус заказа или типизированную ошибку:
+// ErrNotRegistered (204), *RateLimitError (429), *ServerError (прочие коды) и ErrInvalidResponse.
+// Разобранный ответ перед применением проверяется через Order.Validate.
+package accrual
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/NailUsmanov/gophermart/internal/money"
+)
+
+// Status - статус расчета начисления в accrual
+type Status string
+
+const (
+	StatusRegistered Status = "REGISTERED"
+	StatusInvalid    Status = "INVALID"
+	StatusProcessing Status = "PROCESSING"
+	StatusProcessed  Status = "PROCESSED"
+)
+
+// DefaultTimeout - таймаут запроса, если в NewClient передан 0
+const DefaultTimeout = 10 * time.Second
+
+// DefaultRetryAfter - пауза после 429, если accrual не прислал понятный Retry-After
+const DefaultRetryAfter = time.Minute
+
+// maxBodySize - больше этого тело ответа по одному заказу не бывает
+const maxBodySize = 64 << 10
+
+var (
+	ErrNotRegistered      = errors.New("order is not registered in accrual")
+	ErrInvalidResponse    = errors.New("invalid accrual response")
+	ErrSuspiciousResponse = errors.New("suspicious accrual response")
+)
+
+// RateLimitError - accrual ответил 429
+type RateLimitError struct {
+	// RetryAfter - через сколько можно повторить запрос
+	RetryAfter time.Duration
+	// Limit - разрешенное число запросов в минуту из тела ответа, 0 - не указано
+	Limit int
+}
+
+func (e *RateLimitError) Error() string {
+	return fmt.Sprintf("accrual rate limit exceeded: retry after %s, limit %d per minute", e.RetryAfter, e.Limit)
+}
+
+// ServerError - accrual ответил неожиданным кодом (обычно 500)
+type ServerError struct {
+	StatusCode int
+}
+
+func (e *ServerError) Error() string {
+	return fmt.Sprintf("accrual responded with status %d", e.StatusCode)
+}
+
+// Order - ответ accrual по заказу
+type Order struct {
+	Number  string
+	Status  Status
+	Accrual *money.Amount
+	// Raw - тело ответа как есть, заполняется и вместе с ErrInvalidResponse
+	Raw []byte
+}
+
+// Validate проверяет, что ответ относится к запрошенному заказу number и начисление правдоподобно:
+// оно неотрицательное и есть только у PROCESSED. Иначе - ErrSuspiciousResponse с причиной
+func (o Order) Validate(number string) error {
+	switch {
+	case o.Number != number:
+		return fmt.Errorf("%w: order %q in response to %q", ErrSuspiciousResponse, o.Number, number)
+	case o.Accrual == nil:
+		return nil
+	case *o.Accrual < 0:
+		return fmt.Errorf("%w: negative accrual %s", ErrSuspiciousResponse, o.Accrual)
+	case o.Status != StatusProcessed:
+		return fmt.Errorf("%w: accrual %s with status %s", ErrSuspiciousResponse, o.Accrual, o.Status)
+	}
+	return nil
+}
+
+// Client - запрос статуса заказа в accrual
+type Client interface {
+	GetOrder(ctx context.Context, number string) (Order, error)
+}
+
+// HTTPClient - Client поверх HTTP API accrual
+type HTTPClient struct {
+	baseURL string
+	http    *http.Client
+}
+
+func NewClient(baseURL string, timeout time.Duration) *HTTPClient {
+	if timeout <= 0 {
+		timeout = DefaultTimeout
+	}
+	return &HTTPClient{
+		baseURL: strings.TrimRight(baseURL, "/"),
+		http:    &http.Client{Timeout: timeout},
+	}
+}
+
+// limitRe разбирает тело ответа 429: "No more than N requests per minute allowed"
+var limitRe = regexp.MustCompile(`No more than (\d+) requests per minute allowed`)
+
+// GetOrder запрашивает GET {baseURL}/api/orders/{number}
+func (c *HTTPClient) GetOrder(ctx context.Context, number string) (Order, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, c.baseURL+"/api/orders/"+url.PathEscape(number), nil)
+	if err != nil {
+		return Order{}, fmt.Errorf("failed to build accrual request: %w", err)
+	}
+	resp, err := c.http.Do(req)
+	if err != nil {
+		return Order{}, fmt.Errorf("accrual request failed: %w", err)
+	}
+	defer resp.Body.Close()
+
+	switch resp.StatusCode {
+	case http.StatusOK:
+	case http.StatusNoContent:
+		return Order{}, ErrNotRegistered
+	case http.StatusTooManyRequests:
+		body, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+		return Order{}, parseRateLimit(resp.Header, body)
+	default:
+		return Order{}, &ServerError{StatusCode: resp.StatusCode}
+	}
+
+	raw, err := io.ReadAll(io.LimitReader(resp.Body, maxBodySize))
+	if err != nil {
+		return Order{}, fmt.Errorf("failed to read accrual response: %w", err)
+	}
+	// Начисление читаем как json.Number, чтобы не терять точность на float64.
+	// NaN и бесконечность в JSON не записать, такой ответ не разберется
+	var body struct {
+		Order   string       `json:"order"`
+		Status  Status       `json:"status"`
+		Accrual *json.Number `json:"accrual,omitempty"`
+	}
+	if err := json.Unmarshal(raw, &body); err != nil {
+		return Order{Raw: raw}, fmt.Errorf("%w: %v", ErrInvalidResponse, err)
+	}
+	switch body.Status {
+	case StatusRegistered, StatusInvalid, StatusProcessing, StatusProcessed:
+	default:
+		return Order{Raw: raw}, fmt.Errorf("%w: unknown status %q", ErrInvalidResponse, body.Status)
+	}
+	order := Order{Number: body.Order, Status: body.Status, Raw: raw}
+	// Начисления от accrual округляем до сотых половиной от нуля
+	if body.Accrual != nil {
+		amount, err := money.ParseRounded(body.Accrual.String())
+		if err != nil {
+			return Order{Raw: raw}, fmt.Errorf("%w: accrual %q: %v", ErrInvalidResponse, body.Accrual.String(), err)
+		}
+		order.Accrual = &amount
+	}
+	return order, nil
+}
+
+// parseRateLimit достает из ответа 429 паузу (Retry-After в секундах или HTTP-дата) и лимит из тела
+func parseRateLimit(header http.Header, body []byte) *RateLimitError {
+	e := &RateLimitError{RetryAfter: DefaultRetryAfter}
+	if v := header.Get("Retry-After"); v != "" {
+		if sec, err := strconv.Atoi(v); err == nil && sec >= 0 {
+			e.RetryAfter = time.Duration(sec) * time.Second
+		} else if at, err := http.ParseTime(v); err == nil {
+			e.RetryAfter = time.Until(at)
+		}
+	}
+	if m := limitRe.FindSubmatch(body); m != nil {
+		e.Limit, _ = strconv.Atoi(string(m[1]))
+	}
+	return e
+}
@@ -0,0 +1,125 @@
+package accrual_test
+
+import (
+	"context"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/NailUsmanov/gophermart/internal/accrual"
+	"github.com/NailUsmanov/gophermart/internal/accrual/accrualtest"
+	"github.com/NailUsmanov/gophermart/internal/money"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestGetOrder(t *testing.T) {
+	ctx := context.Background()
+	srv := accrualtest.NewServer()
+	defer srv.Close()
+	client := accrual.NewClient(srv.URL, time.Second)
+
+	srv.SetOrder("12345678903", accrualtest.Processing(), accrualtest.Processed("729.985"))
+	order, err := client.GetOrder(ctx, "12345678903")
+	require.NoError(t, err)
+	assert.Equal(t, "12345678903", order.Number)
+	assert.Equal(t, accrual.StatusProcessing, order.Status)
+	assert.Nil(t, order.Accrual)
+	assert.JSONEq(t, `{"order":"12345678903","status":"PROCESSING"}`, string(order.Raw))
+
+	// Начисление округляется до сотых половиной от нуля
+	order, err = client.GetOrder(ctx, "12345678903")
+	require.NoError(t, err)
+	assert.Equal(t, accrual.StatusProcessed, order.Status)
+	require.NotNil(t, order.Accrual)
+	assert.Equal(t, money.Amount(72999), *order.Accrual)
+
+	_, err = client.GetOrder(ctx, "79927398713")
+	assert.ErrorIs(t, err, accrual.ErrNotRegistered)
+}
+
+func TestGetOrderErrors(t *testing.T) {
+	ctx := context.Background()
+	srv := accrualtest.NewServer()
+	defer srv.Close()
+	client := accrual.NewClient(srv.URL, time.Second)
+
+	srv.Throttle("60", 120)
+	_, err := client.GetOrder(ctx, "12345678903")
+	var rateLimited *accrual.RateLimitError
+	require.ErrorAs(t, err, &rateLimited)
+	assert.Equal(t, time.Minute, rateLimited.RetryAfter)
+	assert.Equal(t, 120, rateLimited.Limit)
+
+	// Retry-After в виде HTTP-даты
+	srv.Throttle(time.Now().Add(30*time.Second).UTC().Format(http.TimeFormat), 0)
+	_, err = client.GetOrder(ctx, "12345678903")
+	require.ErrorAs(t, err, &rateLimited)
+	assert.InDelta(t, 30*time.Second, rateLimited.RetryAfter, float64(2*time.Second))
+	assert.Zero(t, rateLimited.Limit)
+
+	// Без Retry-After - пауза по умолчанию
+	srv.Throttle("", 0)
+	_, err = client.GetOrder(ctx, "12345678903")
+	require.ErrorAs(t, err, &rateLimited)
+	assert.Equal(t, accrual.DefaultRetryAfter, rateLimited.RetryAfter)
+
+	srv.SetOrder("12345678903", accrualtest.Failure(http.StatusInternalServerError))
+	_, err = client.GetOrder(ctx, "12345678903")
+	var serverErr *accrual.ServerError
+	require.ErrorAs(t, err, &serverErr)
+	assert.Equal(t, http.StatusInternalServerError, serverErr.StatusCode)
+
+	srv.SetOrder("12345678903", accrualtest.Response{Status: "UNKNOWN"})
+	order, err := client.GetOrder(ctx, "12345678903")
+	assert.ErrorIs(t, err, accrual.ErrInvalidResponse)
+	// Тело неразобранного ответа сохраняется для карантина
+	assert.Contains(t, string(order.Raw), "UNKNOWN")
+
+	srv.SetOrder("12345678903", accrualtest.Processed("1e400"))
+	_, err = client.GetOrder(ctx, "12345678903")
+	assert.ErrorIs(t, err, accrual.ErrInvalidResponse)
+}
+
+func TestValidate(t *testing.T) {
+	amount := func(v money.Amount) *money.Amount { return &v }
+	tests := []struct {
+		name       string
+		order      accrual.Order
+		suspicious bool
+	}{
+		{"processed", accrual.Order{Number: "12345678903", Status: accrual.StatusProcessed, Accrual: amount(50000)}, false},
+		{"processed without accrual", accrual.Order{Number: "12345678903", Status: accrual.StatusProcessed}, false},
+		{"processing", accrual.Order{Number: "12345678903", Status: accrual.StatusProcessing}, false},
+		{"zero accrual", accrual.Order{Number: "12345678903", Status: accrual.StatusProcessed, Accrual: amount(0)}, false},
+		{"other order", accrual.Order{Number: "79927398713", Status: accrual.StatusProcessed, Accrual: amount(50000)}, true},
+		{"negative accrual", accrual.Order{Number: "12345678903", Status: accrual.StatusProcessed, Accrual: amount(-1)}, true},
+		{"accrual on invalid", accrual.Order{Number: "12345678903", Status: accrual.StatusInvalid, Accrual: amount(100)}, true},
+		{"accrual on registered", accrual.Order{Number: "12345678903", Status: accrual.StatusRegistered, Accrual: amount(100)}, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := tt.order.Validate("12345678903")
+			if tt.suspicious {
+				assert.ErrorIs(t, err, accrual.ErrSuspiciousResponse)
+			} else {
+				assert.NoError(t, err)
+			}
+		})
+	}
+}
+
+func TestGetOrderTimeout(t *testing.T) {
+	srv := accrualtest.NewServer()
+	defer srv.Close()
+	srv.SetDelay(200 * time.Millisecond)
+	srv.SetOrder("12345678903", accrualtest.Processing())
+
+	_, err := accrual.NewClient(srv.URL, 20*time.Millisecond).GetOrder(context.Background(), "12345678903")
+	assert.Error(t, err)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	_, err = accrual.NewClient(srv.URL, time.Second).GetOrder(ctx, "12345678903")
+	assert.ErrorIs(t, err, context.DeadlineExceeded)
+}
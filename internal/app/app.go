@@ -2,16 +2,25 @@ package app
 
 import (
 	"context"
+	"errors"
+	"fmt"
+	"io"
 	"net/http"
+	"time"
 
+	"github.com/NailUsmanov/gophermart/internal/accrual"
+	"github.com/NailUsmanov/gophermart/internal/auth"
 	"github.com/NailUsmanov/gophermart/internal/handlers"
 	"github.com/NailUsmanov/gophermart/internal/interfaces"
 	"github.com/NailUsmanov/gophermart/internal/middleware"
+	"github.com/NailUsmanov/gophermart/internal/reconcile"
 	"github.com/NailUsmanov/gophermart/internal/service"
 	"github.com/NailUsmanov/gophermart/internal/storage"
 	"github.com/NailUsmanov/gophermart/internal/validation"
 	"github.com/NailUsmanov/gophermart/internal/worker"
+	"github.com/NailUsmanov/gophermart/pkg/config"
 	"github.com/go-chi/chi"
+	chimw "github.com/go-chi/chi/middleware"
 	"go.uber.org/zap"
 )
 
@@ -22,11 +31,38 @@ type App struct {
 	worker     *worker.Worker
 	validation *validation.LuhnValidation
 	service    *service.Service
+	tokens     *auth.TokenManager
+	passwords  *auth.Passwords
+	sessionTTL time.Duration
+	adminToken string
+	// Сверка балансов запускается вместе с воркером в Run
+	reconcileInterval time.Duration
+	reconcileRepair   bool
+	// stopReconcile и reconcileDone - остановка сверки и ее окончание; без сверки reconcileDone = nil
+	stopReconcile   context.CancelFunc
+	reconcileDone   <-chan struct{}
+	shutdownTimeout time.Duration
+	idempotencyTTL  time.Duration
 }
 
-func NewApp(s storage.Storage, sugar *zap.SugaredLogger, accrualHost string) *App {
+func NewApp(s storage.Storage, sugar *zap.SugaredLogger, cfg *config.Config) (*App, error) {
+	passwords, err := auth.NewPasswordsFor(cfg.PasswordHash)
+	if err != nil {
+		return nil, err
+	}
 	r := chi.NewRouter()
-	w := worker.NewWorker(s, sugar, accrualHost)
+	w := worker.NewWorker(s, sugar, accrual.NewClient(cfg.Accural, cfg.AccrualTimeout), worker.Config{
+		PoolSize:         cfg.AccrualWorkers,
+		Interval:         cfg.AccrualPollInterval,
+		BatchSize:        cfg.AccrualBatchSize,
+		Backoff:          cfg.AccrualBackoff,
+		MaxDelay:         cfg.AccrualMaxDelay,
+		Lease:            cfg.AccrualLease,
+		BreakerThreshold: cfg.AccrualBreakerThreshold,
+		BreakerCooldown:  cfg.AccrualBreakerCooldown,
+		MaxAttempts:      cfg.AccrualMaxAttempts,
+		MaxAge:           cfg.AccrualMaxAge,
+	})
 	v := validation.LuhnValidation{}
 	app := &App{
 		storage:    s,
@@ -35,40 +71,123 @@ func NewApp(s storage.Storage, sugar *zap.SugaredLogger, accrualHost string) *Ap
 		worker:     w,
 		validation: &v,
 		service:    service.NewService(s, &v),
+		tokens:     auth.NewTokenManager(cfg.CookieSecretKey, cfg.TokenTTL),
+		passwords:  passwords,
+		sessionTTL: cfg.SessionTTL,
+		adminToken: cfg.AdminToken,
+
+		reconcileInterval: cfg.ReconcileInterval,
+		reconcileRepair:   cfg.ReconcileRepair,
+		shutdownTimeout:   cfg.ShutdownTimeout,
+		idempotencyTTL:    cfg.IdempotencyTTL,
 	}
 	sugar.Info("App initialized")
-	w.Start(context.Background())
 	app.setupRoutes()
-	return app
+	return app, nil
 }
 
 func (a *App) setupRoutes() {
+	// ID запроса (из X-Request-Id или новый) попадает в тела ответов с ошибкой
+	a.router.Use(chimw.RequestID)
 	a.router.Use(middleware.LoggingMiddleWare(a.sugar))
-	auth := interfaces.Auth(a.storage)
-	a.router.Post("/api/user/register", handlers.Register(auth, a.sugar))
-	a.router.Post("/api/user/login", handlers.Login(auth, a.sugar))
+	authStorage := interfaces.AuthSessions(a.storage)
+	a.router.Post("/api/user/register", handlers.Register(authStorage, a.sugar, a.tokens, a.passwords, a.sessionTTL))
+	a.router.Post("/api/user/login", handlers.Login(authStorage, a.sugar, a.tokens, a.passwords, a.sessionTTL))
+	a.router.Get("/api/health", handlers.Health(a.worker, a.sugar))
 
 	a.router.Route("/api/user", func(r chi.Router) {
-		r.Use(middleware.AuthMiddleware)
+		r.Use(middleware.AuthMiddleware(a.tokens, a.storage, a.sessionTTL))
 		r.Use(middleware.GzipMiddleware)
-		r.Post("/orders", handlers.PostOrder(a.service, a.sugar, a.validation))
+		// Idempotency-Key стоит после gzip: отпечаток считается по распакованному телу
+		idempotent := middleware.IdempotencyMiddleware(a.storage, a.idempotencyTTL, a.sugar)
+		r.With(idempotent).Post("/orders", handlers.PostOrder(a.service, a.sugar, a.validation))
 		r.Get("/orders", handlers.GetUserOrders(a.storage, a.sugar, a.validation))
 		r.Get("/balance", handlers.UserBalance(a.storage, a.sugar))
-		r.Post("/balance/withdraw", handlers.WithDraw(a.storage, a.sugar, a.validation))
+		r.With(idempotent).Post("/balance/withdraw", handlers.WithDraw(a.storage, a.sugar, a.validation))
 		r.Get("/withdrawals", handlers.AllUserWithDrawals(a.storage, a.sugar))
+		r.Post("/logout", handlers.Logout(a.storage, a.sugar))
+		r.Get("/sessions", handlers.UserSessions(a.storage, a.sugar))
+		r.Delete("/sessions/{id}", handlers.RevokeUserSession(a.storage, a.sugar))
+	})
+
+	// Админский API доступен, только если задан ADMIN_TOKEN
+	if a.adminToken == "" {
+		return
+	}
+	a.router.Route("/api/admin", func(r chi.Router) {
+		r.Use(middleware.AdminMiddleware(a.adminToken))
+		r.Get("/orders/stuck", handlers.StuckOrders(a.storage, a.sugar))
+		r.Post("/orders/{number}/requeue", handlers.RequeueOrder(a.worker.Statuses, a.sugar))
+		r.Get("/accrual/quarantine", handlers.QuarantinedResponses(a.storage, a.sugar))
 	})
 }
+
+// Run запускает воркер и HTTP-сервер и работает до отмены ctx (через cancel() в main) или ошибки сервера.
+// Остановка идет по порядку: HTTP-сервер, воркер и сверка балансов, пул соединений с БД
 func (a *App) Run(ctx context.Context, addr string) error {
+	// Воркер останавливается не отменой ctx, а в shutdown после HTTP-сервера:
+	// заказы, принятые во время остановки сервера, тоже уходят в опрос
+	a.worker.Start(context.WithoutCancel(ctx))
+	reconcileCtx, stopReconcile := context.WithCancel(ctx)
+	defer stopReconcile()
+	a.stopReconcile = stopReconcile
+	if a.reconcileInterval > 0 {
+		a.reconcileDone = reconcile.NewReconciler(a.storage, a.sugar, a.reconcileRepair).Start(reconcileCtx, a.reconcileInterval)
+	}
 	srv := http.Server{
 		Addr:    addr,
 		Handler: a.router,
 	}
-	// В фоновом потоке ждём, пока контекст не будет отменён (через cancel() в main)
+	serveErr := make(chan error, 1)
 	go func() {
-		<-ctx.Done()
-		a.sugar.Infow("Shutting down server...")
-		// Graceful shutdown: останавливаем HTTP-сервер, завершаем текущие соединения, новые не принимаем.
-		_ = srv.Shutdown(context.Background())
+		serveErr <- srv.ListenAndServe()
 	}()
-	return srv.ListenAndServe()
+
+	var err error
+	select {
+	case <-ctx.Done():
+		a.sugar.Infow("Shutting down server...")
+	case err = <-serveErr:
+		// Сервер не поднялся, но воркер уже запущен - останавливаем его так же
+		a.sugar.Errorf("HTTP server failed: %v", err)
+	}
+	return errors.Join(err, a.shutdown(&srv))
+}
+
+// shutdown останавливает сервис, давая каждой ступени не больше shutdownTimeout
+func (a *App) shutdown(srv *http.Server) error {
+	var errs []error
+	// Сначала HTTP: новые запросы не принимаются, текущие дописываются
+	httpCtx, cancel := context.WithTimeout(context.Background(), a.shutdownTimeout)
+	defer cancel()
+	if err := srv.Shutdown(httpCtx); err != nil {
+		errs = append(errs, fmt.Errorf("http server shutdown: %w", err))
+	}
+
+	// Затем воркер: новые заказы не выбираются, ответы accrual на начатые запросы сохраняются
+	a.worker.Stop()
+	workerCtx, cancel := context.WithTimeout(context.Background(), a.shutdownTimeout)
+	defer cancel()
+	if err := a.worker.Wait(workerCtx); err != nil {
+		errs = append(errs, fmt.Errorf("worker drain: %w", err))
+	}
+
+	// Сверка тоже пишет в БД (исправления балансов): дожидаемся ее до закрытия пула
+	a.stopReconcile()
+	if a.reconcileDone != nil {
+		select {
+		case <-a.reconcileDone:
+		case <-time.After(a.shutdownTimeout):
+			errs = append(errs, fmt.Errorf("reconciler stop: %w", context.DeadlineExceeded))
+		}
+	}
+
+	// Пул соединений закрывается последним, когда им уже никто не пользуется
+	if closer, ok := a.storage.(io.Closer); ok {
+		if err := closer.Close(); err != nil {
+			errs = append(errs, fmt.Errorf("storage close: %w", err))
+		}
+	}
+	a.sugar.Info("Shutdown complete")
+	return errors.Join(errs...)
 }
@@ -4,13 +4,17 @@ import (
 	"context"
 	"net/http"
 	"net/http/httptest"
+	"sync/atomic"
 	"testing"
+	"time"
 
 	"github.com/NailUsmanov/gophermart/internal/handlers"
 	"github.com/NailUsmanov/gophermart/internal/middleware"
 	"github.com/NailUsmanov/gophermart/internal/models"
+	"github.com/NailUsmanov/gophermart/internal/money"
 	"github.com/NailUsmanov/gophermart/internal/storage"
 	"github.com/NailUsmanov/gophermart/internal/validation"
+	"github.com/NailUsmanov/gophermart/pkg/config"
 	"github.com/go-chi/chi"
 	"github.com/stretchr/testify/assert"
 	"go.uber.org/zap"
@@ -37,21 +41,45 @@ func (m *mockStorage) GetUserIDByLogin(_ context.Context, _ string) (int, error)
 	return 1, nil
 }
 
-func (m *mockStorage) CheckHashMatch(_ context.Context, _ string, _ string) error {
+func (m *mockStorage) UpdatePasswordHash(_ context.Context, _ string, _ string) error {
 	return nil
 }
 
-func (m *mockStorage) AddWithdrawOrder(ctx context.Context, userID int, orderNumber string, sum float64) error {
+func (m *mockStorage) CreateSession(_ context.Context, _ int, _ string, _ string, _ time.Duration) (string, error) {
+	return "session", nil
+}
+
+func (m *mockStorage) TouchSession(_ context.Context, _ string, _ int, _ time.Duration) error {
+	return nil
+}
+
+func (m *mockStorage) ListSessions(_ context.Context, _ int) ([]models.Session, error) {
+	return nil, nil
+}
+
+func (m *mockStorage) RevokeSession(_ context.Context, _ int, _ string) error {
+	return nil
+}
+
+func (m *mockStorage) AddWithdrawOrder(ctx context.Context, userID int, orderNumber string, sum money.Amount) error {
 	return nil
 }
 func (m *mockStorage) GetAllUserWithdrawals(ctx context.Context, userID int) ([]models.UserWithDraw, error) {
 	return nil, nil
 }
 
+func (m *mockStorage) ListUserWithdrawals(ctx context.Context, userID int, f storage.WithdrawalFilter) (storage.WithdrawalPage, error) {
+	return storage.WithdrawalPage{}, nil
+}
+
 func (m *mockStorage) GetOrdersByUserID(ctx context.Context, userID int) ([]storage.Order, error) {
 	return nil, nil
 }
 
+func (m *mockStorage) ListUserOrders(ctx context.Context, userID int, f storage.OrderFilter) (storage.OrderPage, error) {
+	return storage.OrderPage{}, nil
+}
+
 func (m *mockStorage) CreateNewOrder(ctx context.Context, userID int, orderNum string, sugar *zap.SugaredLogger) error {
 	return nil
 }
@@ -60,25 +88,72 @@ func (m *mockStorage) CheckExistOrder(ctx context.Context, numberOrder string) (
 	return false, 0, nil
 }
 
-func (m *mockStorage) GetOrdersForAccrualUpdate(ctx context.Context) ([]storage.Order, error) {
+func (m *mockStorage) ClaimOrdersForAccrual(ctx context.Context, owner string, limit int, lease time.Duration) ([]storage.Order, error) {
 	return nil, nil
 }
 
-func (m *mockStorage) UpdateOrderStatus(ctx context.Context, number string, status string, accrual *float64) error {
+func (m *mockStorage) ScheduleNextCheck(ctx context.Context, number string, delay time.Duration) error {
+	return nil
+}
+
+func (m *mockStorage) ReleaseOrderLease(ctx context.Context, owner, number string) error {
+	return nil
+}
+
+func (m *mockStorage) NewOrders(ctx context.Context) (<-chan string, error) {
+	return make(chan string), nil
+}
+
+func (m *mockStorage) UpdateOrderStatus(ctx context.Context, t storage.StatusTransition, accrual *money.Amount) error {
+	return nil
+}
+
+func (m *mockStorage) QuarantineAccrualResponse(ctx context.Context, q models.QuarantinedResponse) error {
 	return nil
 }
 
-func (m *mockStorage) GetUserBalance(ctx context.Context, userID int) (float64, float64, error) {
+func (m *mockStorage) ListQuarantinedResponses(ctx context.Context, limit int) ([]models.QuarantinedResponse, error) {
+	return nil, nil
+}
+
+func (m *mockStorage) ListStuckOrders(ctx context.Context, limit int) ([]models.StuckOrder, error) {
+	return nil, nil
+}
+
+func (m *mockStorage) GetOrderStatusHistory(ctx context.Context, number string) ([]storage.StatusTransition, error) {
+	return nil, nil
+}
+
+func (m *mockStorage) GetUserBalance(ctx context.Context, userID int) (money.Amount, money.Amount, error) {
 	return 0, 0, nil
 }
 
-func (m *mockStorage) GetUserWithDrawns(ctx context.Context, userID int) (float64, error) {
+func (m *mockStorage) GetUserWithDrawns(ctx context.Context, userID int) (money.Amount, error) {
 	return 0, nil
 }
 
+func (m *mockStorage) ReconcileBalances(ctx context.Context, repair bool) ([]models.BalanceDrift, error) {
+	return nil, nil
+}
+
+func (m *mockStorage) BeginIdempotentRequest(ctx context.Context, userID int, key, fingerprint string, ttl time.Duration) (*models.IdempotentResponse, error) {
+	return nil, nil
+}
+
+func (m *mockStorage) CompleteIdempotentRequest(ctx context.Context, userID int, key string, resp models.IdempotentResponse) error {
+	return nil
+}
+
+func (m *mockStorage) ReleaseIdempotentRequest(ctx context.Context, userID int, key string) error {
+	return nil
+}
+
 func TestNewApp_InitializesRoutes(t *testing.T) {
 	sugar := NewTestLogger()
-	app := NewApp(&mockStorage{}, sugar, "http://localhost:8080")
+	cfg := &config.Config{Accural: "http://localhost:8080", CookieSecretKey: []byte("secret"), TokenTTL: time.Hour, SessionTTL: time.Hour}
+	cfg.SetDefaults()
+	app, err := NewApp(&mockStorage{}, sugar, cfg)
+	assert.NoError(t, err)
 
 	req := httptest.NewRequest("POST", "/api/user/register", nil)
 	w := httptest.NewRecorder()
@@ -134,3 +209,84 @@ func TestNewAppGetUserOrders(t *testing.T) {
 	assert.Equal(t, http.StatusNoContent, w.Code)
 
 }
+
+// closingStorage запоминает, что пул соединений закрыт. Сверка в нем идет, пока ее не отменят,
+// и Close запоминает, не закрыли ли пул посреди нее
+type closingStorage struct {
+	mockStorage
+	closed            atomic.Bool
+	reconciling       chan struct{}
+	inReconcile       atomic.Bool
+	closedInReconcile atomic.Bool
+}
+
+func (s *closingStorage) Close() error {
+	s.closedInReconcile.Store(s.inReconcile.Load())
+	s.closed.Store(true)
+	return nil
+}
+
+func (s *closingStorage) ReconcileBalances(ctx context.Context, repair bool) ([]models.BalanceDrift, error) {
+	s.inReconcile.Store(true)
+	defer s.inReconcile.Store(false)
+	select {
+	case s.reconciling <- struct{}{}:
+	default:
+	}
+	<-ctx.Done()
+	// Исправления после отмены еще дописываются
+	time.Sleep(50 * time.Millisecond)
+	return nil, ctx.Err()
+}
+
+func TestRunShutdown(t *testing.T) {
+	sugar := NewTestLogger()
+	cfg := &config.Config{Accural: "http://localhost:8080", CookieSecretKey: []byte("secret"), TokenTTL: time.Hour, SessionTTL: time.Hour}
+	cfg.SetDefaults()
+	st := &closingStorage{}
+	app, err := NewApp(st, sugar, cfg)
+	assert.NoError(t, err)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error, 1)
+	go func() {
+		done <- app.Run(ctx, "127.0.0.1:0")
+	}()
+	time.Sleep(50 * time.Millisecond)
+	cancel()
+
+	// Штатная остановка - не ошибка, хранилище закрыто последним
+	select {
+	case err := <-done:
+		assert.NoError(t, err)
+	case <-time.After(5 * time.Second):
+		t.Fatal("Run did not return after context cancellation")
+	}
+	assert.True(t, st.closed.Load())
+}
+
+func TestRunShutdownWaitsForReconciler(t *testing.T) {
+	sugar := NewTestLogger()
+	cfg := &config.Config{Accural: "http://localhost:8080", ReconcileInterval: time.Millisecond}
+	cfg.SetDefaults()
+	st := &closingStorage{reconciling: make(chan struct{}, 1)}
+	app, err := NewApp(st, sugar, cfg)
+	assert.NoError(t, err)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error, 1)
+	go func() {
+		done <- app.Run(ctx, "127.0.0.1:0")
+	}()
+	<-st.reconciling
+	cancel()
+
+	select {
+	case err := <-done:
+		assert.NoError(t, err)
+	case <-time.After(5 * time.Second):
+		t.Fatal("Run did not return after context cancellation")
+	}
+	assert.True(t, st.closed.Load())
+	assert.False(t, st.closedInReconcile.Load())
+}
@@ -0,0 +1,258 @@
+package auth
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"strings"
+
+	"golang.org/x/crypto/argon2"
+	"golang.org/x/crypto/bcrypt"
+)
+
+const (
+	AlgorithmArgon2id = "argon2id"
+	AlgorithmBcrypt   = "bcrypt"
+)
+
+var (
+	ErrUnknownHashFormat = errors.New("unknown password hash format")
+	ErrUnknownAlgorithm  = errors.New("unknown password hash algorithm")
+)
+
+// Hasher - одна схема хранения паролей. Параметры (соль, стоимость) кодируются в самой строке хэша,
+// поэтому проверка не зависит от текущих настроек
+type Hasher interface {
+	// Hash возвращает закодированный хэш пароля со случайной солью
+	Hash(password string) (string, error)
+	// Recognizes сообщает, в формате ли этой схемы закодирован хэш
+	Recognizes(encoded string) bool
+	// Verify сравнивает пароль с хэшем за постоянное время
+	Verify(password, encoded string) (bool, error)
+	// NeedsRehash сообщает, что хэш построен с устаревшими параметрами
+	NeedsRehash(encoded string) bool
+}
+
+// Passwords хэширует новые пароли текущей схемой и проверяет пароли любой известной схемой,
+// включая устаревший несоленый SHA-256
+type Passwords struct {
+	current Hasher
+	known   []Hasher
+	// dummyHash - хэш текущей схемой, с которым сверяется пароль неизвестного логина
+	dummyHash string
+}
+
+func NewPasswords(current Hasher) *Passwords {
+	// Ошибка возможна только при сбое генератора случайных чисел; тогда VerifyUnknown просто быстрее
+	dummyHash, _ := current.Hash("gophermart-unknown-login")
+	return &Passwords{
+		current: current,
+		known: []Hasher{
+			current,
+			NewArgon2idHasher(DefaultArgon2idParams),
+			NewBcryptHasher(bcrypt.DefaultCost),
+			legacySHA256Hasher{},
+		},
+		dummyHash: dummyHash,
+	}
+}
+
+// NewPasswordsFor создает Passwords с текущей схемой, выбранной по имени алгоритма из конфига
+func NewPasswordsFor(algorithm string) (*Passwords, error) {
+	switch algorithm {
+	case "", AlgorithmArgon2id:
+		return NewPasswords(NewArgon2idHasher(DefaultArgon2idParams)), nil
+	case AlgorithmBcrypt:
+		return NewPasswords(NewBcryptHasher(bcrypt.DefaultCost)), nil
+	default:
+		return nil, fmt.Errorf("%w: %q", ErrUnknownAlgorithm, algorithm)
+	}
+}
+
+func (p *Passwords) Hash(password string) (string, error) {
+	return p.current.Hash(password)
+}
+
+// Verify проверяет пароль. needsRehash = true, если пароль верный, но хэш нужно пересчитать
+// текущей схемой (другой алгоритм или устаревшие параметры)
+func (p *Passwords) Verify(password, encoded string) (ok bool, needsRehash bool, err error) {
+	for _, h := range p.known {
+		if !h.Recognizes(encoded) {
+			continue
+		}
+		ok, err := h.Verify(password, encoded)
+		if err != nil || !ok {
+			return false, false, err
+		}
+		// Хэш другой схемы всегда пересчитываем текущей
+		if h != p.current {
+			return true, true, nil
+		}
+		return true, h.NeedsRehash(encoded), nil
+	}
+	return false, false, ErrUnknownHashFormat
+}
+
+// VerifyUnknown проверяет пароль для несуществующего логина по фиксированному хэшу текущей схемы.
+// Проверка занимает столько же, сколько у существующего пользователя, и по времени ответа нельзя понять,
+// есть ли такой логин. Результат всегда false
+func (p *Passwords) VerifyUnknown(password string) bool {
+	p.current.Verify(password, p.dummyHash)
+	return false
+}
+
+// Argon2idParams - параметры argon2id. Memory задается в KiB
+type Argon2idParams struct {
+	Memory  uint32
+	Time    uint32
+	Threads uint8
+	SaltLen uint32
+	KeyLen  uint32
+}
+
+// DefaultArgon2idParams - минимальные параметры, рекомендованные OWASP (19 MiB, 2 итерации, 1 поток)
+var DefaultArgon2idParams = Argon2idParams{
+	Memory:  19 * 1024,
+	Time:    2,
+	Threads: 1,
+	SaltLen: 16,
+	KeyLen:  32,
+}
+
+// Argon2idHasher хранит хэши в PHC-формате: $argon2id$v=19$m=...,t=...,p=...$<salt>$<hash>
+type Argon2idHasher struct {
+	params Argon2idParams
+}
+
+func NewArgon2idHasher(params Argon2idParams) *Argon2idHasher {
+	return &Argon2idHasher{params: params}
+}
+
+func (a *Argon2idHasher) Hash(password string) (string, error) {
+	salt := make([]byte, a.params.SaltLen)
+	if _, err := rand.Read(salt); err != nil {
+		return "", fmt.Errorf("failed to generate salt: %w", err)
+	}
+	key := argon2.IDKey([]byte(password), salt, a.params.Time, a.params.Memory, a.params.Threads, a.params.KeyLen)
+	return fmt.Sprintf("$argon2id$v=%d$m=%d,t=%d,p=%d$%s$%s",
+		argon2.Version, a.params.Memory, a.params.Time, a.params.Threads,
+		base64.RawStdEncoding.EncodeToString(salt),
+		base64.RawStdEncoding.EncodeToString(key),
+	), nil
+}
+
+func (a *Argon2idHasher) Recognizes(encoded string) bool {
+	return strings.HasPrefix(encoded, "$argon2id$")
+}
+
+func (a *Argon2idHasher) Verify(password, encoded string) (bool, error) {
+	params, salt, key, err := decodeArgon2id(encoded)
+	if err != nil {
+		return false, err
+	}
+	other := argon2.IDKey([]byte(password), salt, params.Time, params.Memory, params.Threads, uint32(len(key)))
+	return subtle.ConstantTimeCompare(key, other) == 1, nil
+}
+
+func (a *Argon2idHasher) NeedsRehash(encoded string) bool {
+	params, salt, key, err := decodeArgon2id(encoded)
+	if err != nil {
+		return true
+	}
+	return params.Memory != a.params.Memory ||
+		params.Time != a.params.Time ||
+		params.Threads != a.params.Threads ||
+		uint32(len(salt)) != a.params.SaltLen ||
+		uint32(len(key)) != a.params.KeyLen
+}
+
+func decodeArgon2id(encoded string) (Argon2idParams, []byte, []byte, error) {
+	var params Argon2idParams
+	parts := strings.Split(encoded, "$")
+	if len(parts) != 6 || parts[1] != "argon2id" {
+		return params, nil, nil, ErrUnknownHashFormat
+	}
+	var version int
+	if _, err := fmt.Sscanf(parts[2], "v=%d", &version); err != nil || version != argon2.Version {
+		return params, nil, nil, fmt.Errorf("unsupported argon2 version %q", parts[2])
+	}
+	if _, err := fmt.Sscanf(parts[3], "m=%d,t=%d,p=%d", &params.Memory, &params.Time, &params.Threads); err != nil {
+		return params, nil, nil, fmt.Errorf("bad argon2 params: %w", err)
+	}
+	salt, err := base64.RawStdEncoding.DecodeString(parts[4])
+	if err != nil {
+		return params, nil, nil, fmt.Errorf("bad argon2 salt: %w", err)
+	}
+	key, err := base64.RawStdEncoding.DecodeString(parts[5])
+	if err != nil {
+		return params, nil, nil, fmt.Errorf("bad argon2 hash: %w", err)
+	}
+	params.SaltLen = uint32(len(salt))
+	params.KeyLen = uint32(len(key))
+	return params, salt, key, nil
+}
+
+// BcryptHasher хранит хэши в стандартном формате bcrypt ($2a$<cost>$...)
+type BcryptHasher struct {
+	cost int
+}
+
+func NewBcryptHasher(cost int) *BcryptHasher {
+	return &BcryptHasher{cost: cost}
+}
+
+func (b *BcryptHasher) Hash(password string) (string, error) {
+	hash, err := bcrypt.GenerateFromPassword([]byte(password), b.cost)
+	if err != nil {
+		return "", fmt.Errorf("failed to hash password: %w", err)
+	}
+	return string(hash), nil
+}
+
+func (b *BcryptHasher) Recognizes(encoded string) bool {
+	return strings.HasPrefix(encoded, "$2a$") || strings.HasPrefix(encoded, "$2b$") || strings.HasPrefix(encoded, "$2y$")
+}
+
+func (b *BcryptHasher) Verify(password, encoded string) (bool, error) {
+	err := bcrypt.CompareHashAndPassword([]byte(encoded), []byte(password))
+	if errors.Is(err, bcrypt.ErrMismatchedHashAndPassword) {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+func (b *BcryptHasher) NeedsRehash(encoded string) bool {
+	cost, err := bcrypt.Cost([]byte(encoded))
+	return err != nil || cost != b.cost
+}
+
+// legacySHA256Hasher проверяет старые несоленые SHA-256 хэши (hex). Новые хэши в этом формате не создаются
+type legacySHA256Hasher struct{}
+
+func (legacySHA256Hasher) Hash(string) (string, error) {
+	return "", errors.New("legacy sha256 hashing is not supported")
+}
+
+func (legacySHA256Hasher) Recognizes(encoded string) bool {
+	if len(encoded) != sha256.Size*2 {
+		return false
+	}
+	_, err := hex.DecodeString(encoded)
+	return err == nil
+}
+
+func (legacySHA256Hasher) Verify(password, encoded string) (bool, error) {
+	sum := sha256.Sum256([]byte(password))
+	return subtle.ConstantTimeCompare([]byte(hex.EncodeToString(sum[:])), []byte(encoded)) == 1, nil
+}
+
+func (legacySHA256Hasher) NeedsRehash(string) bool {
+	return true
+}
@@ -0,0 +1,111 @@
+package auth
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/crypto/bcrypt"
+)
+
+func TestPasswords(t *testing.T) {
+	passwords, err := NewPasswordsFor(AlgorithmArgon2id)
+	require.NoError(t, err)
+
+	t.Run("argon2id round trip with per-user salt", func(t *testing.T) {
+		first, err := passwords.Hash("secret")
+		require.NoError(t, err)
+		second, err := passwords.Hash("secret")
+		require.NoError(t, err)
+		assert.True(t, strings.HasPrefix(first, "$argon2id$v=19$m=19456,t=2,p=1$"))
+		assert.NotEqual(t, first, second)
+
+		ok, needsRehash, err := passwords.Verify("secret", first)
+		require.NoError(t, err)
+		assert.True(t, ok)
+		assert.False(t, needsRehash)
+
+		ok, _, err = passwords.Verify("wrong", first)
+		require.NoError(t, err)
+		assert.False(t, ok)
+	})
+
+	t.Run("legacy sha256 needs rehash", func(t *testing.T) {
+		sum := sha256.Sum256([]byte("secret"))
+		legacy := hex.EncodeToString(sum[:])
+
+		ok, needsRehash, err := passwords.Verify("secret", legacy)
+		require.NoError(t, err)
+		assert.True(t, ok)
+		assert.True(t, needsRehash)
+
+		ok, needsRehash, err = passwords.Verify("wrong", legacy)
+		require.NoError(t, err)
+		assert.False(t, ok)
+		assert.False(t, needsRehash)
+	})
+
+	t.Run("bcrypt hash is upgraded to argon2id", func(t *testing.T) {
+		hash, err := NewBcryptHasher(bcrypt.MinCost).Hash("secret")
+		require.NoError(t, err)
+
+		ok, needsRehash, err := passwords.Verify("secret", hash)
+		require.NoError(t, err)
+		assert.True(t, ok)
+		assert.True(t, needsRehash)
+	})
+
+	t.Run("argon2id with old params needs rehash", func(t *testing.T) {
+		weak := DefaultArgon2idParams
+		weak.Time = 1
+		hash, err := NewArgon2idHasher(weak).Hash("secret")
+		require.NoError(t, err)
+
+		ok, needsRehash, err := passwords.Verify("secret", hash)
+		require.NoError(t, err)
+		assert.True(t, ok)
+		assert.True(t, needsRehash)
+	})
+
+	t.Run("bcrypt as current algorithm", func(t *testing.T) {
+		bcryptPasswords, err := NewPasswordsFor(AlgorithmBcrypt)
+		require.NoError(t, err)
+		hash, err := bcryptPasswords.Hash("secret")
+		require.NoError(t, err)
+
+		ok, needsRehash, err := bcryptPasswords.Verify("secret", hash)
+		require.NoError(t, err)
+		assert.True(t, ok)
+		assert.False(t, needsRehash)
+	})
+
+	t.Run("unknown login is checked against the current scheme", func(t *testing.T) {
+		h := &countingHasher{Hasher: NewBcryptHasher(bcrypt.MinCost)}
+		counted := NewPasswords(h)
+		assert.False(t, counted.VerifyUnknown("secret"))
+		assert.False(t, counted.VerifyUnknown("gophermart-unknown-login"))
+		assert.Equal(t, 2, h.verified)
+	})
+
+	t.Run("unknown format and algorithm", func(t *testing.T) {
+		_, _, err := passwords.Verify("secret", "plain-text")
+		assert.ErrorIs(t, err, ErrUnknownHashFormat)
+
+		_, err = NewPasswordsFor("md5")
+		assert.ErrorIs(t, err, ErrUnknownAlgorithm)
+	})
+}
+
+// countingHasher считает проверки пароля
+type countingHasher struct {
+	Hasher
+	verified int
+}
+
+func (c *countingHasher) Verify(password, encoded string) (bool, error) {
+	c.verified++
+	return c.Hasher.Verify(password, encoded)
+}
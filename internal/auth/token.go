@@ -0,0 +1,105 @@
+package auth
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+// CookieName - имя куки, в которой клиент хранит токен авторизации
+const CookieName = "auth_token"
+
+// TokenIssuer - значение claim iss во всех токенах, выпущенных сервисом
+const TokenIssuer = "gophermart"
+
+var (
+	ErrTokenExpired = errors.New("token expired")
+	ErrTokenInvalid = errors.New("invalid token")
+
+	ErrSessionNotFound = errors.New("session not found")
+	ErrSessionRevoked  = errors.New("session revoked")
+	ErrSessionExpired  = errors.New("session expired")
+)
+
+// TokenClaims - данные, которые сервис достает из проверенного токена
+type TokenClaims struct {
+	UserID    int
+	SessionID string
+}
+
+// TokenManager выпускает и проверяет подписанные (HS256) токены авторизации
+type TokenManager struct {
+	secret []byte
+	ttl    time.Duration
+}
+
+func NewTokenManager(secret []byte, ttl time.Duration) *TokenManager {
+	return &TokenManager{
+		secret: secret,
+		ttl:    ttl,
+	}
+}
+
+// TTL возвращает время жизни выпускаемых токенов
+func (m *TokenManager) TTL() time.Duration {
+	return m.ttl
+}
+
+// BuildToken создает подписанный токен, в subject которого лежит ID пользователя, а в jti - ID сессии
+func (m *TokenManager) BuildToken(userID int, sessionID string) (string, time.Time, error) {
+	now := time.Now()
+	expiresAt := now.Add(m.ttl)
+	claims := jwt.RegisteredClaims{
+		Issuer:    TokenIssuer,
+		Subject:   strconv.Itoa(userID),
+		ID:        sessionID,
+		IssuedAt:  jwt.NewNumericDate(now),
+		ExpiresAt: jwt.NewNumericDate(expiresAt),
+	}
+	token, err := jwt.NewWithClaims(jwt.SigningMethodHS256, claims).SignedString(m.secret)
+	if err != nil {
+		return "", time.Time{}, fmt.Errorf("failed to sign token: %w", err)
+	}
+	return token, expiresAt, nil
+}
+
+// ParseToken проверяет подпись, срок действия, issuer и subject токена и возвращает ID пользователя и сессии.
+// Просроченный токен дает ErrTokenExpired, любой другой невалидный - ErrTokenInvalid
+func (m *TokenManager) ParseToken(tokenString string) (TokenClaims, error) {
+	claims := &jwt.RegisteredClaims{}
+	_, err := jwt.ParseWithClaims(tokenString, claims, func(t *jwt.Token) (interface{}, error) {
+		return m.secret, nil
+	},
+		jwt.WithValidMethods([]string{jwt.SigningMethodHS256.Alg()}),
+		jwt.WithIssuer(TokenIssuer),
+		jwt.WithExpirationRequired(),
+	)
+	if err != nil {
+		if errors.Is(err, jwt.ErrTokenExpired) {
+			return TokenClaims{}, ErrTokenExpired
+		}
+		return TokenClaims{}, fmt.Errorf("%w: %v", ErrTokenInvalid, err)
+	}
+	userID, err := strconv.Atoi(claims.Subject)
+	if err != nil || userID <= 0 {
+		return TokenClaims{}, fmt.Errorf("%w: bad subject %q", ErrTokenInvalid, claims.Subject)
+	}
+	if claims.ID == "" {
+		return TokenClaims{}, fmt.Errorf("%w: missing session id", ErrTokenInvalid)
+	}
+	return TokenClaims{UserID: userID, SessionID: claims.ID}, nil
+}
+
+// NewSessionID генерирует случайный идентификатор сессии
+func NewSessionID() (string, error) {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return "", fmt.Errorf("failed to generate session id: %w", err)
+	}
+	return hex.EncodeToString(b), nil
+}
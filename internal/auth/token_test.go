@@ -0,0 +1,69 @@
+package auth
+
+import (
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestTokenManager(t *testing.T) {
+	tokens := NewTokenManager([]byte("secret"), time.Hour)
+
+	t.Run("round trip", func(t *testing.T) {
+		token, expiresAt, err := tokens.BuildToken(42, "session")
+		require.NoError(t, err)
+		assert.WithinDuration(t, time.Now().Add(time.Hour), expiresAt, time.Minute)
+
+		claims, err := tokens.ParseToken(token)
+		require.NoError(t, err)
+		assert.Equal(t, TokenClaims{UserID: 42, SessionID: "session"}, claims)
+	})
+
+	t.Run("expired token", func(t *testing.T) {
+		expired := NewTokenManager([]byte("secret"), -time.Minute)
+		token, _, err := expired.BuildToken(42, "session")
+		require.NoError(t, err)
+
+		_, err = tokens.ParseToken(token)
+		assert.ErrorIs(t, err, ErrTokenExpired)
+	})
+
+	t.Run("forged signature", func(t *testing.T) {
+		forger := NewTokenManager([]byte("another secret"), time.Hour)
+		token, _, err := forger.BuildToken(42, "session")
+		require.NoError(t, err)
+
+		_, err = tokens.ParseToken(token)
+		assert.ErrorIs(t, err, ErrTokenInvalid)
+	})
+
+	t.Run("raw user id", func(t *testing.T) {
+		_, err := tokens.ParseToken("42")
+		assert.ErrorIs(t, err, ErrTokenInvalid)
+	})
+
+	t.Run("missing session id", func(t *testing.T) {
+		token, _, err := tokens.BuildToken(42, "")
+		require.NoError(t, err)
+
+		_, err = tokens.ParseToken(token)
+		assert.ErrorIs(t, err, ErrTokenInvalid)
+	})
+
+	t.Run("wrong issuer", func(t *testing.T) {
+		claims := jwt.RegisteredClaims{
+			ID:        "session",
+			Issuer:    "someone-else",
+			Subject:   "42",
+			ExpiresAt: jwt.NewNumericDate(time.Now().Add(time.Hour)),
+		}
+		token, err := jwt.NewWithClaims(jwt.SigningMethodHS256, claims).SignedString([]byte("secret"))
+		require.NoError(t, err)
+
+		_, err = tokens.ParseToken(token)
+		assert.ErrorIs(t, err, ErrTokenInvalid)
+	})
+}
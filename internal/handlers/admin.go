@@ -0,0 +1,87 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+
+	"github.com/NailUsmanov/gophermart/internal/service"
+	"github.com/NailUsmanov/gophermart/internal/storage"
+	"github.com/go-chi/chi"
+	"go.uber.org/zap"
+)
+
+// defaultAdminLimit - сколько записей отдают админские списки без ?limit
+const defaultAdminLimit = 100
+
+// OrderRequeuer возвращает застрявший заказ в опрос
+type OrderRequeuer interface {
+	Requeue(ctx context.Context, number string) error
+}
+
+// adminLimit читает ?limit: по умолчанию defaultAdminLimit, не больше 1000
+func adminLimit(r *http.Request) (int, bool) {
+	v := r.URL.Query().Get("limit")
+	if v == "" {
+		return defaultAdminLimit, true
+	}
+	limit, err := strconv.Atoi(v)
+	if err != nil || limit <= 0 || limit > 1000 {
+		return 0, false
+	}
+	return limit, true
+}
+
+func writeJSON(w http.ResponseWriter, sugar *zap.SugaredLogger, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	if err := json.NewEncoder(w).Encode(v); err != nil {
+		sugar.Errorf("error encoding response: %v", err)
+	}
+}
+
+func StuckOrders(s storage.StuckOrderLister, sugar *zap.SugaredLogger) http.HandlerFunc {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		limit, ok := adminLimit(r)
+		if !ok {
+			writeError(w, r, sugar, &service.FieldError{Field: "limit", Message: "must be between 1 and 1000"})
+			return
+		}
+		orders, err := s.ListStuckOrders(r.Context(), limit)
+		if err != nil {
+			writeError(w, r, sugar, err)
+			return
+		}
+		writeJSON(w, sugar, orders)
+	})
+}
+
+func RequeueOrder(s OrderRequeuer, sugar *zap.SugaredLogger) http.HandlerFunc {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		number := chi.URLParam(r, "number")
+		err := s.Requeue(r.Context(), number)
+		if err != nil {
+			writeError(w, r, sugar, fmt.Errorf("requeue order %s: %w", number, err))
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	})
+}
+
+func QuarantinedResponses(s storage.AccrualQuarantine, sugar *zap.SugaredLogger) http.HandlerFunc {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		limit, ok := adminLimit(r)
+		if !ok {
+			writeError(w, r, sugar, &service.FieldError{Field: "limit", Message: "must be between 1 and 1000"})
+			return
+		}
+		responses, err := s.ListQuarantinedResponses(r.Context(), limit)
+		if err != nil {
+			writeError(w, r, sugar, err)
+			return
+		}
+		writeJSON(w, sugar, responses)
+	})
+}
@@ -7,6 +7,9 @@ import (
 
 	"github.com/NailUsmanov/gophermart/internal/middleware"
 	"github.com/NailUsmanov/gophermart/internal/models"
+	"github.com/NailUsmanov/gophermart/internal/money"
+	"github.com/NailUsmanov/gophermart/internal/problem"
+	"github.com/NailUsmanov/gophermart/internal/service"
 	"github.com/NailUsmanov/gophermart/internal/storage"
 	"github.com/NailUsmanov/gophermart/internal/validation"
 	"go.uber.org/zap"
@@ -18,17 +21,15 @@ func UserBalance(s storage.BalanceIndicator, sugar *zap.SugaredLogger) http.Hand
 
 		// Достаем номер пользователя из контекста через куки аутентификации
 		userID, ok := r.Context().Value(middleware.UserLoginKey).(int)
-		sugar.Infof("DEBUG: userID from context = %d (ok=%v)", userID, ok)
 		if !ok {
-			http.Error(w, "Unauthorized", http.StatusUnauthorized)
+			unauthorized(w, r, sugar)
 			return
 		}
 
 		// Используем метод GetUserBalance чтобы получить сумму баллов
 		current, withdrawn, err := s.GetUserBalance(r.Context(), userID)
 		if err != nil {
-			sugar.Errorf("Failed check user balance: %v", err)
-			http.Error(w, "Internal server error", http.StatusInternalServerError)
+			writeError(w, r, sugar, err)
 			return
 		}
 
@@ -42,8 +43,8 @@ func UserBalance(s storage.BalanceIndicator, sugar *zap.SugaredLogger) http.Hand
 		w.WriteHeader(http.StatusOK)
 		enc := json.NewEncoder(w)
 		if err := enc.Encode(balance); err != nil {
+			// Статус уже отправлен, поэтому ошибку можно только залогировать
 			sugar.Errorf("error encoding response: %v", err)
-			http.Error(w, "error with encoding response", http.StatusInternalServerError)
 			return
 		}
 	})
@@ -54,14 +55,13 @@ func WithDraw(s storage.WithdrawLogic, sugar *zap.SugaredLogger, v validation.Or
 		sugar.Infof(">>> WithDraw endpoint called")
 		// Проверяем авторизацию пользователя
 		userID, ok := r.Context().Value(middleware.UserLoginKey).(int)
-		sugar.Infof("DEBUG: userID from context = %d (ok=%v)", userID, ok)
 		if !ok {
-			http.Error(w, "Unauthorized", http.StatusUnauthorized)
+			unauthorized(w, r, sugar)
 			return
 		}
 		// Проверка Content-Type
 		if r.Header.Get("Content-Type") != "application/json" {
-			http.Error(w, "Content-Type must be application/json", http.StatusBadRequest)
+			invalidContentType(w, r, sugar, "application/json")
 			return
 		}
 
@@ -69,7 +69,18 @@ func WithDraw(s storage.WithdrawLogic, sugar *zap.SugaredLogger, v validation.Or
 		var withDraw models.WithDrawRequest
 		if err := json.NewDecoder(r.Body).Decode(&withDraw); err != nil {
 			sugar.Error("cannot decode request JSON body:", err)
-			http.Error(w, "Invalid JSON format", http.StatusBadRequest)
+			// Сумму с точностью больше двух знаков не округляем, а отклоняем
+			if errors.Is(err, money.ErrTooPrecise) {
+				writeProblem(w, r, sugar, problem.New(http.StatusUnprocessableEntity, problem.CodeInvalidAmount, "sum is invalid").
+					WithField("sum", "must have at most two decimal places"))
+				return
+			}
+			invalidBody(w, r, sugar, "invalid JSON format")
+			return
+		}
+		if withDraw.Sum <= 0 {
+			writeProblem(w, r, sugar, problem.New(http.StatusUnprocessableEntity, problem.CodeInvalidAmount, "sum is invalid").
+				WithField("sum", "must be positive"))
 			return
 		}
 
@@ -78,7 +89,8 @@ func WithDraw(s storage.WithdrawLogic, sugar *zap.SugaredLogger, v validation.Or
 		IsValid := v.IsValidLuhn(withDraw.NumberOrder)
 		sugar.Infof("passed Luhn: %v", IsValid)
 		if !IsValid {
-			http.Error(w, "Invalid order number", http.StatusUnprocessableEntity)
+			writeProblem(w, r, sugar, problem.New(http.StatusUnprocessableEntity, problem.CodeInvalidOrderNumber, "order number is invalid").
+				WithField("order", "must pass the Luhn check"))
 			return
 		}
 
@@ -89,20 +101,9 @@ func WithDraw(s storage.WithdrawLogic, sugar *zap.SugaredLogger, v validation.Or
 			w.WriteHeader(http.StatusOK)
 			return
 		}
-		// Отлавливаем возможные ошибки
-		switch {
-		case errors.Is(err, storage.ErrOrderAlreadyUsed):
-			sugar.Infof("Order number already used: %v", storage.ErrOrderAlreadyUsed)
-			http.Error(w, "Order number already used", http.StatusConflict)
-		case errors.Is(err, storage.ErrNotEnoughFunds):
-			sugar.Infof("Not enough funds: %v", storage.ErrNotEnoughFunds)
-			http.Error(w, "Not enough funds", http.StatusPaymentRequired)
-			return
-		default:
-			sugar.Infof("AddWithdrawOrder failed: %v", err)
-			http.Error(w, "Internal server error", http.StatusInternalServerError)
-			return
-		}
+		// Занятый номер - 409, нехватка баллов - 402, остальное - 500
+		sugar.Infof("AddWithdrawOrder failed: %v", err)
+		writeError(w, r, sugar, err)
 	})
 }
 
@@ -113,15 +114,20 @@ func AllUserWithDrawals(s storage.WithdrawalFetcher, sugar *zap.SugaredLogger) h
 		// Извлекаем Юзера из контекста через куки
 		userID, ok := r.Context().Value(middleware.UserLoginKey).(int)
 		if !ok {
-			http.Error(w, "Unauthorized", http.StatusUnauthorized)
+			unauthorized(w, r, sugar)
+			return
+		}
+
+		// С параметрами - постраничный ответ с итогом, без них - весь список, как раньше
+		if hasAnyParam(r, withdrawalsQueryParams) {
+			listUserWithdrawals(w, r, s, sugar)
 			return
 		}
 
 		// Получаю все данные по списаниям конкретного пользователя через метод GetAllUserWithdrawals
 		withdrawals, err := s.GetAllUserWithdrawals(r.Context(), userID)
 		if err != nil {
-			sugar.Errorf("GetOrdersByUserID failed: %v", err)
-			http.Error(w, "Method GetAllUserWithdrawals has err", http.StatusInternalServerError)
+			writeError(w, r, sugar, err)
 			return
 		}
 		// Если нет заказов возвращаем 204 No Content
@@ -134,9 +140,65 @@ func AllUserWithDrawals(s storage.WithdrawalFetcher, sugar *zap.SugaredLogger) h
 		w.WriteHeader(http.StatusOK)
 		enc := json.NewEncoder(w)
 		if err := enc.Encode(withdrawals); err != nil {
-			sugar.Error("error encoding response")
-			http.Error(w, "error with encoding reponse", http.StatusInternalServerError)
+			// Статус уже отправлен, поэтому ошибку можно только залогировать
+			sugar.Errorf("error encoding response: %v", err)
 			return
 		}
 	})
 }
+
+// withdrawalsQueryParams - параметры постраничного списка списаний
+var withdrawalsQueryParams = []string{"limit", "cursor", "from", "to", "min_sum", "max_sum"}
+
+// listUserWithdrawals отвечает страницей списаний с итогом по выборке:
+// {"withdrawals": [...], "next_cursor": "...", "total": 1500.50, "count": 12}
+func listUserWithdrawals(w http.ResponseWriter, r *http.Request, s storage.WithdrawalFetcher, sugar *zap.SugaredLogger) {
+	q, err := parseWithdrawalsQuery(r)
+	if err != nil {
+		writeError(w, r, sugar, err)
+		return
+	}
+	page, err := service.NewWithdrawals(s).List(r.Context(), q)
+	if err != nil {
+		writeError(w, r, sugar, err)
+		return
+	}
+	if page.NextCursor != "" {
+		w.Header().Set("Link", nextPageLink(r, page.NextCursor))
+	}
+	writeJSON(w, sugar, page)
+}
+
+func parseWithdrawalsQuery(r *http.Request) (service.WithdrawalsQuery, error) {
+	values := r.URL.Query()
+	q := service.WithdrawalsQuery{Cursor: values.Get("cursor")}
+	var err error
+	if q.Limit, err = parseLimitParam(values.Get("limit")); err != nil {
+		return q, err
+	}
+	if q.From, err = parseTimeParam(values.Get("from")); err != nil {
+		return q, paramError("from", err)
+	}
+	if q.To, err = parseTimeParam(values.Get("to")); err != nil {
+		return q, paramError("to", err)
+	}
+	if q.MinSum, err = parseSumParam(values.Get("min_sum")); err != nil {
+		return q, paramError("min_sum", err)
+	}
+	if q.MaxSum, err = parseSumParam(values.Get("max_sum")); err != nil {
+		return q, paramError("max_sum", err)
+	}
+	return q, nil
+}
+
+// parseSumParam - сумма с точностью до копеек; пустая строка - nil
+func parseSumParam(v string) (*money.Amount, error) {
+	if v == "" {
+		return nil, nil
+	}
+	sum, err := money.Parse(v)
+	if err != nil {
+		return nil, err
+	}
+	return &sum, nil
+}
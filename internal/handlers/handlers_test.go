@@ -2,19 +2,29 @@ package handlers
 
 import (
 	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
 	"net/http"
 	"net/http/httptest"
 	"strings"
 	"testing"
 	"time"
 
+	"github.com/NailUsmanov/gophermart/internal/auth"
 	"github.com/NailUsmanov/gophermart/internal/middleware"
 	"github.com/NailUsmanov/gophermart/internal/mocks"
 	"github.com/NailUsmanov/gophermart/internal/models"
+	"github.com/NailUsmanov/gophermart/internal/money"
+	"github.com/NailUsmanov/gophermart/internal/problem"
 	"github.com/NailUsmanov/gophermart/internal/service"
 	"github.com/NailUsmanov/gophermart/internal/storage"
 	"github.com/NailUsmanov/gophermart/internal/validation"
+	"github.com/NailUsmanov/gophermart/internal/worker"
 	"github.com/go-chi/chi"
+	chimw "github.com/go-chi/chi/middleware"
 	"github.com/stretchr/testify/assert"
 	"go.uber.org/mock/gomock"
 	"go.uber.org/zap"
@@ -187,6 +197,60 @@ func TestGetUserOrders(t *testing.T) {
 		// Проверяем Content-Type
 		assert.Equal(t, "", w.Header().Get("Content-Type"))
 	})
+
+	t.Run("paginated test", func(t *testing.T) {
+		ctrl := gomock.NewController(t)
+		defer ctrl.Finish()
+		mockServ := mocks.NewMockServiceStorage(ctrl)
+
+		from := time.Date(2025, 6, 1, 0, 0, 0, 0, time.UTC)
+		mockServ.EXPECT().ListUserOrders(gomock.Any(), 1, gomock.Any()).
+			DoAndReturn(func(_ context.Context, _ int, f storage.OrderFilter) (storage.OrderPage, error) {
+				assert.Equal(t, 1, f.Limit)
+				assert.Equal(t, []string{"NEW"}, f.Statuses)
+				assert.True(t, from.Equal(f.From))
+				return storage.OrderPage{
+					Orders: []storage.Order{{Number: "1", Status: ptr("NEW"), UploadedAt: uploaded}},
+					Next:   &storage.OrderCursor{UploadedAt: uploaded, ID: 7},
+				}, nil
+			})
+
+		r := chi.NewRouter()
+		r.Use(FakeAuthMiddleWare)
+		r.Get("/api/user/orders", GetUserOrders(mockServ, logger, validator))
+		req := httptest.NewRequest(http.MethodGet, "/api/user/orders?limit=1&status=NEW&from=2025-06-01", nil)
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, req)
+
+		assert.Equal(t, http.StatusOK, w.Code)
+		var body struct {
+			Orders     []storage.Order `json:"orders"`
+			NextCursor string          `json:"next_cursor"`
+		}
+		assert.NoError(t, json.NewDecoder(w.Body).Decode(&body))
+		assert.Len(t, body.Orders, 1)
+		assert.NotEmpty(t, body.NextCursor)
+		link := w.Header().Get("Link")
+		assert.Contains(t, link, "cursor="+body.NextCursor)
+		assert.Contains(t, link, "status=NEW")
+		assert.Contains(t, link, `rel="next"`)
+	})
+
+	t.Run("invalid query test", func(t *testing.T) {
+		ctrl := gomock.NewController(t)
+		defer ctrl.Finish()
+		mockServ := mocks.NewMockServiceStorage(ctrl)
+
+		r := chi.NewRouter()
+		r.Use(FakeAuthMiddleWare)
+		r.Get("/api/user/orders", GetUserOrders(mockServ, logger, validator))
+		for _, query := range []string{"limit=abc", "limit=0", "status=STUCK", "from=yesterday", "cursor=%21%21"} {
+			req := httptest.NewRequest(http.MethodGet, "/api/user/orders?"+query, nil)
+			w := httptest.NewRecorder()
+			r.ServeHTTP(w, req)
+			assert.Equal(t, http.StatusBadRequest, w.Code, query)
+		}
+	})
 }
 
 // Для проверки хендлеров с балансом
@@ -199,7 +263,7 @@ func TestUserBalance(t *testing.T) {
 
 		mockServ := mocks.NewMockBalanceIndicator(ctrl)
 
-		mockServ.EXPECT().GetUserBalance(gomock.Any(), 1).Return(100.00, 0.00, nil)
+		mockServ.EXPECT().GetUserBalance(gomock.Any(), 1).Return(money.Amount(10000), money.Amount(0), nil)
 
 		r := chi.NewRouter()
 		r.Use(FakeAuthMiddleWare)
@@ -221,7 +285,7 @@ func TestUserBalance(t *testing.T) {
 
 		mockServ := mocks.NewMockBalanceIndicator(ctrl)
 
-		mockServ.EXPECT().GetUserBalance(gomock.Any(), 1).Return(0.00, 0.00, service.ErrInternal)
+		mockServ.EXPECT().GetUserBalance(gomock.Any(), 1).Return(money.Amount(0), money.Amount(0), service.ErrInternal)
 
 		r := chi.NewRouter()
 		r.Use(FakeAuthMiddleWare)
@@ -234,8 +298,16 @@ func TestUserBalance(t *testing.T) {
 
 		assert.Equal(t, http.StatusInternalServerError, w.Code)
 
-		// Проверяем Content-Type
-		assert.Equal(t, "text/plain; charset=utf-8", w.Header().Get("Content-Type"))
+		// Проверяем Content-Type и то, что текст внутренней ошибки не уходит клиенту
+		assert.Equal(t, problem.ContentType, w.Header().Get("Content-Type"))
+		assert.JSONEq(t, `{
+			"type": "urn:gophermart:problem:internal_error",
+			"title": "Internal Server Error",
+			"status": 500,
+			"detail": "internal server error",
+			"instance": "/api/user/balance",
+			"code": "internal_error"
+		}`, w.Body.String())
 	})
 }
 
@@ -244,7 +316,7 @@ func TestAllUserWithDrawals(t *testing.T) {
 	correctResult := []models.UserWithDraw{
 		{
 			NumberOrder: "1234567890",
-			Sum:         0.00,
+			Sum:         money.Amount(0),
 			ProcessedAt: time.Date(2025, 6, 20, 10, 0, 0, 0, time.UTC),
 		},
 	}
@@ -254,7 +326,7 @@ func TestAllUserWithDrawals(t *testing.T) {
 		ctrl := gomock.NewController(t)
 		defer ctrl.Finish()
 
-		mock := mocks.NewMockBalanceIndicator(ctrl)
+		mock := mocks.NewMockWithdrawalFetcher(ctrl)
 		mock.EXPECT().GetAllUserWithdrawals(gomock.Any(), 1).Return(correctResult, nil)
 
 		r := chi.NewRouter()
@@ -274,7 +346,7 @@ func TestAllUserWithDrawals(t *testing.T) {
 		ctrl := gomock.NewController(t)
 		defer ctrl.Finish()
 
-		mock := mocks.NewMockBalanceIndicator(ctrl)
+		mock := mocks.NewMockWithdrawalFetcher(ctrl)
 		mock.EXPECT().GetAllUserWithdrawals(gomock.Any(), 1).Return([]models.UserWithDraw{}, nil)
 
 		r := chi.NewRouter()
@@ -293,7 +365,7 @@ func TestAllUserWithDrawals(t *testing.T) {
 		ctrl := gomock.NewController(t)
 		defer ctrl.Finish()
 
-		mock := mocks.NewMockBalanceIndicator(ctrl)
+		mock := mocks.NewMockWithdrawalFetcher(ctrl)
 
 		r := chi.NewRouter() // Без FakeAuthMiddleWare — неавторизован
 		r.Get("/api/user/withdrawals", AllUserWithDrawals(mock, logger))
@@ -303,7 +375,455 @@ func TestAllUserWithDrawals(t *testing.T) {
 		r.ServeHTTP(w, req)
 
 		assert.Equal(t, http.StatusUnauthorized, w.Code)
-		assert.Equal(t, "Unauthorized\n", w.Body.String())
-		assert.Equal(t, "text/plain; charset=utf-8", w.Header().Get("Content-Type"))
+		assert.Equal(t, problem.ContentType, w.Header().Get("Content-Type"))
+		assert.JSONEq(t, `{
+			"type": "urn:gophermart:problem:unauthorized",
+			"title": "Unauthorized",
+			"status": 401,
+			"detail": "authentication required",
+			"instance": "/api/user/withdrawals",
+			"code": "unauthorized"
+		}`, w.Body.String())
+	})
+
+	t.Run("filtered page with total", func(t *testing.T) {
+		ctrl := gomock.NewController(t)
+		defer ctrl.Finish()
+
+		mock := mocks.NewMockWithdrawalFetcher(ctrl)
+		mock.EXPECT().ListUserWithdrawals(gomock.Any(), 1, gomock.Any()).
+			DoAndReturn(func(_ context.Context, _ int, f storage.WithdrawalFilter) (storage.WithdrawalPage, error) {
+				assert.Equal(t, money.Amount(1050), *f.MinSum)
+				assert.Nil(t, f.MaxSum)
+				assert.Equal(t, time.Date(2025, 6, 1, 0, 0, 0, 0, time.UTC), f.From)
+				return storage.WithdrawalPage{Withdrawals: correctResult, Total: 2500, Count: 2}, nil
+			})
+
+		r := chi.NewRouter()
+		r.Use(FakeAuthMiddleWare)
+		r.Get("/api/user/withdrawals", AllUserWithDrawals(mock, logger))
+
+		req := httptest.NewRequest(http.MethodGet, "/api/user/withdrawals?from=2025-06-01&min_sum=10.5", nil)
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, req)
+
+		assert.Equal(t, http.StatusOK, w.Code)
+		assert.JSONEq(t, `{"withdrawals":`+correctBody+`,"total":25.00,"count":2}`, w.Body.String())
+		assert.Empty(t, w.Header().Get("Link"))
+	})
+
+	t.Run("invalid filters", func(t *testing.T) {
+		ctrl := gomock.NewController(t)
+		defer ctrl.Finish()
+
+		mock := mocks.NewMockWithdrawalFetcher(ctrl)
+		r := chi.NewRouter()
+		r.Use(FakeAuthMiddleWare)
+		r.Get("/api/user/withdrawals", AllUserWithDrawals(mock, logger))
+
+		for _, query := range []string{"min_sum=ten", "max_sum=1.005", "min_sum=5&max_sum=1", "to=2025-13-01"} {
+			req := httptest.NewRequest(http.MethodGet, "/api/user/withdrawals?"+query, nil)
+			w := httptest.NewRecorder()
+			r.ServeHTTP(w, req)
+			assert.Equal(t, http.StatusBadRequest, w.Code, query)
+		}
+	})
+}
+
+func TestLogin(t *testing.T) {
+	logger := zap.NewNop().Sugar()
+	tokens := auth.NewTokenManager([]byte("secret"), time.Hour)
+	passwords, err := auth.NewPasswordsFor(auth.AlgorithmArgon2id)
+	assert.NoError(t, err)
+	legacySum := sha256.Sum256([]byte("password"))
+	legacyHash := hex.EncodeToString(legacySum[:])
+	body := `{"login":"user","password":"password"}`
+
+	t.Run("legacy hash is upgraded on login", func(t *testing.T) {
+		ctrl := gomock.NewController(t)
+		defer ctrl.Finish()
+
+		mock := mocks.NewMockStorage(ctrl)
+		mock.EXPECT().GetUserByLogin(gomock.Any(), "user").Return(legacyHash, nil)
+		mock.EXPECT().UpdatePasswordHash(gomock.Any(), "user", gomock.Any()).DoAndReturn(
+			func(_ context.Context, _ string, newHash string) error {
+				ok, needsRehash, err := passwords.Verify("password", newHash)
+				assert.NoError(t, err)
+				assert.True(t, ok)
+				assert.False(t, needsRehash)
+				return nil
+			})
+		mock.EXPECT().GetUserIDByLogin(gomock.Any(), "user").Return(1, nil)
+		mock.EXPECT().CreateSession(gomock.Any(), 1, "test-agent", "192.0.2.1", time.Hour).Return("session-1", nil)
+
+		req := httptest.NewRequest(http.MethodPost, "/api/user/login", strings.NewReader(body))
+		req.Header.Set("Content-Type", "application/json")
+		req.Header.Set("User-Agent", "test-agent")
+		w := httptest.NewRecorder()
+		Login(mock, logger, tokens, passwords, time.Hour).ServeHTTP(w, req)
+
+		assert.Equal(t, http.StatusOK, w.Code)
+		cookies := w.Result().Cookies()
+		assert.Len(t, cookies, 1)
+		claims, err := tokens.ParseToken(cookies[0].Value)
+		assert.NoError(t, err)
+		assert.Equal(t, auth.TokenClaims{UserID: 1, SessionID: "session-1"}, claims)
+	})
+
+	t.Run("wrong password", func(t *testing.T) {
+		ctrl := gomock.NewController(t)
+		defer ctrl.Finish()
+
+		mock := mocks.NewMockStorage(ctrl)
+		mock.EXPECT().GetUserByLogin(gomock.Any(), "user").Return(legacyHash, nil)
+
+		req := httptest.NewRequest(http.MethodPost, "/api/user/login", strings.NewReader(`{"login":"user","password":"wrong"}`))
+		req.Header.Set("Content-Type", "application/json")
+		w := httptest.NewRecorder()
+		Login(mock, logger, tokens, passwords, time.Hour).ServeHTTP(w, req)
+
+		assert.Equal(t, http.StatusUnauthorized, w.Code)
+	})
+
+	t.Run("unknown login", func(t *testing.T) {
+		ctrl := gomock.NewController(t)
+		defer ctrl.Finish()
+
+		mock := mocks.NewMockStorage(ctrl)
+		mock.EXPECT().GetUserByLogin(gomock.Any(), "user").Return("", nil)
+
+		req := httptest.NewRequest(http.MethodPost, "/api/user/login", strings.NewReader(body))
+		req.Header.Set("Content-Type", "application/json")
+		w := httptest.NewRecorder()
+		Login(mock, logger, tokens, passwords, time.Hour).ServeHTTP(w, req)
+
+		assert.Equal(t, http.StatusUnauthorized, w.Code)
+		assert.Contains(t, w.Body.String(), problem.CodeInvalidCredentials)
+	})
+}
+
+func FakeSessionMiddleWare(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ctx := context.WithValue(r.Context(), middleware.UserLoginKey, 1)
+		ctx = context.WithValue(ctx, middleware.SessionIDKey, "current")
+		next.ServeHTTP(w, r.WithContext(ctx))
+	})
+}
+
+func TestSessions(t *testing.T) {
+	logger := zap.NewNop().Sugar()
+	created := time.Date(2025, 6, 20, 10, 0, 0, 0, time.UTC)
+
+	t.Run("logout revokes current session", func(t *testing.T) {
+		ctrl := gomock.NewController(t)
+		defer ctrl.Finish()
+
+		mock := mocks.NewMockStorage(ctrl)
+		mock.EXPECT().RevokeSession(gomock.Any(), 1, "current").Return(nil)
+
+		r := chi.NewRouter()
+		r.Use(FakeSessionMiddleWare)
+		r.Post("/api/user/logout", Logout(mock, logger))
+
+		req := httptest.NewRequest(http.MethodPost, "/api/user/logout", nil)
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, req)
+
+		assert.Equal(t, http.StatusOK, w.Code)
+		cookies := w.Result().Cookies()
+		assert.Len(t, cookies, 1)
+		assert.Equal(t, auth.CookieName, cookies[0].Name)
+		assert.Equal(t, -1, cookies[0].MaxAge)
+	})
+
+	t.Run("list marks current session", func(t *testing.T) {
+		ctrl := gomock.NewController(t)
+		defer ctrl.Finish()
+
+		mock := mocks.NewMockStorage(ctrl)
+		mock.EXPECT().ListSessions(gomock.Any(), 1).Return([]models.Session{
+			{ID: "current", UserAgent: "curl", IP: "192.0.2.1", CreatedAt: created, LastSeenAt: created, ExpiresAt: created},
+			{ID: "other", UserAgent: "phone", IP: "192.0.2.2", CreatedAt: created, LastSeenAt: created, ExpiresAt: created},
+		}, nil)
+
+		r := chi.NewRouter()
+		r.Use(FakeSessionMiddleWare)
+		r.Get("/api/user/sessions", UserSessions(mock, logger))
+
+		req := httptest.NewRequest(http.MethodGet, "/api/user/sessions", nil)
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, req)
+
+		assert.Equal(t, http.StatusOK, w.Code)
+		assert.JSONEq(t, `[
+			{"id":"current","device":"curl","ip":"192.0.2.1","created_at":"2025-06-20T10:00:00Z","last_seen_at":"2025-06-20T10:00:00Z","expires_at":"2025-06-20T10:00:00Z","current":true},
+			{"id":"other","device":"phone","ip":"192.0.2.2","created_at":"2025-06-20T10:00:00Z","last_seen_at":"2025-06-20T10:00:00Z","expires_at":"2025-06-20T10:00:00Z","current":false}
+		]`, w.Body.String())
+	})
+
+	t.Run("revoke unknown session", func(t *testing.T) {
+		ctrl := gomock.NewController(t)
+		defer ctrl.Finish()
+
+		mock := mocks.NewMockStorage(ctrl)
+		mock.EXPECT().RevokeSession(gomock.Any(), 1, "foreign").Return(auth.ErrSessionNotFound)
+		mock.EXPECT().RevokeSession(gomock.Any(), 1, "other").Return(nil)
+
+		r := chi.NewRouter()
+		r.Use(FakeSessionMiddleWare)
+		r.Delete("/api/user/sessions/{id}", RevokeUserSession(mock, logger))
+
+		req := httptest.NewRequest(http.MethodDelete, "/api/user/sessions/foreign", nil)
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, req)
+		assert.Equal(t, http.StatusNotFound, w.Code)
+
+		req = httptest.NewRequest(http.MethodDelete, "/api/user/sessions/other", nil)
+		w = httptest.NewRecorder()
+		r.ServeHTTP(w, req)
+		assert.Equal(t, http.StatusNoContent, w.Code)
+	})
+}
+
+func TestWithDraw(t *testing.T) {
+	logger := zap.NewNop().Sugar()
+	validator := &validation.LuhnValidation{}
+
+	tests := []struct {
+		name       string
+		body       string
+		setup      func(mock *mocks.MockWithdrawLogic)
+		wantStatus int
+	}{
+		{
+			name: "exact decimal sum",
+			body: `{"order":"2377225624","sum":751.1}`,
+			setup: func(mock *mocks.MockWithdrawLogic) {
+				mock.EXPECT().AddWithdrawOrder(gomock.Any(), 1, "2377225624", money.Amount(75110)).Return(nil)
+			},
+			wantStatus: http.StatusOK,
+		},
+		{
+			name:       "more than two decimal places",
+			body:       `{"order":"2377225624","sum":751.101}`,
+			wantStatus: http.StatusUnprocessableEntity,
+		},
+		{
+			name:       "non-positive sum",
+			body:       `{"order":"2377225624","sum":0}`,
+			wantStatus: http.StatusUnprocessableEntity,
+		},
+		{
+			name: "not enough funds",
+			body: `{"order":"2377225624","sum":10}`,
+			setup: func(mock *mocks.MockWithdrawLogic) {
+				mock.EXPECT().AddWithdrawOrder(gomock.Any(), 1, "2377225624", money.Amount(1000)).Return(storage.ErrNotEnoughFunds)
+			},
+			wantStatus: http.StatusPaymentRequired,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctrl := gomock.NewController(t)
+			defer ctrl.Finish()
+
+			mock := mocks.NewMockWithdrawLogic(ctrl)
+			if tt.setup != nil {
+				tt.setup(mock)
+			}
+
+			r := chi.NewRouter()
+			r.Use(FakeAuthMiddleWare)
+			r.Post("/api/user/balance/withdraw", WithDraw(mock, logger, validator))
+
+			req := httptest.NewRequest(http.MethodPost, "/api/user/balance/withdraw", strings.NewReader(tt.body))
+			req.Header.Set("Content-Type", "application/json")
+			w := httptest.NewRecorder()
+			r.ServeHTTP(w, req)
+
+			assert.Equal(t, tt.wantStatus, w.Code)
+		})
+	}
+}
+
+type healthStub worker.Health
+
+func (h healthStub) Health() worker.Health { return worker.Health(h) }
+
+func TestHealth(t *testing.T) {
+	logger := zap.NewNop().Sugar()
+	tests := []struct {
+		name       string
+		breaker    string
+		wantStatus string
+	}{
+		{name: "accrual available", breaker: worker.BreakerClosed, wantStatus: "ok"},
+		{name: "accrual circuit open", breaker: worker.BreakerOpen, wantStatus: "degraded"},
+		{name: "accrual probe in flight", breaker: worker.BreakerHalfOpen, wantStatus: "degraded"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			health := healthStub{
+				Breaker:   worker.BreakerState{State: tt.breaker, Failures: 2},
+				RateLimit: worker.LimiterState{Limit: 600},
+			}
+			req := httptest.NewRequest(http.MethodGet, "/api/health", nil)
+			w := httptest.NewRecorder()
+			Health(health, logger).ServeHTTP(w, req)
+
+			assert.Equal(t, http.StatusOK, w.Code)
+			var body struct {
+				Status  string `json:"status"`
+				Accrual struct {
+					Breaker struct {
+						State    string `json:"state"`
+						Failures int    `json:"failures"`
+					} `json:"breaker"`
+					RateLimit struct {
+						Limit int `json:"limit_per_minute"`
+					} `json:"rate_limit"`
+				} `json:"accrual"`
+			}
+			assert.NoError(t, json.NewDecoder(w.Body).Decode(&body))
+			assert.Equal(t, tt.wantStatus, body.Status)
+			assert.Equal(t, tt.breaker, body.Accrual.Breaker.State)
+			assert.Equal(t, 2, body.Accrual.Breaker.Failures)
+			assert.Equal(t, 600, body.Accrual.RateLimit.Limit)
+		})
+	}
+}
+
+type requeueStub struct{ err error }
+
+func (s requeueStub) Requeue(ctx context.Context, number string) error { return s.err }
+
+func TestRequeueOrder(t *testing.T) {
+	logger := zap.NewNop().Sugar()
+	tests := []struct {
+		name       string
+		token      string
+		err        error
+		wantStatus int
+	}{
+		{name: "requeued", token: "secret", wantStatus: http.StatusNoContent},
+		{name: "no admin token", token: "", wantStatus: http.StatusUnauthorized},
+		{name: "wrong admin token", token: "guess", wantStatus: http.StatusUnauthorized},
+		{name: "order not found", token: "secret", err: storage.ErrOrderNotFound, wantStatus: http.StatusNotFound},
+		{name: "order not stuck", token: "secret", err: service.ErrNotStuck, wantStatus: http.StatusConflict},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := chi.NewRouter()
+			r.Use(middleware.AdminMiddleware("secret"))
+			r.Post("/api/admin/orders/{number}/requeue", RequeueOrder(requeueStub{err: tt.err}, logger))
+
+			req := httptest.NewRequest(http.MethodPost, "/api/admin/orders/79927398713/requeue", nil)
+			if tt.token != "" {
+				req.Header.Set(middleware.AdminTokenHeader, tt.token)
+			}
+			w := httptest.NewRecorder()
+			r.ServeHTTP(w, req)
+
+			assert.Equal(t, tt.wantStatus, w.Code)
+		})
+	}
+}
+
+func TestProblemResponses(t *testing.T) {
+	logger := zap.NewNop().Sugar()
+	validator := &validation.LuhnValidation{}
+
+	decode := func(t *testing.T, w *httptest.ResponseRecorder) problem.Problem {
+		assert.Equal(t, problem.ContentType, w.Header().Get("Content-Type"))
+		var p problem.Problem
+		assert.NoError(t, json.NewDecoder(w.Body).Decode(&p))
+		assert.Equal(t, w.Code, p.Status)
+		return p
+	}
+
+	t.Run("sentinel errors", func(t *testing.T) {
+		tests := []struct {
+			err        error
+			wantStatus int
+			wantCode   string
+		}{
+			{service.ErrUnauthorized, http.StatusUnauthorized, problem.CodeUnauthorized},
+			{service.ErrInvalidOrderFormat, http.StatusUnprocessableEntity, problem.CodeInvalidOrderNumber},
+			{storage.ErrOrderAlreadyUploaded, http.StatusConflict, problem.CodeOrderUploadedByUser},
+			{storage.ErrNotEnoughFunds, http.StatusPaymentRequired, problem.CodeInsufficientFunds},
+			{fmt.Errorf("order 1: %w", storage.ErrOrderNotFound), http.StatusNotFound, problem.CodeOrderNotFound},
+			{service.ErrInternal, http.StatusInternalServerError, problem.CodeInternal},
+			{errors.New("pq: connection refused"), http.StatusInternalServerError, problem.CodeInternal},
+		}
+		for _, tt := range tests {
+			p := problemFor(tt.err)
+			assert.Equal(t, tt.wantStatus, p.Status, tt.err.Error())
+			assert.Equal(t, tt.wantCode, p.Code, tt.err.Error())
+			assert.Equal(t, "urn:gophermart:problem:"+tt.wantCode, p.Type, tt.err.Error())
+		}
+	})
+
+	t.Run("wrapped error text is not sent", func(t *testing.T) {
+		err := fmt.Errorf("requeue order 79927398713: %w", fmt.Errorf("order is PROCESSED in db, not STUCK: %w", storage.ErrStatusConflict))
+		p := problemFor(err)
+		assert.Equal(t, problem.CodeStatusConflict, p.Code)
+		assert.Equal(t, "order status has changed", p.Detail)
+		assert.Empty(t, p.Errors)
+	})
+
+	t.Run("request id and field details", func(t *testing.T) {
+		ctrl := gomock.NewController(t)
+		defer ctrl.Finish()
+		mockServ := mocks.NewMockServiceStorage(ctrl)
+
+		r := chi.NewRouter()
+		r.Use(chimw.RequestID)
+		r.Use(FakeAuthMiddleWare)
+		r.Get("/api/user/orders", GetUserOrders(mockServ, logger, validator))
+
+		req := httptest.NewRequest(http.MethodGet, "/api/user/orders?limit=abc", nil)
+		req.Header.Set(chimw.RequestIDHeader, "req-42")
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, req)
+
+		assert.Equal(t, http.StatusBadRequest, w.Code)
+		p := decode(t, w)
+		assert.Equal(t, problem.CodeInvalidQuery, p.Code)
+		assert.Equal(t, "req-42", p.RequestID)
+		assert.Equal(t, "/api/user/orders", p.Instance)
+		assert.Equal(t, []problem.Field{{Field: "limit", Message: `must be a positive integer, got "abc"`}}, p.Errors)
+	})
+
+	t.Run("service validation field", func(t *testing.T) {
+		ctrl := gomock.NewController(t)
+		defer ctrl.Finish()
+		mock := mocks.NewMockWithdrawalFetcher(ctrl)
+
+		r := chi.NewRouter()
+		r.Use(FakeAuthMiddleWare)
+		r.Get("/api/user/withdrawals", AllUserWithDrawals(mock, logger))
+
+		req := httptest.NewRequest(http.MethodGet, "/api/user/withdrawals?min_sum=5&max_sum=1", nil)
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, req)
+
+		assert.Equal(t, http.StatusBadRequest, w.Code)
+		p := decode(t, w)
+		assert.Equal(t, []problem.Field{{Field: "min_sum", Message: "must not exceed max_sum"}}, p.Errors)
+	})
+
+	t.Run("empty credentials", func(t *testing.T) {
+		req := httptest.NewRequest(http.MethodPost, "/api/user/register", strings.NewReader(`{"login":""}`))
+		req.Header.Set("Content-Type", "application/json")
+		w := httptest.NewRecorder()
+		Register(nil, logger, nil, nil, time.Hour).ServeHTTP(w, req)
+
+		assert.Equal(t, http.StatusBadRequest, w.Code)
+		p := decode(t, w)
+		assert.Equal(t, problem.CodeValidationFailed, p.Code)
+		assert.Equal(t, []problem.Field{
+			{Field: "login", Message: "must not be empty"},
+			{Field: "password", Message: "must not be empty"},
+		}, p.Errors)
 	})
 }
@@ -0,0 +1,36 @@
+package handlers
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"github.com/NailUsmanov/gophermart/internal/worker"
+	"go.uber.org/zap"
+)
+
+// AccrualHealth - источник состояния опроса accrual
+type AccrualHealth interface {
+	Health() worker.Health
+}
+
+type healthResponse struct {
+	// Status - ok или degraded, если опрос accrual остановлен предохранителем
+	Status  string        `json:"status"`
+	Accrual worker.Health `json:"accrual"`
+}
+
+// Health всегда отвечает 200: когда accrual недоступен, сервис продолжает принимать заказы
+// и списания, поэтому выводить его из балансировки не нужно
+func Health(h AccrualHealth, sugar *zap.SugaredLogger) http.HandlerFunc {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		resp := healthResponse{Status: "ok", Accrual: h.Health()}
+		if resp.Accrual.Breaker.State != worker.BreakerClosed {
+			resp.Status = "degraded"
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusOK)
+		if err := json.NewEncoder(w).Encode(resp); err != nil {
+			sugar.Errorf("error encoding response: %v", err)
+		}
+	})
+}
@@ -2,8 +2,12 @@ package handlers
 
 import (
 	"encoding/json"
+	"errors"
+	"fmt"
 	"io"
 	"net/http"
+	"strconv"
+	"time"
 
 	"github.com/NailUsmanov/gophermart/internal/service"
 	"github.com/NailUsmanov/gophermart/internal/storage"
@@ -15,7 +19,7 @@ func PostOrder(s service.ServiceInterface, sugar *zap.SugaredLogger, v validatio
 	return func(w http.ResponseWriter, r *http.Request) {
 		sugar.Infof(">>> PostOrder endpoint called")
 		if r.Header.Get("Content-Type") != "text/plain" {
-			http.Error(w, "Invalid content-type", http.StatusBadRequest)
+			invalidContentType(w, r, sugar, "text/plain")
 			return
 		}
 		sugar.Infof("Content-Type: %s", r.Header.Get("Content-Type"))
@@ -23,7 +27,7 @@ func PostOrder(s service.ServiceInterface, sugar *zap.SugaredLogger, v validatio
 		// Читаем тело запроса
 		body, err := io.ReadAll(r.Body)
 		if err != nil || len(body) == 0 {
-			http.Error(w, "Invalid request body", http.StatusBadRequest)
+			invalidBody(w, r, sugar, "request body must contain an order number")
 			return
 		}
 		sugar.Infof("Received request body: %q", body)
@@ -35,16 +39,7 @@ func PostOrder(s service.ServiceInterface, sugar *zap.SugaredLogger, v validatio
 
 		exists, existingUserID, userID, err := s.CheckExistUser(r.Context(), orderNum)
 		if err != nil {
-			switch err {
-			case service.ErrUnauthorized:
-				http.Error(w, "unauthorized", http.StatusUnauthorized)
-			case service.ErrInvalidOrderFormat:
-				http.Error(w, "invalid order number format", http.StatusUnprocessableEntity)
-			case service.ErrInternal:
-				http.Error(w, "internal server error", http.StatusInternalServerError)
-			default:
-				http.Error(w, "unknown error", http.StatusInternalServerError)
-			}
+			writeError(w, r, sugar, err)
 			return
 		}
 		// Проверяем существует ли уже запись в базе
@@ -52,7 +47,7 @@ func PostOrder(s service.ServiceInterface, sugar *zap.SugaredLogger, v validatio
 			if existingUserID == userID {
 				w.WriteHeader(http.StatusOK)
 			} else {
-				http.Error(w, "Order already uploaded by another user", http.StatusConflict)
+				writeError(w, r, sugar, storage.ErrOrderAlreadyUploaded)
 			}
 			return
 		}
@@ -60,12 +55,7 @@ func PostOrder(s service.ServiceInterface, sugar *zap.SugaredLogger, v validatio
 		// Создаем новый заказ. Если заказ уже существует по такому номеру, то вернет ошибку
 		sugar.Infof("Calling CreateNewOrder with userID=%d, orderNum=%s", userID, orderNum)
 		if err := s.CreateNewOrder(r.Context(), userID, orderNum, sugar); err != nil {
-			switch err {
-			case service.ErrInternal:
-				http.Error(w, "Internal server error", http.StatusInternalServerError)
-			case storage.ErrOrderAlreadyUploaded:
-				http.Error(w, "order already uploaded by another person", http.StatusConflict)
-			}
+			writeError(w, r, sugar, err)
 			return
 		}
 		w.WriteHeader(http.StatusAccepted)
@@ -79,19 +69,22 @@ func GetUserOrders(s service.ServiceStorage, sugar *zap.SugaredLogger, v validat
 		// Создаем структуру сервис слоя
 		serv := service.NewService(s, v)
 
+		// С параметрами - постраничный ответ, без них - весь список, как раньше
+		if hasOrdersQuery(r) {
+			listUserOrders(w, r, serv, sugar)
+			return
+		}
+
 		// Получаем все данные по заказам пользователя через метод GetOrdersByUserID
 		// и проверяем на наличие записей по конкретному пользователю
 		orders, err := serv.GetUserOrders(r.Context())
 		if err != nil {
 			sugar.Infof("GetOrdersByUserID failed: %v", err)
-			switch err {
-			case service.ErrUnauthorized:
-				http.Error(w, "Unauthorize", http.StatusUnauthorized)
-			case service.ErrNoContent:
+			if errors.Is(err, service.ErrNoContent) {
 				w.WriteHeader(http.StatusNoContent)
-			case service.ErrInternal:
-				http.Error(w, "internal server error", http.StatusInternalServerError)
+				return
 			}
+			writeError(w, r, sugar, err)
 			return
 		}
 		// Если все ок, то возвращаем JSON со списком заказов
@@ -99,9 +92,105 @@ func GetUserOrders(s service.ServiceStorage, sugar *zap.SugaredLogger, v validat
 		w.WriteHeader(http.StatusOK)
 		enc := json.NewEncoder(w)
 		if err := enc.Encode(orders); err != nil {
-			sugar.Error("error encoding response")
-			http.Error(w, "error with encoding response", http.StatusInternalServerError)
+			// Статус уже отправлен, поэтому ошибку можно только залогировать
+			sugar.Errorf("error encoding response: %v", err)
 			return
 		}
 	})
 }
+
+// ordersQueryParams - параметры постраничного списка заказов
+var ordersQueryParams = []string{"limit", "cursor", "status", "from", "to"}
+
+func hasOrdersQuery(r *http.Request) bool {
+	return hasAnyParam(r, ordersQueryParams)
+}
+
+func hasAnyParam(r *http.Request, params []string) bool {
+	q := r.URL.Query()
+	for _, p := range params {
+		if q.Has(p) {
+			return true
+		}
+	}
+	return false
+}
+
+// listUserOrders отвечает страницей заказов {"orders": [...], "next_cursor": "..."};
+// ссылка на следующую страницу дублируется в заголовке Link
+func listUserOrders(w http.ResponseWriter, r *http.Request, serv *service.Service, sugar *zap.SugaredLogger) {
+	q, err := parseOrdersQuery(r)
+	if err != nil {
+		writeError(w, r, sugar, err)
+		return
+	}
+	page, err := serv.ListUserOrders(r.Context(), q)
+	if err != nil {
+		writeError(w, r, sugar, err)
+		return
+	}
+	if page.NextCursor != "" {
+		w.Header().Set("Link", nextPageLink(r, page.NextCursor))
+	}
+	writeJSON(w, sugar, page)
+}
+
+func parseOrdersQuery(r *http.Request) (service.OrdersQuery, error) {
+	values := r.URL.Query()
+	q := service.OrdersQuery{
+		Cursor: values.Get("cursor"),
+		Status: values.Get("status"),
+	}
+	var err error
+	if q.Limit, err = parseLimitParam(values.Get("limit")); err != nil {
+		return q, err
+	}
+	if q.From, err = parseTimeParam(values.Get("from")); err != nil {
+		return q, paramError("from", err)
+	}
+	if q.To, err = parseTimeParam(values.Get("to")); err != nil {
+		return q, paramError("to", err)
+	}
+	return q, nil
+}
+
+// parseLimitParam - размер страницы; пустая строка - 0, то есть размер по умолчанию
+func parseLimitParam(v string) (int, error) {
+	if v == "" {
+		return 0, nil
+	}
+	limit, err := strconv.Atoi(v)
+	if err != nil || limit <= 0 {
+		return 0, &service.FieldError{Field: "limit", Message: fmt.Sprintf("must be a positive integer, got %q", v)}
+	}
+	return limit, nil
+}
+
+// parseTimeParam принимает RFC 3339 или дату YYYY-MM-DD (полночь UTC); пустая строка - нулевое время
+func parseTimeParam(v string) (time.Time, error) {
+	if v == "" {
+		return time.Time{}, nil
+	}
+	if t, err := time.Parse(time.RFC3339, v); err == nil {
+		return t, nil
+	}
+	t, err := time.Parse(time.DateOnly, v)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("%q is neither RFC 3339 nor YYYY-MM-DD", v)
+	}
+	return t, nil
+}
+
+// paramError - ошибка разбора параметра запроса как FieldError, чтобы клиент получил имя поля
+func paramError(field string, err error) error {
+	return &service.FieldError{Field: field, Message: err.Error()}
+}
+
+// nextPageLink - ссылка rel="next" с теми же фильтрами и курсором следующей страницы
+func nextPageLink(r *http.Request, cursor string) string {
+	next := *r.URL
+	q := next.Query()
+	q.Set("cursor", cursor)
+	next.RawQuery = q.Encode()
+	return fmt.Sprintf("<%s>; rel=\"next\"", next.RequestURI())
+}
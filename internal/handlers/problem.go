@@ -0,0 +1,87 @@
+package handlers
+
+import (
+	"errors"
+	"net/http"
+
+	"github.com/NailUsmanov/gophermart/internal/auth"
+	"github.com/NailUsmanov/gophermart/internal/money"
+	"github.com/NailUsmanov/gophermart/internal/problem"
+	"github.com/NailUsmanov/gophermart/internal/service"
+	"github.com/NailUsmanov/gophermart/internal/storage"
+	"go.uber.org/zap"
+)
+
+// sentinelProblems - статус, код и текст для клиента для ошибок сервиса и хранилища; проверяются по порядку через errors.Is.
+// Текст фиксированный: обертки ошибок несут внутренние подробности и попадают только в лог
+var sentinelProblems = []struct {
+	err    error
+	status int
+	code   string
+	detail string
+}{
+	{service.ErrUnauthorized, http.StatusUnauthorized, problem.CodeUnauthorized, "authentication required"},
+	{service.ErrInvalidOrderFormat, http.StatusUnprocessableEntity, problem.CodeInvalidOrderNumber, "order number is invalid"},
+	{service.ErrInvalidQuery, http.StatusBadRequest, problem.CodeInvalidQuery, "query parameters are invalid"},
+	{service.ErrNotStuck, http.StatusConflict, problem.CodeOrderNotStuck, "order is not stuck"},
+	{service.ErrUnknownStatus, http.StatusUnprocessableEntity, problem.CodeUnknownStatus, "order status is unknown"},
+	{service.ErrIllegalTransition, http.StatusConflict, problem.CodeIllegalTransition, "order status transition is not allowed"},
+	{storage.ErrOrderAlreadyUploaded, http.StatusConflict, problem.CodeOrderUploadedByUser, "order is already uploaded by another user"},
+	{storage.ErrOrderAlreadyUsed, http.StatusConflict, problem.CodeOrderAlreadyUsed, "order is already used"},
+	{storage.ErrNotEnoughFunds, http.StatusPaymentRequired, problem.CodeInsufficientFunds, "insufficient funds"},
+	{storage.ErrOrderNotFound, http.StatusNotFound, problem.CodeOrderNotFound, "order not found"},
+	{storage.ErrStatusConflict, http.StatusConflict, problem.CodeStatusConflict, "order status has changed"},
+	{auth.ErrSessionNotFound, http.StatusNotFound, problem.CodeSessionNotFound, "session not found"},
+	{money.ErrTooPrecise, http.StatusUnprocessableEntity, problem.CodeInvalidAmount, "amount has more than two decimal places"},
+	{money.ErrInvalidValue, http.StatusUnprocessableEntity, problem.CodeInvalidAmount, "amount is invalid"},
+	{money.ErrOverflow, http.StatusUnprocessableEntity, problem.CodeInvalidAmount, "amount is too large"},
+}
+
+// problemFor переводит ошибку сервиса или хранилища в проблему. Клиенту уходит только фиксированный текст
+// и поле из FieldError: текст самой ошибки может раскрыть устройство сервиса, поэтому он только логируется
+func problemFor(err error) *problem.Problem {
+	for _, sp := range sentinelProblems {
+		if !errors.Is(err, sp.err) {
+			continue
+		}
+		p := problem.New(sp.status, sp.code, sp.detail)
+		var fe *service.FieldError
+		if errors.As(err, &fe) {
+			p.WithField(fe.Field, fe.Message)
+		}
+		return p
+	}
+	return problem.New(http.StatusInternalServerError, problem.CodeInternal, "internal server error")
+}
+
+// writeError отвечает проблемой для ошибки сервиса или хранилища
+func writeError(w http.ResponseWriter, r *http.Request, sugar *zap.SugaredLogger, err error) {
+	p := problemFor(err)
+	if p.Status >= http.StatusInternalServerError {
+		sugar.Errorf("%s %s failed: %v", r.Method, r.URL.Path, err)
+	} else {
+		sugar.Debugw("Request rejected", "method", r.Method, "path", r.URL.Path, "code", p.Code, "err", err)
+	}
+	writeProblem(w, r, sugar, p)
+}
+
+// writeProblem пишет проблему в ответ и логирует ошибку записи
+func writeProblem(w http.ResponseWriter, r *http.Request, sugar *zap.SugaredLogger, p *problem.Problem) {
+	if err := problem.Write(w, r, p); err != nil {
+		sugar.Errorf("error encoding problem: %v", err)
+	}
+}
+
+// Частые проблемы, которые отдают сами обработчики, а не ошибки сервиса
+
+func unauthorized(w http.ResponseWriter, r *http.Request, sugar *zap.SugaredLogger) {
+	writeProblem(w, r, sugar, problem.New(http.StatusUnauthorized, problem.CodeUnauthorized, "authentication required"))
+}
+
+func invalidContentType(w http.ResponseWriter, r *http.Request, sugar *zap.SugaredLogger, want string) {
+	writeProblem(w, r, sugar, problem.New(http.StatusBadRequest, problem.CodeInvalidContentType, "Content-Type must be "+want))
+}
+
+func invalidBody(w http.ResponseWriter, r *http.Request, sugar *zap.SugaredLogger, detail string) {
+	writeProblem(w, r, sugar, problem.New(http.StatusBadRequest, problem.CodeInvalidBody, detail))
+}
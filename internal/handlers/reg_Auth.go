@@ -3,70 +3,76 @@ package handlers
 import (
 	"encoding/json"
 	"errors"
+	"fmt"
+	"net"
 	"net/http"
-	"strconv"
+	"time"
 
+	"github.com/NailUsmanov/gophermart/internal/auth"
 	"github.com/NailUsmanov/gophermart/internal/interfaces"
+	"github.com/NailUsmanov/gophermart/internal/problem"
 	"github.com/NailUsmanov/gophermart/internal/storage"
 	"github.com/NailUsmanov/gophermart/models"
 	"go.uber.org/zap"
 )
 
-func Register(s interfaces.Auth, sugar *zap.SugaredLogger) http.HandlerFunc {
+func Register(s interfaces.AuthSessions, sugar *zap.SugaredLogger, tokens *auth.TokenManager, passwords *auth.Passwords, sessionTTL time.Duration) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
 		sugar.Infof(">>> Register endpoint called")
 		if r.Header.Get("Content-Type") != "application/json" {
-			http.Error(w, "Invalid content type", http.StatusBadRequest)
+			invalidContentType(w, r, sugar, "application/json")
 			return
 		}
 		// Декодим наш запрос
 		var req models.RegistrationJSON
 		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
 			sugar.Error("cannot decode request JSON body:", err)
-			http.Error(w, "Invalid JSON format", http.StatusBadRequest)
+			invalidBody(w, r, sugar, "invalid JSON format")
 			return
 		}
 		// Проверяем чтобы логин и пароль были не пустыми
-		if len(req.Login) == 0 || len(req.Password) == 0 {
-			http.Error(w, "empty login or password", http.StatusBadRequest)
+		if p := credentialsProblem(req); p != nil {
+			writeProblem(w, r, sugar, p)
 			return
 		}
 		// Хэшируем пароль
-		passwordHash := storage.HashPassword(req.Password)
+		passwordHash, err := passwords.Hash(req.Password)
+		if err != nil {
+			writeError(w, r, sugar, fmt.Errorf("hash password: %w", err))
+			return
+		}
 		// Регистрируем пользователя
-		err := s.Registration(r.Context(), req.Login, passwordHash)
+		err = s.Registration(r.Context(), req.Login, passwordHash)
 		if err != nil {
+			// Хранилище сообщает о занятом логине той же ошибкой, что и о занятом номере заказа
 			if errors.Is(err, storage.ErrOrderAlreadyUsed) {
-				sugar.Errorf("Save error: %v", err)
-				http.Error(w, "login is already occupied", http.StatusConflict)
+				writeProblem(w, r, sugar, problem.New(http.StatusConflict, problem.CodeLoginTaken, "login is already occupied").
+					WithField("login", "is already occupied"))
 				return
 			}
-			sugar.Errorf("Unexpected registration error: %v", err)
-			http.Error(w, "internal server error", http.StatusInternalServerError)
+			writeError(w, r, sugar, fmt.Errorf("registration: %w", err))
 			return
 		}
 		// Получаем userID по login
 		userID, err := s.GetUserIDByLogin(r.Context(), req.Login)
 		if err != nil {
-			http.Error(w, "server error", http.StatusInternalServerError)
+			writeError(w, r, sugar, fmt.Errorf("get user id: %w", err))
 			return
 		}
 		// Возвращаем ответ
+		if err := startSession(w, r, s, tokens, sessionTTL, userID); err != nil {
+			writeError(w, r, sugar, fmt.Errorf("start session: %w", err))
+			return
+		}
 		sugar.Infof("User %s successfully registered", req.Login)
-		http.SetCookie(w, &http.Cookie{
-			Name:     "auth_token",
-			Value:    strconv.Itoa(userID),
-			Path:     "/",
-			HttpOnly: true,
-		})
 		w.WriteHeader(http.StatusOK)
 	}
 }
 
-func Login(s interfaces.Auth, sugar *zap.SugaredLogger) http.HandlerFunc {
+func Login(s interfaces.AuthSessions, sugar *zap.SugaredLogger, tokens *auth.TokenManager, passwords *auth.Passwords, sessionTTL time.Duration) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
 		if r.Header.Get("Content-Type") != "application/json" {
-			http.Error(w, "invalid content type", http.StatusBadRequest)
+			invalidContentType(w, r, sugar, "application/json")
 			return
 		}
 		// Декодим запрос
@@ -74,40 +80,99 @@ func Login(s interfaces.Auth, sugar *zap.SugaredLogger) http.HandlerFunc {
 		err := json.NewDecoder(r.Body).Decode(&req)
 		if err != nil {
 			sugar.Error("cannot decode request JSON body:", err)
-			http.Error(w, "Invalid JSON format", http.StatusBadRequest)
+			invalidBody(w, r, sugar, "invalid JSON format")
 			return
 		}
 		// Провеверяем чтобы логин и пароль не были пустыми
-		if len(req.Login) == 0 || len(req.Password) == 0 {
-			http.Error(w, "empty login or password", http.StatusBadRequest)
+		if p := credentialsProblem(req); p != nil {
+			writeProblem(w, r, sugar, p)
 			return
 		}
 		// Проверяем наличие логина и совпадение хэша пароля в базе
-		_, err = s.GetUserByLogin(r.Context(), req.Login)
-		if err != nil {
+		passwordHash, err := s.GetUserByLogin(r.Context(), req.Login)
+		if err != nil || passwordHash == "" {
 			sugar.Errorf("Unexpected auth error: %v", err)
-			http.Error(w, "can't find user", http.StatusUnauthorized)
+			// Пароль все равно хэшируем, чтобы неизвестный логин не отличался от неверного пароля по времени ответа
+			passwords.VerifyUnknown(req.Password)
+			invalidCredentials(w, r, sugar)
 			return
 		}
-		err = s.CheckHashMatch(r.Context(), req.Login, req.Password)
-		if err != nil {
-			http.Error(w, "invalid password", http.StatusUnauthorized)
+		ok, needsRehash, err := passwords.Verify(req.Password, passwordHash)
+		if err != nil || !ok {
+			invalidCredentials(w, r, sugar)
 			return
 		}
+		// Если хэш устаревший (SHA-256 или старые параметры), пересчитываем его текущей схемой.
+		// Ошибка пересчета не мешает входу - попробуем в следующий раз
+		if needsRehash {
+			if newHash, err := passwords.Hash(req.Password); err != nil {
+				sugar.Errorf("Failed to rehash password: %v", err)
+			} else if err := s.UpdatePasswordHash(r.Context(), req.Login, newHash); err != nil {
+				sugar.Errorf("Failed to store rehashed password: %v", err)
+			}
+		}
 		// Получаем UserID по логину
 		userID, err := s.GetUserIDByLogin(r.Context(), req.Login)
 		if err != nil {
-			http.Error(w, "server error", http.StatusInternalServerError)
+			writeError(w, r, sugar, fmt.Errorf("get user id: %w", err))
 			return
 		}
 		// Устанавливаем куку и возвращаем ответ
+		if err := startSession(w, r, s, tokens, sessionTTL, userID); err != nil {
+			writeError(w, r, sugar, fmt.Errorf("start session: %w", err))
+			return
+		}
 		sugar.Infof("User %s successfully authenticated", req.Login)
-		http.SetCookie(w, &http.Cookie{
-			Name:     "auth_token",
-			Value:    strconv.Itoa(userID),
-			Path:     "/",
-			HttpOnly: true,
-		})
 		w.WriteHeader(http.StatusOK)
 	}
 }
+
+// credentialsProblem - 400 с перечнем пустых полей; nil, если логин и пароль заданы
+func credentialsProblem(req models.RegistrationJSON) *problem.Problem {
+	if len(req.Login) != 0 && len(req.Password) != 0 {
+		return nil
+	}
+	p := problem.New(http.StatusBadRequest, problem.CodeValidationFailed, "empty login or password")
+	if len(req.Login) == 0 {
+		p.WithField("login", "must not be empty")
+	}
+	if len(req.Password) == 0 {
+		p.WithField("password", "must not be empty")
+	}
+	return p
+}
+
+// invalidCredentials не уточняет, что именно не так: неизвестный логин или неверный пароль
+func invalidCredentials(w http.ResponseWriter, r *http.Request, sugar *zap.SugaredLogger) {
+	writeProblem(w, r, sugar, problem.New(http.StatusUnauthorized, problem.CodeInvalidCredentials, "invalid login or password"))
+}
+
+// startSession заводит серверную сессию и кладет подписанный токен с ее ID в куку auth_token
+func startSession(w http.ResponseWriter, r *http.Request, sessions interfaces.Sessions, tokens *auth.TokenManager, sessionTTL time.Duration, userID int) error {
+	sessionID, err := sessions.CreateSession(r.Context(), userID, r.UserAgent(), clientIP(r), sessionTTL)
+	if err != nil {
+		return err
+	}
+	token, expiresAt, err := tokens.BuildToken(userID, sessionID)
+	if err != nil {
+		return err
+	}
+	http.SetCookie(w, &http.Cookie{
+		Name:     auth.CookieName,
+		Value:    token,
+		Path:     "/",
+		Expires:  expiresAt,
+		HttpOnly: true,
+		SameSite: http.SameSiteLaxMode,
+	})
+	return nil
+}
+
+// clientIP достает IP клиента из адреса соединения
+func clientIP(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
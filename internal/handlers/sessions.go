@@ -0,0 +1,96 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+
+	"github.com/NailUsmanov/gophermart/internal/auth"
+	"github.com/NailUsmanov/gophermart/internal/interfaces"
+	"github.com/NailUsmanov/gophermart/internal/middleware"
+	"github.com/go-chi/chi"
+	"go.uber.org/zap"
+)
+
+func Logout(s interfaces.Sessions, sugar *zap.SugaredLogger) http.HandlerFunc {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		sugar.Infof(">>> Logout endpoint called")
+
+		// Достаем пользователя и текущую сессию из контекста
+		userID, ok := r.Context().Value(middleware.UserLoginKey).(int)
+		sessionID, okSession := r.Context().Value(middleware.SessionIDKey).(string)
+		if !ok || !okSession {
+			unauthorized(w, r, sugar)
+			return
+		}
+
+		// Отзываем текущую сессию, чтобы кука перестала работать даже если ее украли
+		if err := s.RevokeSession(r.Context(), userID, sessionID); err != nil && !errors.Is(err, auth.ErrSessionNotFound) {
+			writeError(w, r, sugar, err)
+			return
+		}
+
+		// Стираем куку на клиенте
+		http.SetCookie(w, &http.Cookie{
+			Name:     auth.CookieName,
+			Value:    "",
+			Path:     "/",
+			MaxAge:   -1,
+			HttpOnly: true,
+			SameSite: http.SameSiteLaxMode,
+		})
+		w.WriteHeader(http.StatusOK)
+	})
+}
+
+func UserSessions(s interfaces.Sessions, sugar *zap.SugaredLogger) http.HandlerFunc {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		sugar.Infof("UserSessions endpoint called")
+
+		userID, ok := r.Context().Value(middleware.UserLoginKey).(int)
+		if !ok {
+			unauthorized(w, r, sugar)
+			return
+		}
+		currentID, _ := r.Context().Value(middleware.SessionIDKey).(string)
+
+		// Получаем все активные сессии пользователя и помечаем текущую
+		sessions, err := s.ListSessions(r.Context(), userID)
+		if err != nil {
+			writeError(w, r, sugar, err)
+			return
+		}
+		for i := range sessions {
+			sessions[i].Current = sessions[i].ID == currentID
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusOK)
+		enc := json.NewEncoder(w)
+		if err := enc.Encode(sessions); err != nil {
+			sugar.Errorf("error encoding response: %v", err)
+			return
+		}
+	})
+}
+
+func RevokeUserSession(s interfaces.Sessions, sugar *zap.SugaredLogger) http.HandlerFunc {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		sugar.Infof("RevokeUserSession endpoint called")
+
+		userID, ok := r.Context().Value(middleware.UserLoginKey).(int)
+		if !ok {
+			unauthorized(w, r, sugar)
+			return
+		}
+
+		// Отзываем сессию по ID из пути. Чужую сессию отозвать нельзя - для нее вернется 404
+		sessionID := chi.URLParam(r, "id")
+		err := s.RevokeSession(r.Context(), userID, sessionID)
+		if err != nil {
+			writeError(w, r, sugar, err)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	})
+}
@@ -6,5 +6,5 @@ type Auth interface {
 	Registration(ctx context.Context, login string, password string) error
 	GetUserByLogin(ctx context.Context, login string) (string, error)
 	GetUserIDByLogin(ctx context.Context, login string) (int, error)
-	CheckHashMatch(ctx context.Context, login, password string) error
+	UpdatePasswordHash(ctx context.Context, login, passwordHash string) error
 }
@@ -0,0 +1,20 @@
+package interfaces
+
+import (
+	"context"
+	"time"
+
+	"github.com/NailUsmanov/gophermart/internal/models"
+)
+
+// IdempotencyKeys хранит ответы на запросы с Idempotency-Key, чтобы повтор получил первый ответ
+type IdempotencyKeys interface {
+	// BeginIdempotentRequest закрепляет ключ пользователя за запросом с отпечатком fingerprint на ttl.
+	// nil - ключ был свободен, запрос нужно выполнить. Иначе возвращается запись первого запроса:
+	// со Status == 0, пока тот выполняется, или с его сохраненным ответом
+	BeginIdempotentRequest(ctx context.Context, userID int, key, fingerprint string, ttl time.Duration) (*models.IdempotentResponse, error)
+	// CompleteIdempotentRequest сохраняет ответ на запрос, закрепивший ключ
+	CompleteIdempotentRequest(ctx context.Context, userID int, key string, resp models.IdempotentResponse) error
+	// ReleaseIdempotentRequest освобождает ключ запроса, оставшегося без ответа, чтобы повтор выполнился заново
+	ReleaseIdempotentRequest(ctx context.Context, userID int, key string) error
+}
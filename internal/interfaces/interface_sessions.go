@@ -0,0 +1,26 @@
+package interfaces
+
+import (
+	"context"
+	"time"
+
+	"github.com/NailUsmanov/gophermart/internal/models"
+)
+
+type Sessions interface {
+	// CreateSession заводит новую сессию пользователя и возвращает ее ID
+	CreateSession(ctx context.Context, userID int, userAgent, ip string, ttl time.Duration) (string, error)
+	// TouchSession проверяет, что сессия активна, и продлевает ее на ttl от текущего момента.
+	// Возвращает auth.ErrSessionNotFound, auth.ErrSessionRevoked или auth.ErrSessionExpired
+	TouchSession(ctx context.Context, sessionID string, userID int, ttl time.Duration) error
+	// ListSessions возвращает активные сессии пользователя
+	ListSessions(ctx context.Context, userID int) ([]models.Session, error)
+	// RevokeSession отзывает сессию пользователя. Чужая или несуществующая сессия - auth.ErrSessionNotFound
+	RevokeSession(ctx context.Context, userID int, sessionID string) error
+}
+
+// AuthSessions - то, что нужно хендлерам регистрации и входа: пользователи и их сессии
+type AuthSessions interface {
+	Auth
+	Sessions
+}
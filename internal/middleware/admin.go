@@ -0,0 +1,26 @@
+package middleware
+
+import (
+	"crypto/subtle"
+	"net/http"
+
+	"github.com/NailUsmanov/gophermart/internal/problem"
+)
+
+// AdminTokenHeader - заголовок с токеном оператора для админского API
+const AdminTokenHeader = "X-Admin-Token"
+
+// AdminMiddleware пропускает только запросы с токеном оператора в AdminTokenHeader
+func AdminMiddleware(token string) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			got := r.Header.Get(AdminTokenHeader)
+			// Сравнение за постоянное время, чтобы токен нельзя было подобрать по задержке ответа
+			if token == "" || subtle.ConstantTimeCompare([]byte(got), []byte(token)) != 1 {
+				writeProblem(w, r, http.StatusUnauthorized, problem.CodeInvalidAdminToken, "invalid admin token")
+				return
+			}
+			next.ServeHTTP(w, r)
+		})
+	}
+}
@@ -2,32 +2,61 @@ package middleware
 
 import (
 	"context"
+	"errors"
 	"net/http"
-	"strconv"
+	"time"
+
+	"github.com/NailUsmanov/gophermart/internal/auth"
+	"github.com/NailUsmanov/gophermart/internal/interfaces"
+	"github.com/NailUsmanov/gophermart/internal/problem"
 )
 
 type contextLogin string
 
 const (
 	UserLoginKey contextLogin = "userID"
+	SessionIDKey contextLogin = "sessionID"
 )
 
-func AuthMiddleware(next http.Handler) http.Handler {
-	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		// 1. Проверяем куку auth_token
-		cookie, err := r.Cookie("auth_token")
-		if err != nil || cookie.Value == "" {
-			http.Error(w, "unauthorized", http.StatusUnauthorized)
-			return
-		}
-		// 2. Если кука есть - используем её значение как login
-		userID, err := strconv.Atoi(cookie.Value)
-		if err != nil {
-			http.Error(w, "unauthorized", http.StatusUnauthorized)
-			return
-		}
-		// 3. Добавляем login в контекст
-		ctx := context.WithValue(r.Context(), UserLoginKey, userID)
-		next.ServeHTTP(w, r.WithContext(ctx))
-	})
+// AuthMiddleware проверяет подписанный токен из куки и активность серверной сессии,
+// продлевая ее на sessionTTL при каждом запросе
+func AuthMiddleware(tokens *auth.TokenManager, sessions interfaces.Sessions, sessionTTL time.Duration) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			// 1. Проверяем куку auth_token
+			cookie, err := r.Cookie(auth.CookieName)
+			if err != nil || cookie.Value == "" {
+				writeProblem(w, r, http.StatusUnauthorized, problem.CodeTokenMissing, "missing auth token")
+				return
+			}
+			// 2. Проверяем подпись и срок действия токена и достаем из него userID и ID сессии
+			claims, err := tokens.ParseToken(cookie.Value)
+			if err != nil {
+				if errors.Is(err, auth.ErrTokenExpired) {
+					writeProblem(w, r, http.StatusUnauthorized, problem.CodeTokenExpired, "token expired")
+					return
+				}
+				writeProblem(w, r, http.StatusUnauthorized, problem.CodeTokenInvalid, "invalid token")
+				return
+			}
+			// 3. Проверяем, что сессия не отозвана и не истекла, и сдвигаем last-seen
+			if err := sessions.TouchSession(r.Context(), claims.SessionID, claims.UserID, sessionTTL); err != nil {
+				switch {
+				case errors.Is(err, auth.ErrSessionRevoked):
+					writeProblem(w, r, http.StatusUnauthorized, problem.CodeSessionRevoked, "session revoked")
+				case errors.Is(err, auth.ErrSessionExpired):
+					writeProblem(w, r, http.StatusUnauthorized, problem.CodeSessionExpired, "session expired")
+				case errors.Is(err, auth.ErrSessionNotFound):
+					writeProblem(w, r, http.StatusUnauthorized, problem.CodeSessionNotFound, "session not found")
+				default:
+					writeProblem(w, r, http.StatusInternalServerError, problem.CodeInternal, "internal server error")
+				}
+				return
+			}
+			// 4. Добавляем userID и ID сессии в контекст
+			ctx := context.WithValue(r.Context(), UserLoginKey, claims.UserID)
+			ctx = context.WithValue(ctx, SessionIDKey, claims.SessionID)
+			next.ServeHTTP(w, r.WithContext(ctx))
+		})
+	}
 }
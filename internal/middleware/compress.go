@@ -5,6 +5,8 @@ import (
 	"io"
 	"net/http"
 	"strings"
+
+	"github.com/NailUsmanov/gophermart/internal/problem"
 )
 
 type CompressWriter struct {
@@ -75,7 +77,7 @@ func GzipMiddleware(next http.Handler) http.Handler {
 		if strings.Contains(r.Header.Get("Content-Encoding"), "gzip") {
 			cr, err := NewCompressReader(r.Body)
 			if err != nil {
-				http.Error(w, "failed to decompress gzip body", http.StatusBadRequest)
+				writeProblem(w, r, http.StatusBadRequest, problem.CodeInvalidBody, "failed to decompress gzip body")
 				return
 			}
 			defer cr.Close()
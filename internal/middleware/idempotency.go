@@ -0,0 +1,157 @@
+package middleware
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"io"
+	"net/http"
+	"time"
+
+	"github.com/NailUsmanov/gophermart/internal/interfaces"
+	"github.com/NailUsmanov/gophermart/internal/models"
+	"github.com/NailUsmanov/gophermart/internal/problem"
+	"go.uber.org/zap"
+)
+
+// IdempotencyKeyHeader - заголовок с ключом, по которому повтор запроса получает первый ответ
+const IdempotencyKeyHeader = "Idempotency-Key"
+
+// IdempotentReplayedHeader помечает ответ, повторенный из хранилища, а не полученный заново
+const IdempotentReplayedHeader = "Idempotent-Replayed"
+
+// Ограничения на ключ и тело запроса: тело целиком читается в память ради отпечатка
+const (
+	maxIdempotencyKeyLen = 255
+	maxIdempotentBody    = 1 << 20
+)
+
+// IdempotencyMiddleware сохраняет первый ответ на запрос с Idempotency-Key на ttl и отдает его на повторы.
+// Повтор с тем же ключом, но другим запросом получает 422, повтор во время выполнения первого - 409.
+// Ответы 5xx не сохраняются: повтор после сбоя выполняется заново. Запросы без ключа проходят как есть
+func IdempotencyMiddleware(keys interfaces.IdempotencyKeys, ttl time.Duration, sugar *zap.SugaredLogger) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			key := r.Header.Get(IdempotencyKeyHeader)
+			if key == "" {
+				next.ServeHTTP(w, r)
+				return
+			}
+			if len(key) > maxIdempotencyKeyLen {
+				writeProblem(w, r, http.StatusBadRequest, problem.CodeInvalidIdempotencyKey, "idempotency key is too long")
+				return
+			}
+			userID, ok := r.Context().Value(UserLoginKey).(int)
+			if !ok {
+				writeProblem(w, r, http.StatusUnauthorized, problem.CodeUnauthorized, "authentication required")
+				return
+			}
+
+			body, err := io.ReadAll(http.MaxBytesReader(w, r.Body, maxIdempotentBody))
+			if err != nil {
+				var tooLarge *http.MaxBytesError
+				if errors.As(err, &tooLarge) {
+					writeProblem(w, r, http.StatusRequestEntityTooLarge, problem.CodeBodyTooLarge, "request body is too large")
+					return
+				}
+				writeProblem(w, r, http.StatusBadRequest, problem.CodeInvalidBody, "failed to read request body")
+				return
+			}
+			r.Body = io.NopCloser(bytes.NewReader(body))
+
+			fingerprint := requestFingerprint(r, body)
+			prev, err := keys.BeginIdempotentRequest(r.Context(), userID, key, fingerprint, ttl)
+			if err != nil {
+				sugar.Errorf("begin idempotent request: %v", err)
+				writeProblem(w, r, http.StatusInternalServerError, problem.CodeInternal, "internal server error")
+				return
+			}
+			if prev != nil {
+				replayResponse(w, r, prev, fingerprint)
+				return
+			}
+
+			rec := &recordingResponseWriter{ResponseWriter: w}
+			// Запись без ответа держит ключ: освобождаем его, если обработчик не дошел до ответа
+			done := false
+			defer func() {
+				if done {
+					return
+				}
+				if err := keys.ReleaseIdempotentRequest(context.WithoutCancel(r.Context()), userID, key); err != nil {
+					sugar.Errorf("release idempotent request: %v", err)
+				}
+			}()
+			next.ServeHTTP(rec, r)
+
+			status := rec.status
+			if status == 0 {
+				status = http.StatusOK
+			}
+			if status >= http.StatusInternalServerError {
+				return
+			}
+			resp := models.IdempotentResponse{
+				Fingerprint: fingerprint,
+				Status:      status,
+				ContentType: w.Header().Get("Content-Type"),
+				Body:        rec.body.Bytes(),
+			}
+			// Ответ клиенту уже отправлен, поэтому сохраняем его, даже если клиент отключился
+			if err := keys.CompleteIdempotentRequest(context.WithoutCancel(r.Context()), userID, key, resp); err != nil {
+				sugar.Errorf("complete idempotent request: %v", err)
+				return
+			}
+			done = true
+		})
+	}
+}
+
+// replayResponse отвечает на повтор запроса по записи первого
+func replayResponse(w http.ResponseWriter, r *http.Request, prev *models.IdempotentResponse, fingerprint string) {
+	switch {
+	case prev.Fingerprint != fingerprint:
+		writeProblem(w, r, http.StatusUnprocessableEntity, problem.CodeIdempotencyKeyReused, "idempotency key is already used for a different request")
+	case prev.Status == 0:
+		writeProblem(w, r, http.StatusConflict, problem.CodeRequestInProgress, "request with this idempotency key is still in progress")
+	default:
+		if prev.ContentType != "" {
+			w.Header().Set("Content-Type", prev.ContentType)
+		}
+		w.Header().Set(IdempotentReplayedHeader, "true")
+		w.WriteHeader(prev.Status)
+		w.Write(prev.Body)
+	}
+}
+
+// requestFingerprint - хеш метода, пути, типа и тела запроса: по нему повтор отличается от другого запроса с тем же ключом
+func requestFingerprint(r *http.Request, body []byte) string {
+	h := sha256.New()
+	io.WriteString(h, r.Method+" "+r.URL.Path+"\n"+r.Header.Get("Content-Type")+"\n")
+	h.Write(body)
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+// recordingResponseWriter передает ответ клиенту и запоминает статус и тело для сохранения
+type recordingResponseWriter struct {
+	http.ResponseWriter
+	status int
+	body   bytes.Buffer
+}
+
+func (rw *recordingResponseWriter) WriteHeader(statusCode int) {
+	if rw.status == 0 {
+		rw.status = statusCode
+	}
+	rw.ResponseWriter.WriteHeader(statusCode)
+}
+
+func (rw *recordingResponseWriter) Write(p []byte) (int, error) {
+	if rw.status == 0 {
+		rw.status = http.StatusOK
+	}
+	rw.body.Write(p)
+	return rw.ResponseWriter.Write(p)
+}
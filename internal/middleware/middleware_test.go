@@ -3,11 +3,21 @@ package middleware
 import (
 	"bytes"
 	"compress/gzip"
+	"context"
+	"encoding/json"
+	"io"
 	"net/http"
 	"net/http/httptest"
+	"strconv"
 	"testing"
+	"time"
 
+	"github.com/NailUsmanov/gophermart/internal/auth"
+	"github.com/NailUsmanov/gophermart/internal/interfaces"
+	"github.com/NailUsmanov/gophermart/internal/problem"
+	"github.com/NailUsmanov/gophermart/internal/storage"
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
 	"go.uber.org/zap/zaptest"
 )
 
@@ -52,6 +62,25 @@ func TestGzipMiddleWare(t *testing.T) {
 		assert.Equal(t, "gzip", res.Header.Get("Content-Encoding"))
 		assert.Equal(t, "application/json", res.Header.Get("Content-Type"))
 	})
+
+	t.Run("broken gzip body", func(t *testing.T) {
+		req := httptest.NewRequest("POST", "/api/user/login", bytes.NewBufferString("not gzip"))
+		req.Header.Set("Content-Encoding", "gzip")
+		rec := httptest.NewRecorder()
+		GzipMiddleware(http.NotFoundHandler()).ServeHTTP(rec, req)
+
+		assert.Equal(t, http.StatusBadRequest, rec.Code)
+		assert.Equal(t, problem.CodeInvalidBody, problemCode(t, rec))
+	})
+}
+
+// problemCode проверяет, что ответ - проблема RFC 7807, и возвращает ее код
+func problemCode(t *testing.T, rec *httptest.ResponseRecorder) string {
+	t.Helper()
+	assert.Equal(t, problem.ContentType, rec.Header().Get("Content-Type"))
+	var p problem.Problem
+	require.NoError(t, json.NewDecoder(rec.Body).Decode(&p))
+	return p.Code
 }
 
 func TestLoggingMiddleware(t *testing.T) {
@@ -77,3 +106,163 @@ func TestLoggingMiddleware(t *testing.T) {
 		assert.Equal(t, http.StatusOK, res.StatusCode)
 	})
 }
+
+type fakeSessions struct {
+	interfaces.Sessions
+	states map[string]error
+}
+
+func (f *fakeSessions) TouchSession(_ context.Context, sessionID string, _ int, _ time.Duration) error {
+	return f.states[sessionID]
+}
+
+func TestAuthMiddleware(t *testing.T) {
+	tokens := auth.NewTokenManager([]byte("secret"), time.Hour)
+	sessions := &fakeSessions{states: map[string]error{
+		"active":  nil,
+		"revoked": auth.ErrSessionRevoked,
+		"expired": auth.ErrSessionExpired,
+		"unknown": auth.ErrSessionNotFound,
+	}}
+	handler := AuthMiddleware(tokens, sessions, time.Hour)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		userID, _ := r.Context().Value(UserLoginKey).(int)
+		sessionID, _ := r.Context().Value(SessionIDKey).(string)
+		w.Write([]byte(strconv.Itoa(userID) + ":" + sessionID))
+	}))
+
+	buildToken := func(sessionID string) string {
+		token, _, err := tokens.BuildToken(7, sessionID)
+		assert.NoError(t, err)
+		return token
+	}
+	expiredToken, _, err := auth.NewTokenManager([]byte("secret"), -time.Minute).BuildToken(7, "active")
+	assert.NoError(t, err)
+
+	tests := []struct {
+		name       string
+		cookie     string
+		wantStatus int
+		wantCode   string
+	}{
+		{name: "no cookie", cookie: "", wantStatus: http.StatusUnauthorized, wantCode: problem.CodeTokenMissing},
+		{name: "raw user id", cookie: "7", wantStatus: http.StatusUnauthorized, wantCode: problem.CodeTokenInvalid},
+		{name: "expired token", cookie: expiredToken, wantStatus: http.StatusUnauthorized, wantCode: problem.CodeTokenExpired},
+		{name: "revoked session", cookie: buildToken("revoked"), wantStatus: http.StatusUnauthorized, wantCode: problem.CodeSessionRevoked},
+		{name: "expired session", cookie: buildToken("expired"), wantStatus: http.StatusUnauthorized, wantCode: problem.CodeSessionExpired},
+		{name: "unknown session", cookie: buildToken("unknown"), wantStatus: http.StatusUnauthorized, wantCode: problem.CodeSessionNotFound},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodGet, "/api/user/orders", nil)
+			if tt.cookie != "" {
+				req.AddCookie(&http.Cookie{Name: auth.CookieName, Value: tt.cookie})
+			}
+			rec := httptest.NewRecorder()
+			handler.ServeHTTP(rec, req)
+
+			assert.Equal(t, tt.wantStatus, rec.Code)
+			assert.Equal(t, tt.wantCode, problemCode(t, rec))
+		})
+	}
+
+	t.Run("valid token", func(t *testing.T) {
+		req := httptest.NewRequest(http.MethodGet, "/api/user/orders", nil)
+		req.AddCookie(&http.Cookie{Name: auth.CookieName, Value: buildToken("active")})
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, req)
+
+		assert.Equal(t, http.StatusOK, rec.Code)
+		assert.Equal(t, "7:active", rec.Body.String())
+	})
+}
+
+func TestIdempotencyMiddleware(t *testing.T) {
+	calls := 0
+	status := http.StatusAccepted
+	// release держит запрос внутри обработчика, пока тест не разрешит ему ответить; entered - запрос дошел до обработчика
+	var release chan struct{}
+	entered := make(chan struct{})
+	handler := IdempotencyMiddleware(storage.NewMemStorage(), time.Hour, zaptest.NewLogger(t).Sugar())(
+		http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			calls++
+			if release != nil {
+				entered <- struct{}{}
+				<-release
+			}
+			body, _ := io.ReadAll(r.Body)
+			w.Header().Set("Content-Type", "text/plain")
+			w.WriteHeader(status)
+			w.Write([]byte("order " + string(body)))
+		}))
+
+	do := func(userID int, key, body string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodPost, "/api/user/orders", bytes.NewBufferString(body))
+		req.Header.Set("Content-Type", "text/plain")
+		if key != "" {
+			req.Header.Set(IdempotencyKeyHeader, key)
+		}
+		req = req.WithContext(context.WithValue(req.Context(), UserLoginKey, userID))
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, req)
+		return rec
+	}
+
+	t.Run("retry is replayed", func(t *testing.T) {
+		calls = 0
+		first := do(1, "k1", "12345678903")
+		second := do(1, "k1", "12345678903")
+		assert.Equal(t, 1, calls)
+		assert.Equal(t, http.StatusAccepted, second.Code)
+		assert.Equal(t, first.Body.String(), second.Body.String())
+		assert.Equal(t, "text/plain", second.Header().Get("Content-Type"))
+		assert.Equal(t, "true", second.Header().Get(IdempotentReplayedHeader))
+		assert.Empty(t, first.Header().Get(IdempotentReplayedHeader))
+	})
+
+	t.Run("different payload", func(t *testing.T) {
+		calls = 0
+		do(1, "k2", "12345678903")
+		rec := do(1, "k2", "79927398713")
+		assert.Equal(t, 1, calls)
+		assert.Equal(t, http.StatusUnprocessableEntity, rec.Code)
+		assert.Equal(t, problem.CodeIdempotencyKeyReused, problemCode(t, rec))
+	})
+
+	t.Run("keys are per user", func(t *testing.T) {
+		calls = 0
+		do(1, "k3", "12345678903")
+		rec := do(2, "k3", "79927398713")
+		assert.Equal(t, 2, calls)
+		assert.Equal(t, http.StatusAccepted, rec.Code)
+	})
+
+	t.Run("no key", func(t *testing.T) {
+		calls = 0
+		do(1, "", "12345678903")
+		do(1, "", "12345678903")
+		assert.Equal(t, 2, calls)
+	})
+
+	t.Run("server error is not stored", func(t *testing.T) {
+		calls = 0
+		status = http.StatusInternalServerError
+		do(1, "k4", "12345678903")
+		status = http.StatusAccepted
+		rec := do(1, "k4", "12345678903")
+		assert.Equal(t, 2, calls)
+		assert.Equal(t, http.StatusAccepted, rec.Code)
+	})
+
+	t.Run("retry while in progress", func(t *testing.T) {
+		release = make(chan struct{})
+		done := make(chan *httptest.ResponseRecorder)
+		go func() { done <- do(1, "k5", "12345678903") }()
+		<-entered
+		rec := do(1, "k5", "12345678903")
+		assert.Equal(t, http.StatusConflict, rec.Code)
+		assert.Equal(t, problem.CodeRequestInProgress, problemCode(t, rec))
+		close(release)
+		assert.Equal(t, http.StatusAccepted, (<-done).Code)
+		release = nil
+	})
+}
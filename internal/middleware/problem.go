@@ -0,0 +1,12 @@
+package middleware
+
+import (
+	"net/http"
+
+	"github.com/NailUsmanov/gophermart/internal/problem"
+)
+
+// writeProblem отвечает проблемой RFC 7807. Статус к этому моменту уже отправлен, поэтому ошибку записи тела не обрабатываем
+func writeProblem(w http.ResponseWriter, r *http.Request, status int, code, detail string) {
+	_ = problem.Write(w, r, problem.New(status, code, detail))
+}
@@ -87,6 +87,21 @@ func (mr *MockServiceStorageMockRecorder) GetOrdersByUserID(ctx, userID any) *go
 	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "GetOrdersByUserID", reflect.TypeOf((*MockServiceStorage)(nil).GetOrdersByUserID), ctx, userID)
 }
 
+// ListUserOrders mocks base method.
+func (m *MockServiceStorage) ListUserOrders(ctx context.Context, userID int, f storage.OrderFilter) (storage.OrderPage, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "ListUserOrders", ctx, userID, f)
+	ret0, _ := ret[0].(storage.OrderPage)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// ListUserOrders indicates an expected call of ListUserOrders.
+func (mr *MockServiceStorageMockRecorder) ListUserOrders(ctx, userID, f any) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "ListUserOrders", reflect.TypeOf((*MockServiceStorage)(nil).ListUserOrders), ctx, userID, f)
+}
+
 // MockServiceInterface is a mock of ServiceInterface interface.
 type MockServiceInterface struct {
 	ctrl     *gomock.Controller
@@ -12,8 +12,10 @@ package mocks
 import (
 	context "context"
 	reflect "reflect"
+	time "time"
 
 	models "github.com/NailUsmanov/gophermart/internal/models"
+	money "github.com/NailUsmanov/gophermart/internal/money"
 	storage "github.com/NailUsmanov/gophermart/internal/storage"
 	gomock "go.uber.org/mock/gomock"
 	zap "go.uber.org/zap"
@@ -88,6 +90,21 @@ func (mr *MockOrderOptionMockRecorder) GetOrdersByUserID(ctx, userID any) *gomoc
 	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "GetOrdersByUserID", reflect.TypeOf((*MockOrderOption)(nil).GetOrdersByUserID), ctx, userID)
 }
 
+// ListUserOrders mocks base method.
+func (m *MockOrderOption) ListUserOrders(ctx context.Context, userID int, f storage.OrderFilter) (storage.OrderPage, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "ListUserOrders", ctx, userID, f)
+	ret0, _ := ret[0].(storage.OrderPage)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// ListUserOrders indicates an expected call of ListUserOrders.
+func (mr *MockOrderOptionMockRecorder) ListUserOrders(ctx, userID, f any) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "ListUserOrders", reflect.TypeOf((*MockOrderOption)(nil).ListUserOrders), ctx, userID, f)
+}
+
 // MockWorkerAccrual is a mock of WorkerAccrual interface.
 type MockWorkerAccrual struct {
 	ctrl     *gomock.Controller
@@ -112,33 +129,91 @@ func (m *MockWorkerAccrual) EXPECT() *MockWorkerAccrualMockRecorder {
 	return m.recorder
 }
 
-// GetOrdersForAccrualUpdate mocks base method.
-func (m *MockWorkerAccrual) GetOrdersForAccrualUpdate(ctx context.Context) ([]storage.Order, error) {
+// ClaimOrdersForAccrual mocks base method.
+func (m *MockWorkerAccrual) ClaimOrdersForAccrual(ctx context.Context, owner string, limit int, lease time.Duration) ([]storage.Order, error) {
 	m.ctrl.T.Helper()
-	ret := m.ctrl.Call(m, "GetOrdersForAccrualUpdate", ctx)
+	ret := m.ctrl.Call(m, "ClaimOrdersForAccrual", ctx, owner, limit, lease)
 	ret0, _ := ret[0].([]storage.Order)
 	ret1, _ := ret[1].(error)
 	return ret0, ret1
 }
 
-// GetOrdersForAccrualUpdate indicates an expected call of GetOrdersForAccrualUpdate.
-func (mr *MockWorkerAccrualMockRecorder) GetOrdersForAccrualUpdate(ctx any) *gomock.Call {
+// ClaimOrdersForAccrual indicates an expected call of ClaimOrdersForAccrual.
+func (mr *MockWorkerAccrualMockRecorder) ClaimOrdersForAccrual(ctx, owner, limit, lease any) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "ClaimOrdersForAccrual", reflect.TypeOf((*MockWorkerAccrual)(nil).ClaimOrdersForAccrual), ctx, owner, limit, lease)
+}
+
+// GetOrderStatusHistory mocks base method.
+func (m *MockWorkerAccrual) GetOrderStatusHistory(ctx context.Context, number string) ([]storage.StatusTransition, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "GetOrderStatusHistory", ctx, number)
+	ret0, _ := ret[0].([]storage.StatusTransition)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// GetOrderStatusHistory indicates an expected call of GetOrderStatusHistory.
+func (mr *MockWorkerAccrualMockRecorder) GetOrderStatusHistory(ctx, number any) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "GetOrderStatusHistory", reflect.TypeOf((*MockWorkerAccrual)(nil).GetOrderStatusHistory), ctx, number)
+}
+
+// NewOrders mocks base method.
+func (m *MockWorkerAccrual) NewOrders(ctx context.Context) (<-chan string, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "NewOrders", ctx)
+	ret0, _ := ret[0].(<-chan string)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// NewOrders indicates an expected call of NewOrders.
+func (mr *MockWorkerAccrualMockRecorder) NewOrders(ctx any) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "NewOrders", reflect.TypeOf((*MockWorkerAccrual)(nil).NewOrders), ctx)
+}
+
+// ReleaseOrderLease mocks base method.
+func (m *MockWorkerAccrual) ReleaseOrderLease(ctx context.Context, owner, number string) error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "ReleaseOrderLease", ctx, owner, number)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// ReleaseOrderLease indicates an expected call of ReleaseOrderLease.
+func (mr *MockWorkerAccrualMockRecorder) ReleaseOrderLease(ctx, owner, number any) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "ReleaseOrderLease", reflect.TypeOf((*MockWorkerAccrual)(nil).ReleaseOrderLease), ctx, owner, number)
+}
+
+// ScheduleNextCheck mocks base method.
+func (m *MockWorkerAccrual) ScheduleNextCheck(ctx context.Context, number string, delay time.Duration) error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "ScheduleNextCheck", ctx, number, delay)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// ScheduleNextCheck indicates an expected call of ScheduleNextCheck.
+func (mr *MockWorkerAccrualMockRecorder) ScheduleNextCheck(ctx, number, delay any) *gomock.Call {
 	mr.mock.ctrl.T.Helper()
-	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "GetOrdersForAccrualUpdate", reflect.TypeOf((*MockWorkerAccrual)(nil).GetOrdersForAccrualUpdate), ctx)
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "ScheduleNextCheck", reflect.TypeOf((*MockWorkerAccrual)(nil).ScheduleNextCheck), ctx, number, delay)
 }
 
 // UpdateOrderStatus mocks base method.
-func (m *MockWorkerAccrual) UpdateOrderStatus(ctx context.Context, number, status string, accrual *float64) error {
+func (m *MockWorkerAccrual) UpdateOrderStatus(ctx context.Context, t storage.StatusTransition, accrual *money.Amount) error {
 	m.ctrl.T.Helper()
-	ret := m.ctrl.Call(m, "UpdateOrderStatus", ctx, number, status, accrual)
+	ret := m.ctrl.Call(m, "UpdateOrderStatus", ctx, t, accrual)
 	ret0, _ := ret[0].(error)
 	return ret0
 }
 
 // UpdateOrderStatus indicates an expected call of UpdateOrderStatus.
-func (mr *MockWorkerAccrualMockRecorder) UpdateOrderStatus(ctx, number, status, accrual any) *gomock.Call {
+func (mr *MockWorkerAccrualMockRecorder) UpdateOrderStatus(ctx, t, accrual any) *gomock.Call {
 	mr.mock.ctrl.T.Helper()
-	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "UpdateOrderStatus", reflect.TypeOf((*MockWorkerAccrual)(nil).UpdateOrderStatus), ctx, number, status, accrual)
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "UpdateOrderStatus", reflect.TypeOf((*MockWorkerAccrual)(nil).UpdateOrderStatus), ctx, t, accrual)
 }
 
 // MockBalanceIndicator is a mock of BalanceIndicator interface.
@@ -166,7 +241,7 @@ func (m *MockBalanceIndicator) EXPECT() *MockBalanceIndicatorMockRecorder {
 }
 
 // AddWithdrawOrder mocks base method.
-func (m *MockBalanceIndicator) AddWithdrawOrder(ctx context.Context, userID int, orderNumber string, sum float64) error {
+func (m *MockBalanceIndicator) AddWithdrawOrder(ctx context.Context, userID int, orderNumber string, sum money.Amount) error {
 	m.ctrl.T.Helper()
 	ret := m.ctrl.Call(m, "AddWithdrawOrder", ctx, userID, orderNumber, sum)
 	ret0, _ := ret[0].(error)
@@ -195,11 +270,11 @@ func (mr *MockBalanceIndicatorMockRecorder) GetAllUserWithdrawals(ctx, userID an
 }
 
 // GetUserBalance mocks base method.
-func (m *MockBalanceIndicator) GetUserBalance(ctx context.Context, userID int) (float64, float64, error) {
+func (m *MockBalanceIndicator) GetUserBalance(ctx context.Context, userID int) (money.Amount, money.Amount, error) {
 	m.ctrl.T.Helper()
 	ret := m.ctrl.Call(m, "GetUserBalance", ctx, userID)
-	ret0, _ := ret[0].(float64)
-	ret1, _ := ret[1].(float64)
+	ret0, _ := ret[0].(money.Amount)
+	ret1, _ := ret[1].(money.Amount)
 	ret2, _ := ret[2].(error)
 	return ret0, ret1, ret2
 }
@@ -211,10 +286,10 @@ func (mr *MockBalanceIndicatorMockRecorder) GetUserBalance(ctx, userID any) *gom
 }
 
 // GetUserWithDrawns mocks base method.
-func (m *MockBalanceIndicator) GetUserWithDrawns(ctx context.Context, userID int) (float64, error) {
+func (m *MockBalanceIndicator) GetUserWithDrawns(ctx context.Context, userID int) (money.Amount, error) {
 	m.ctrl.T.Helper()
 	ret := m.ctrl.Call(m, "GetUserWithDrawns", ctx, userID)
-	ret0, _ := ret[0].(float64)
+	ret0, _ := ret[0].(money.Amount)
 	ret1, _ := ret[1].(error)
 	return ret0, ret1
 }
@@ -250,7 +325,7 @@ func (m *MockWithdrawLogic) EXPECT() *MockWithdrawLogicMockRecorder {
 }
 
 // AddWithdrawOrder mocks base method.
-func (m *MockWithdrawLogic) AddWithdrawOrder(ctx context.Context, userID int, number string, sum float64) error {
+func (m *MockWithdrawLogic) AddWithdrawOrder(ctx context.Context, userID int, number string, sum money.Amount) error {
 	m.ctrl.T.Helper()
 	ret := m.ctrl.Call(m, "AddWithdrawOrder", ctx, userID, number, sum)
 	ret0, _ := ret[0].(error)
@@ -280,11 +355,11 @@ func (mr *MockWithdrawLogicMockRecorder) CheckExistOrder(ctx, numberOrder any) *
 }
 
 // GetUserBalance mocks base method.
-func (m *MockWithdrawLogic) GetUserBalance(ctx context.Context, userID int) (float64, float64, error) {
+func (m *MockWithdrawLogic) GetUserBalance(ctx context.Context, userID int) (money.Amount, money.Amount, error) {
 	m.ctrl.T.Helper()
 	ret := m.ctrl.Call(m, "GetUserBalance", ctx, userID)
-	ret0, _ := ret[0].(float64)
-	ret1, _ := ret[1].(float64)
+	ret0, _ := ret[0].(money.Amount)
+	ret1, _ := ret[1].(money.Amount)
 	ret2, _ := ret[2].(error)
 	return ret0, ret1, ret2
 }
@@ -334,6 +409,152 @@ func (mr *MockWithdrawalFetcherMockRecorder) GetAllUserWithdrawals(ctx, userID a
 	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "GetAllUserWithdrawals", reflect.TypeOf((*MockWithdrawalFetcher)(nil).GetAllUserWithdrawals), ctx, userID)
 }
 
+// ListUserWithdrawals mocks base method.
+func (m *MockWithdrawalFetcher) ListUserWithdrawals(ctx context.Context, userID int, f storage.WithdrawalFilter) (storage.WithdrawalPage, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "ListUserWithdrawals", ctx, userID, f)
+	ret0, _ := ret[0].(storage.WithdrawalPage)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// ListUserWithdrawals indicates an expected call of ListUserWithdrawals.
+func (mr *MockWithdrawalFetcherMockRecorder) ListUserWithdrawals(ctx, userID, f any) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "ListUserWithdrawals", reflect.TypeOf((*MockWithdrawalFetcher)(nil).ListUserWithdrawals), ctx, userID, f)
+}
+
+// MockBalanceReconciler is a mock of BalanceReconciler interface.
+type MockBalanceReconciler struct {
+	ctrl     *gomock.Controller
+	recorder *MockBalanceReconcilerMockRecorder
+	isgomock struct{}
+}
+
+// MockBalanceReconcilerMockRecorder is the mock recorder for MockBalanceReconciler.
+type MockBalanceReconcilerMockRecorder struct {
+	mock *MockBalanceReconciler
+}
+
+// NewMockBalanceReconciler creates a new mock instance.
+func NewMockBalanceReconciler(ctrl *gomock.Controller) *MockBalanceReconciler {
+	mock := &MockBalanceReconciler{ctrl: ctrl}
+	mock.recorder = &MockBalanceReconcilerMockRecorder{mock}
+	return mock
+}
+
+// EXPECT returns an object that allows the caller to indicate expected use.
+func (m *MockBalanceReconciler) EXPECT() *MockBalanceReconcilerMockRecorder {
+	return m.recorder
+}
+
+// ReconcileBalances mocks base method.
+func (m *MockBalanceReconciler) ReconcileBalances(ctx context.Context, repair bool) ([]models.BalanceDrift, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "ReconcileBalances", ctx, repair)
+	ret0, _ := ret[0].([]models.BalanceDrift)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// ReconcileBalances indicates an expected call of ReconcileBalances.
+func (mr *MockBalanceReconcilerMockRecorder) ReconcileBalances(ctx, repair any) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "ReconcileBalances", reflect.TypeOf((*MockBalanceReconciler)(nil).ReconcileBalances), ctx, repair)
+}
+
+// MockAccrualQuarantine is a mock of AccrualQuarantine interface.
+type MockAccrualQuarantine struct {
+	ctrl     *gomock.Controller
+	recorder *MockAccrualQuarantineMockRecorder
+	isgomock struct{}
+}
+
+// MockAccrualQuarantineMockRecorder is the mock recorder for MockAccrualQuarantine.
+type MockAccrualQuarantineMockRecorder struct {
+	mock *MockAccrualQuarantine
+}
+
+// NewMockAccrualQuarantine creates a new mock instance.
+func NewMockAccrualQuarantine(ctrl *gomock.Controller) *MockAccrualQuarantine {
+	mock := &MockAccrualQuarantine{ctrl: ctrl}
+	mock.recorder = &MockAccrualQuarantineMockRecorder{mock}
+	return mock
+}
+
+// EXPECT returns an object that allows the caller to indicate expected use.
+func (m *MockAccrualQuarantine) EXPECT() *MockAccrualQuarantineMockRecorder {
+	return m.recorder
+}
+
+// ListQuarantinedResponses mocks base method.
+func (m *MockAccrualQuarantine) ListQuarantinedResponses(ctx context.Context, limit int) ([]models.QuarantinedResponse, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "ListQuarantinedResponses", ctx, limit)
+	ret0, _ := ret[0].([]models.QuarantinedResponse)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// ListQuarantinedResponses indicates an expected call of ListQuarantinedResponses.
+func (mr *MockAccrualQuarantineMockRecorder) ListQuarantinedResponses(ctx, limit any) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "ListQuarantinedResponses", reflect.TypeOf((*MockAccrualQuarantine)(nil).ListQuarantinedResponses), ctx, limit)
+}
+
+// QuarantineAccrualResponse mocks base method.
+func (m *MockAccrualQuarantine) QuarantineAccrualResponse(ctx context.Context, q models.QuarantinedResponse) error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "QuarantineAccrualResponse", ctx, q)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// QuarantineAccrualResponse indicates an expected call of QuarantineAccrualResponse.
+func (mr *MockAccrualQuarantineMockRecorder) QuarantineAccrualResponse(ctx, q any) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "QuarantineAccrualResponse", reflect.TypeOf((*MockAccrualQuarantine)(nil).QuarantineAccrualResponse), ctx, q)
+}
+
+// MockStuckOrderLister is a mock of StuckOrderLister interface.
+type MockStuckOrderLister struct {
+	ctrl     *gomock.Controller
+	recorder *MockStuckOrderListerMockRecorder
+	isgomock struct{}
+}
+
+// MockStuckOrderListerMockRecorder is the mock recorder for MockStuckOrderLister.
+type MockStuckOrderListerMockRecorder struct {
+	mock *MockStuckOrderLister
+}
+
+// NewMockStuckOrderLister creates a new mock instance.
+func NewMockStuckOrderLister(ctrl *gomock.Controller) *MockStuckOrderLister {
+	mock := &MockStuckOrderLister{ctrl: ctrl}
+	mock.recorder = &MockStuckOrderListerMockRecorder{mock}
+	return mock
+}
+
+// EXPECT returns an object that allows the caller to indicate expected use.
+func (m *MockStuckOrderLister) EXPECT() *MockStuckOrderListerMockRecorder {
+	return m.recorder
+}
+
+// ListStuckOrders mocks base method.
+func (m *MockStuckOrderLister) ListStuckOrders(ctx context.Context, limit int) ([]models.StuckOrder, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "ListStuckOrders", ctx, limit)
+	ret0, _ := ret[0].([]models.StuckOrder)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// ListStuckOrders indicates an expected call of ListStuckOrders.
+func (mr *MockStuckOrderListerMockRecorder) ListStuckOrders(ctx, limit any) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "ListStuckOrders", reflect.TypeOf((*MockStuckOrderLister)(nil).ListStuckOrders), ctx, limit)
+}
+
 // MockStorage is a mock of Storage interface.
 type MockStorage struct {
 	ctrl     *gomock.Controller
@@ -359,7 +580,7 @@ func (m *MockStorage) EXPECT() *MockStorageMockRecorder {
 }
 
 // AddWithdrawOrder mocks base method.
-func (m *MockStorage) AddWithdrawOrder(ctx context.Context, userID int, number string, sum float64) error {
+func (m *MockStorage) AddWithdrawOrder(ctx context.Context, userID int, number string, sum money.Amount) error {
 	m.ctrl.T.Helper()
 	ret := m.ctrl.Call(m, "AddWithdrawOrder", ctx, userID, number, sum)
 	ret0, _ := ret[0].(error)
@@ -372,6 +593,21 @@ func (mr *MockStorageMockRecorder) AddWithdrawOrder(ctx, userID, number, sum any
 	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "AddWithdrawOrder", reflect.TypeOf((*MockStorage)(nil).AddWithdrawOrder), ctx, userID, number, sum)
 }
 
+// BeginIdempotentRequest mocks base method.
+func (m *MockStorage) BeginIdempotentRequest(ctx context.Context, userID int, key, fingerprint string, ttl time.Duration) (*models.IdempotentResponse, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "BeginIdempotentRequest", ctx, userID, key, fingerprint, ttl)
+	ret0, _ := ret[0].(*models.IdempotentResponse)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// BeginIdempotentRequest indicates an expected call of BeginIdempotentRequest.
+func (mr *MockStorageMockRecorder) BeginIdempotentRequest(ctx, userID, key, fingerprint, ttl any) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "BeginIdempotentRequest", reflect.TypeOf((*MockStorage)(nil).BeginIdempotentRequest), ctx, userID, key, fingerprint, ttl)
+}
+
 // CheckExistOrder mocks base method.
 func (m *MockStorage) CheckExistOrder(ctx context.Context, numberOrder string) (bool, int, error) {
 	m.ctrl.T.Helper()
@@ -388,18 +624,33 @@ func (mr *MockStorageMockRecorder) CheckExistOrder(ctx, numberOrder any) *gomock
 	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "CheckExistOrder", reflect.TypeOf((*MockStorage)(nil).CheckExistOrder), ctx, numberOrder)
 }
 
-// CheckHashMatch mocks base method.
-func (m *MockStorage) CheckHashMatch(ctx context.Context, login, password string) error {
+// ClaimOrdersForAccrual mocks base method.
+func (m *MockStorage) ClaimOrdersForAccrual(ctx context.Context, owner string, limit int, lease time.Duration) ([]storage.Order, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "ClaimOrdersForAccrual", ctx, owner, limit, lease)
+	ret0, _ := ret[0].([]storage.Order)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// ClaimOrdersForAccrual indicates an expected call of ClaimOrdersForAccrual.
+func (mr *MockStorageMockRecorder) ClaimOrdersForAccrual(ctx, owner, limit, lease any) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "ClaimOrdersForAccrual", reflect.TypeOf((*MockStorage)(nil).ClaimOrdersForAccrual), ctx, owner, limit, lease)
+}
+
+// CompleteIdempotentRequest mocks base method.
+func (m *MockStorage) CompleteIdempotentRequest(ctx context.Context, userID int, key string, resp models.IdempotentResponse) error {
 	m.ctrl.T.Helper()
-	ret := m.ctrl.Call(m, "CheckHashMatch", ctx, login, password)
+	ret := m.ctrl.Call(m, "CompleteIdempotentRequest", ctx, userID, key, resp)
 	ret0, _ := ret[0].(error)
 	return ret0
 }
 
-// CheckHashMatch indicates an expected call of CheckHashMatch.
-func (mr *MockStorageMockRecorder) CheckHashMatch(ctx, login, password any) *gomock.Call {
+// CompleteIdempotentRequest indicates an expected call of CompleteIdempotentRequest.
+func (mr *MockStorageMockRecorder) CompleteIdempotentRequest(ctx, userID, key, resp any) *gomock.Call {
 	mr.mock.ctrl.T.Helper()
-	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "CheckHashMatch", reflect.TypeOf((*MockStorage)(nil).CheckHashMatch), ctx, login, password)
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "CompleteIdempotentRequest", reflect.TypeOf((*MockStorage)(nil).CompleteIdempotentRequest), ctx, userID, key, resp)
 }
 
 // CreateNewOrder mocks base method.
@@ -416,6 +667,21 @@ func (mr *MockStorageMockRecorder) CreateNewOrder(ctx, userNumber, numberOrder,
 	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "CreateNewOrder", reflect.TypeOf((*MockStorage)(nil).CreateNewOrder), ctx, userNumber, numberOrder, sugar)
 }
 
+// CreateSession mocks base method.
+func (m *MockStorage) CreateSession(ctx context.Context, userID int, userAgent, ip string, ttl time.Duration) (string, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "CreateSession", ctx, userID, userAgent, ip, ttl)
+	ret0, _ := ret[0].(string)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// CreateSession indicates an expected call of CreateSession.
+func (mr *MockStorageMockRecorder) CreateSession(ctx, userID, userAgent, ip, ttl any) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "CreateSession", reflect.TypeOf((*MockStorage)(nil).CreateSession), ctx, userID, userAgent, ip, ttl)
+}
+
 // GetAllUserWithdrawals mocks base method.
 func (m *MockStorage) GetAllUserWithdrawals(ctx context.Context, userID int) ([]models.UserWithDraw, error) {
 	m.ctrl.T.Helper()
@@ -431,42 +697,42 @@ func (mr *MockStorageMockRecorder) GetAllUserWithdrawals(ctx, userID any) *gomoc
 	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "GetAllUserWithdrawals", reflect.TypeOf((*MockStorage)(nil).GetAllUserWithdrawals), ctx, userID)
 }
 
-// GetOrdersByUserID mocks base method.
-func (m *MockStorage) GetOrdersByUserID(ctx context.Context, userID int) ([]storage.Order, error) {
+// GetOrderStatusHistory mocks base method.
+func (m *MockStorage) GetOrderStatusHistory(ctx context.Context, number string) ([]storage.StatusTransition, error) {
 	m.ctrl.T.Helper()
-	ret := m.ctrl.Call(m, "GetOrdersByUserID", ctx, userID)
-	ret0, _ := ret[0].([]storage.Order)
+	ret := m.ctrl.Call(m, "GetOrderStatusHistory", ctx, number)
+	ret0, _ := ret[0].([]storage.StatusTransition)
 	ret1, _ := ret[1].(error)
 	return ret0, ret1
 }
 
-// GetOrdersByUserID indicates an expected call of GetOrdersByUserID.
-func (mr *MockStorageMockRecorder) GetOrdersByUserID(ctx, userID any) *gomock.Call {
+// GetOrderStatusHistory indicates an expected call of GetOrderStatusHistory.
+func (mr *MockStorageMockRecorder) GetOrderStatusHistory(ctx, number any) *gomock.Call {
 	mr.mock.ctrl.T.Helper()
-	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "GetOrdersByUserID", reflect.TypeOf((*MockStorage)(nil).GetOrdersByUserID), ctx, userID)
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "GetOrderStatusHistory", reflect.TypeOf((*MockStorage)(nil).GetOrderStatusHistory), ctx, number)
 }
 
-// GetOrdersForAccrualUpdate mocks base method.
-func (m *MockStorage) GetOrdersForAccrualUpdate(ctx context.Context) ([]storage.Order, error) {
+// GetOrdersByUserID mocks base method.
+func (m *MockStorage) GetOrdersByUserID(ctx context.Context, userID int) ([]storage.Order, error) {
 	m.ctrl.T.Helper()
-	ret := m.ctrl.Call(m, "GetOrdersForAccrualUpdate", ctx)
+	ret := m.ctrl.Call(m, "GetOrdersByUserID", ctx, userID)
 	ret0, _ := ret[0].([]storage.Order)
 	ret1, _ := ret[1].(error)
 	return ret0, ret1
 }
 
-// GetOrdersForAccrualUpdate indicates an expected call of GetOrdersForAccrualUpdate.
-func (mr *MockStorageMockRecorder) GetOrdersForAccrualUpdate(ctx any) *gomock.Call {
+// GetOrdersByUserID indicates an expected call of GetOrdersByUserID.
+func (mr *MockStorageMockRecorder) GetOrdersByUserID(ctx, userID any) *gomock.Call {
 	mr.mock.ctrl.T.Helper()
-	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "GetOrdersForAccrualUpdate", reflect.TypeOf((*MockStorage)(nil).GetOrdersForAccrualUpdate), ctx)
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "GetOrdersByUserID", reflect.TypeOf((*MockStorage)(nil).GetOrdersByUserID), ctx, userID)
 }
 
 // GetUserBalance mocks base method.
-func (m *MockStorage) GetUserBalance(ctx context.Context, userID int) (float64, float64, error) {
+func (m *MockStorage) GetUserBalance(ctx context.Context, userID int) (money.Amount, money.Amount, error) {
 	m.ctrl.T.Helper()
 	ret := m.ctrl.Call(m, "GetUserBalance", ctx, userID)
-	ret0, _ := ret[0].(float64)
-	ret1, _ := ret[1].(float64)
+	ret0, _ := ret[0].(money.Amount)
+	ret1, _ := ret[1].(money.Amount)
 	ret2, _ := ret[2].(error)
 	return ret0, ret1, ret2
 }
@@ -508,10 +774,10 @@ func (mr *MockStorageMockRecorder) GetUserIDByLogin(ctx, login any) *gomock.Call
 }
 
 // GetUserWithDrawns mocks base method.
-func (m *MockStorage) GetUserWithDrawns(ctx context.Context, userID int) (float64, error) {
+func (m *MockStorage) GetUserWithDrawns(ctx context.Context, userID int) (money.Amount, error) {
 	m.ctrl.T.Helper()
 	ret := m.ctrl.Call(m, "GetUserWithDrawns", ctx, userID)
-	ret0, _ := ret[0].(float64)
+	ret0, _ := ret[0].(money.Amount)
 	ret1, _ := ret[1].(error)
 	return ret0, ret1
 }
@@ -522,6 +788,125 @@ func (mr *MockStorageMockRecorder) GetUserWithDrawns(ctx, userID any) *gomock.Ca
 	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "GetUserWithDrawns", reflect.TypeOf((*MockStorage)(nil).GetUserWithDrawns), ctx, userID)
 }
 
+// ListQuarantinedResponses mocks base method.
+func (m *MockStorage) ListQuarantinedResponses(ctx context.Context, limit int) ([]models.QuarantinedResponse, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "ListQuarantinedResponses", ctx, limit)
+	ret0, _ := ret[0].([]models.QuarantinedResponse)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// ListQuarantinedResponses indicates an expected call of ListQuarantinedResponses.
+func (mr *MockStorageMockRecorder) ListQuarantinedResponses(ctx, limit any) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "ListQuarantinedResponses", reflect.TypeOf((*MockStorage)(nil).ListQuarantinedResponses), ctx, limit)
+}
+
+// ListSessions mocks base method.
+func (m *MockStorage) ListSessions(ctx context.Context, userID int) ([]models.Session, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "ListSessions", ctx, userID)
+	ret0, _ := ret[0].([]models.Session)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// ListSessions indicates an expected call of ListSessions.
+func (mr *MockStorageMockRecorder) ListSessions(ctx, userID any) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "ListSessions", reflect.TypeOf((*MockStorage)(nil).ListSessions), ctx, userID)
+}
+
+// ListStuckOrders mocks base method.
+func (m *MockStorage) ListStuckOrders(ctx context.Context, limit int) ([]models.StuckOrder, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "ListStuckOrders", ctx, limit)
+	ret0, _ := ret[0].([]models.StuckOrder)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// ListStuckOrders indicates an expected call of ListStuckOrders.
+func (mr *MockStorageMockRecorder) ListStuckOrders(ctx, limit any) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "ListStuckOrders", reflect.TypeOf((*MockStorage)(nil).ListStuckOrders), ctx, limit)
+}
+
+// ListUserOrders mocks base method.
+func (m *MockStorage) ListUserOrders(ctx context.Context, userID int, f storage.OrderFilter) (storage.OrderPage, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "ListUserOrders", ctx, userID, f)
+	ret0, _ := ret[0].(storage.OrderPage)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// ListUserOrders indicates an expected call of ListUserOrders.
+func (mr *MockStorageMockRecorder) ListUserOrders(ctx, userID, f any) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "ListUserOrders", reflect.TypeOf((*MockStorage)(nil).ListUserOrders), ctx, userID, f)
+}
+
+// ListUserWithdrawals mocks base method.
+func (m *MockStorage) ListUserWithdrawals(ctx context.Context, userID int, f storage.WithdrawalFilter) (storage.WithdrawalPage, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "ListUserWithdrawals", ctx, userID, f)
+	ret0, _ := ret[0].(storage.WithdrawalPage)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// ListUserWithdrawals indicates an expected call of ListUserWithdrawals.
+func (mr *MockStorageMockRecorder) ListUserWithdrawals(ctx, userID, f any) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "ListUserWithdrawals", reflect.TypeOf((*MockStorage)(nil).ListUserWithdrawals), ctx, userID, f)
+}
+
+// NewOrders mocks base method.
+func (m *MockStorage) NewOrders(ctx context.Context) (<-chan string, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "NewOrders", ctx)
+	ret0, _ := ret[0].(<-chan string)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// NewOrders indicates an expected call of NewOrders.
+func (mr *MockStorageMockRecorder) NewOrders(ctx any) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "NewOrders", reflect.TypeOf((*MockStorage)(nil).NewOrders), ctx)
+}
+
+// QuarantineAccrualResponse mocks base method.
+func (m *MockStorage) QuarantineAccrualResponse(ctx context.Context, q models.QuarantinedResponse) error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "QuarantineAccrualResponse", ctx, q)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// QuarantineAccrualResponse indicates an expected call of QuarantineAccrualResponse.
+func (mr *MockStorageMockRecorder) QuarantineAccrualResponse(ctx, q any) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "QuarantineAccrualResponse", reflect.TypeOf((*MockStorage)(nil).QuarantineAccrualResponse), ctx, q)
+}
+
+// ReconcileBalances mocks base method.
+func (m *MockStorage) ReconcileBalances(ctx context.Context, repair bool) ([]models.BalanceDrift, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "ReconcileBalances", ctx, repair)
+	ret0, _ := ret[0].([]models.BalanceDrift)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// ReconcileBalances indicates an expected call of ReconcileBalances.
+func (mr *MockStorageMockRecorder) ReconcileBalances(ctx, repair any) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "ReconcileBalances", reflect.TypeOf((*MockStorage)(nil).ReconcileBalances), ctx, repair)
+}
+
 // Registration mocks base method.
 func (m *MockStorage) Registration(ctx context.Context, login, password string) error {
 	m.ctrl.T.Helper()
@@ -536,16 +921,100 @@ func (mr *MockStorageMockRecorder) Registration(ctx, login, password any) *gomoc
 	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "Registration", reflect.TypeOf((*MockStorage)(nil).Registration), ctx, login, password)
 }
 
+// ReleaseIdempotentRequest mocks base method.
+func (m *MockStorage) ReleaseIdempotentRequest(ctx context.Context, userID int, key string) error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "ReleaseIdempotentRequest", ctx, userID, key)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// ReleaseIdempotentRequest indicates an expected call of ReleaseIdempotentRequest.
+func (mr *MockStorageMockRecorder) ReleaseIdempotentRequest(ctx, userID, key any) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "ReleaseIdempotentRequest", reflect.TypeOf((*MockStorage)(nil).ReleaseIdempotentRequest), ctx, userID, key)
+}
+
+// ReleaseOrderLease mocks base method.
+func (m *MockStorage) ReleaseOrderLease(ctx context.Context, owner, number string) error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "ReleaseOrderLease", ctx, owner, number)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// ReleaseOrderLease indicates an expected call of ReleaseOrderLease.
+func (mr *MockStorageMockRecorder) ReleaseOrderLease(ctx, owner, number any) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "ReleaseOrderLease", reflect.TypeOf((*MockStorage)(nil).ReleaseOrderLease), ctx, owner, number)
+}
+
+// RevokeSession mocks base method.
+func (m *MockStorage) RevokeSession(ctx context.Context, userID int, sessionID string) error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "RevokeSession", ctx, userID, sessionID)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// RevokeSession indicates an expected call of RevokeSession.
+func (mr *MockStorageMockRecorder) RevokeSession(ctx, userID, sessionID any) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "RevokeSession", reflect.TypeOf((*MockStorage)(nil).RevokeSession), ctx, userID, sessionID)
+}
+
+// ScheduleNextCheck mocks base method.
+func (m *MockStorage) ScheduleNextCheck(ctx context.Context, number string, delay time.Duration) error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "ScheduleNextCheck", ctx, number, delay)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// ScheduleNextCheck indicates an expected call of ScheduleNextCheck.
+func (mr *MockStorageMockRecorder) ScheduleNextCheck(ctx, number, delay any) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "ScheduleNextCheck", reflect.TypeOf((*MockStorage)(nil).ScheduleNextCheck), ctx, number, delay)
+}
+
+// TouchSession mocks base method.
+func (m *MockStorage) TouchSession(ctx context.Context, sessionID string, userID int, ttl time.Duration) error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "TouchSession", ctx, sessionID, userID, ttl)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// TouchSession indicates an expected call of TouchSession.
+func (mr *MockStorageMockRecorder) TouchSession(ctx, sessionID, userID, ttl any) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "TouchSession", reflect.TypeOf((*MockStorage)(nil).TouchSession), ctx, sessionID, userID, ttl)
+}
+
 // UpdateOrderStatus mocks base method.
-func (m *MockStorage) UpdateOrderStatus(ctx context.Context, number, status string, accrual *float64) error {
+func (m *MockStorage) UpdateOrderStatus(ctx context.Context, t storage.StatusTransition, accrual *money.Amount) error {
 	m.ctrl.T.Helper()
-	ret := m.ctrl.Call(m, "UpdateOrderStatus", ctx, number, status, accrual)
+	ret := m.ctrl.Call(m, "UpdateOrderStatus", ctx, t, accrual)
 	ret0, _ := ret[0].(error)
 	return ret0
 }
 
 // UpdateOrderStatus indicates an expected call of UpdateOrderStatus.
-func (mr *MockStorageMockRecorder) UpdateOrderStatus(ctx, number, status, accrual any) *gomock.Call {
+func (mr *MockStorageMockRecorder) UpdateOrderStatus(ctx, t, accrual any) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "UpdateOrderStatus", reflect.TypeOf((*MockStorage)(nil).UpdateOrderStatus), ctx, t, accrual)
+}
+
+// UpdatePasswordHash mocks base method.
+func (m *MockStorage) UpdatePasswordHash(ctx context.Context, login, passwordHash string) error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "UpdatePasswordHash", ctx, login, passwordHash)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// UpdatePasswordHash indicates an expected call of UpdatePasswordHash.
+func (mr *MockStorageMockRecorder) UpdatePasswordHash(ctx, login, passwordHash any) *gomock.Call {
 	mr.mock.ctrl.T.Helper()
-	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "UpdateOrderStatus", reflect.TypeOf((*MockStorage)(nil).UpdateOrderStatus), ctx, number, status, accrual)
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "UpdatePasswordHash", reflect.TypeOf((*MockStorage)(nil).UpdatePasswordHash), ctx, login, passwordHash)
 }
@@ -1,19 +1,71 @@
 package models
 
-import "time"
+import (
+	"time"
+
+	"github.com/NailUsmanov/gophermart/internal/money"
+)
 
 type BalanceResponse struct {
-	Current   float64 `json:"current"`
-	Withdrawn float64 `json:"withdrawn"`
+	Current   money.Amount `json:"current"`
+	Withdrawn money.Amount `json:"withdrawn"`
 }
 
 type WithDrawRequest struct {
-	NumberOrder string  `json:"order"`
-	Sum         float64 `json:"sum"`
+	NumberOrder string       `json:"order"`
+	Sum         money.Amount `json:"sum"`
 }
 
 type UserWithDraw struct {
-	NumberOrder string    `json:"order"`
-	Sum         float64   `json:"sum"`
-	ProcessedAt time.Time `json:"processed_at"`
+	NumberOrder string       `json:"order"`
+	Sum         money.Amount `json:"sum"`
+	ProcessedAt time.Time    `json:"processed_at"`
+}
+
+type Session struct {
+	ID         string    `json:"id"`
+	UserAgent  string    `json:"device"`
+	IP         string    `json:"ip"`
+	CreatedAt  time.Time `json:"created_at"`
+	LastSeenAt time.Time `json:"last_seen_at"`
+	ExpiresAt  time.Time `json:"expires_at"`
+	Current    bool      `json:"current"`
+}
+
+// BalanceDrift - расхождение сохраненного баланса с балансом, пересчитанным по истории движений
+type BalanceDrift struct {
+	UserID            int          `json:"user_id"`
+	Current           money.Amount `json:"current"`
+	Withdrawn         money.Amount `json:"withdrawn"`
+	ExpectedCurrent   money.Amount `json:"expected_current"`
+	ExpectedWithdrawn money.Amount `json:"expected_withdrawn"`
+}
+
+// QuarantinedResponse - ответ accrual, который не прошел проверку и не был применен к заказу
+type QuarantinedResponse struct {
+	ID int64 `json:"id"`
+	// OrderNumber - заказ, по которому отправлялся запрос
+	OrderNumber string `json:"order"`
+	Reason      string `json:"reason"`
+	// Response - тело ответа как есть
+	Response   string    `json:"response"`
+	ReceivedAt time.Time `json:"received_at"`
+}
+
+// StuckOrder - заказ, снятый с опроса accrual
+type StuckOrder struct {
+	Number     string    `json:"number"`
+	UserID     int       `json:"user_id"`
+	UploadedAt time.Time `json:"uploaded_at"`
+	StuckAt    time.Time `json:"stuck_at"`
+}
+
+// IdempotentResponse - первый ответ на запрос с Idempotency-Key
+type IdempotentResponse struct {
+	// Fingerprint - отпечаток запроса: повтор с тем же ключом, но другим телом отклоняется
+	Fingerprint string
+	// Status == 0 - первый запрос еще выполняется
+	Status      int
+	ContentType string
+	Body        []byte
 }
@@ -0,0 +1,156 @@
+// Package money - денежные суммы (баллы) с фиксированной точкой.
+//
+// Сумма хранится как целое число сотых долей балла, поэтому сложение и вычитание точны,
+// в отличие от float64. Правила округления:
+//   - суммы от пользователя (списания) принимаются только с точностью до двух знаков,
+//     больше знаков - ErrTooPrecise, ничего не округляем молча;
+//   - начисления от внешней системы accrual и значения, прочитанные из базы, округляются
+//     до двух знаков по правилу "половина от нуля" (0.005 -> 0.01, -0.005 -> -0.01).
+package money
+
+import (
+	"database/sql/driver"
+	"errors"
+	"fmt"
+	"math/big"
+	"strconv"
+	"strings"
+)
+
+// Amount - сумма в сотых долях балла
+type Amount int64
+
+var (
+	ErrTooPrecise   = errors.New("amount has more than two decimal places")
+	ErrInvalidValue = errors.New("invalid amount")
+	ErrOverflow     = errors.New("amount is out of range")
+)
+
+var hundred = big.NewInt(100)
+
+// FromMinor создает сумму из сотых долей
+func FromMinor(minor int64) Amount {
+	return Amount(minor)
+}
+
+// Minor возвращает сумму в сотых долях
+func (a Amount) Minor() int64 {
+	return int64(a)
+}
+
+// Parse разбирает десятичную запись строго: больше двух знаков после точки - ErrTooPrecise
+func Parse(s string) (Amount, error) {
+	return parse(s, false)
+}
+
+// ParseRounded разбирает десятичную запись и округляет ее до двух знаков половиной от нуля
+func ParseRounded(s string) (Amount, error) {
+	return parse(s, true)
+}
+
+func parse(s string, round bool) (Amount, error) {
+	s = strings.TrimSpace(s)
+	// big.Rat понимает и дроби вида 1/3, но для денег это не число
+	if s == "" || strings.Contains(s, "/") {
+		return 0, fmt.Errorf("%w: %q", ErrInvalidValue, s)
+	}
+	r, ok := new(big.Rat).SetString(s)
+	if !ok {
+		return 0, fmt.Errorf("%w: %q", ErrInvalidValue, s)
+	}
+	r.Mul(r, new(big.Rat).SetInt(hundred))
+
+	var minor *big.Int
+	if r.IsInt() {
+		minor = new(big.Int).Set(r.Num())
+	} else {
+		if !round {
+			return 0, fmt.Errorf("%w: %q", ErrTooPrecise, s)
+		}
+		// Округление половиной от нуля: |x| + 1/2 и отбрасываем дробную часть
+		abs := new(big.Rat).Abs(r)
+		abs.Add(abs, big.NewRat(1, 2))
+		minor = new(big.Int).Quo(abs.Num(), abs.Denom())
+		if r.Sign() < 0 {
+			minor.Neg(minor)
+		}
+	}
+	if !minor.IsInt64() {
+		return 0, fmt.Errorf("%w: %q", ErrOverflow, s)
+	}
+	return Amount(minor.Int64()), nil
+}
+
+// String возвращает десятичную запись без лишних нулей: 500.5, 42, 0.05
+func (a Amount) String() string {
+	sign := ""
+	minor := int64(a)
+	if minor < 0 {
+		sign = "-"
+		minor = -minor
+	}
+	units, cents := minor/100, minor%100
+	switch {
+	case cents == 0:
+		return fmt.Sprintf("%s%d", sign, units)
+	case cents%10 == 0:
+		return fmt.Sprintf("%s%d.%d", sign, units, cents/10)
+	default:
+		return fmt.Sprintf("%s%d.%02d", sign, units, cents)
+	}
+}
+
+// MarshalJSON пишет сумму JSON-числом
+func (a Amount) MarshalJSON() ([]byte, error) {
+	return []byte(a.String()), nil
+}
+
+// UnmarshalJSON принимает JSON-число строго, без округления
+func (a *Amount) UnmarshalJSON(data []byte) error {
+	s := string(data)
+	if s == "null" {
+		return nil
+	}
+	// Принимаем только число, строку "10.5" не принимаем
+	if strings.HasPrefix(s, `"`) {
+		return fmt.Errorf("%w: %s", ErrInvalidValue, s)
+	}
+	v, err := Parse(s)
+	if err != nil {
+		return err
+	}
+	*a = v
+	return nil
+}
+
+// Scan читает NUMERIC из базы. NULL (например, SUM по пустому набору) дает ноль
+func (a *Amount) Scan(src any) error {
+	var (
+		v   Amount
+		err error
+	)
+	switch x := src.(type) {
+	case nil:
+		v = 0
+	case string:
+		v, err = ParseRounded(x)
+	case []byte:
+		v, err = ParseRounded(string(x))
+	case int64:
+		v, err = ParseRounded(strconv.FormatInt(x, 10))
+	case float64:
+		v, err = ParseRounded(strconv.FormatFloat(x, 'f', -1, 64))
+	default:
+		return fmt.Errorf("%w: cannot scan %T", ErrInvalidValue, src)
+	}
+	if err != nil {
+		return err
+	}
+	*a = v
+	return nil
+}
+
+// Value передает сумму в базу десятичной строкой, чтобы NUMERIC получил точное значение
+func (a Amount) Value() (driver.Value, error) {
+	return a.String(), nil
+}
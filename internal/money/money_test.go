@@ -0,0 +1,104 @@
+package money
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParse(t *testing.T) {
+	tests := []struct {
+		in      string
+		want    Amount
+		wantErr error
+	}{
+		{in: "500.5", want: 50050},
+		{in: "42", want: 4200},
+		{in: "0.01", want: 1},
+		{in: "-3.10", want: -310},
+		{in: "1e2", want: 10000},
+		{in: "729.98", want: 72998},
+		{in: "0.001", wantErr: ErrTooPrecise},
+		{in: "10.555", wantErr: ErrTooPrecise},
+		{in: "abc", wantErr: ErrInvalidValue},
+		{in: "1/3", wantErr: ErrInvalidValue},
+		{in: "1e30", wantErr: ErrOverflow},
+	}
+	for _, tt := range tests {
+		t.Run(tt.in, func(t *testing.T) {
+			got, err := Parse(tt.in)
+			if tt.wantErr != nil {
+				assert.ErrorIs(t, err, tt.wantErr)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, tt.want, got)
+		})
+	}
+}
+
+func TestParseRounded(t *testing.T) {
+	tests := map[string]Amount{
+		"0.005":    1,
+		"0.004":    0,
+		"-0.005":   -1,
+		"10.555":   1056,
+		"729.9849": 72998,
+	}
+	for in, want := range tests {
+		got, err := ParseRounded(in)
+		require.NoError(t, err)
+		assert.Equal(t, want, got, in)
+	}
+}
+
+func TestSumHasNoDrift(t *testing.T) {
+	// 0.1 + 0.2 во float64 дает 0.30000000000000004
+	var total Amount
+	for i := 0; i < 1000; i++ {
+		v, err := Parse("0.1")
+		require.NoError(t, err)
+		total += v
+	}
+	assert.Equal(t, "100", total.String())
+}
+
+func TestJSON(t *testing.T) {
+	var req struct {
+		Sum Amount `json:"sum"`
+	}
+	require.NoError(t, json.Unmarshal([]byte(`{"sum": 751.25}`), &req))
+	assert.Equal(t, Amount(75125), req.Sum)
+
+	err := json.Unmarshal([]byte(`{"sum": 751.255}`), &req)
+	assert.ErrorIs(t, err, ErrTooPrecise)
+
+	err = json.Unmarshal([]byte(`{"sum": "751"}`), &req)
+	assert.ErrorIs(t, err, ErrInvalidValue)
+
+	out, err := json.Marshal(struct {
+		Current   Amount `json:"current"`
+		Withdrawn Amount `json:"withdrawn"`
+		Small     Amount `json:"small"`
+	}{Current: 50050, Withdrawn: 4200, Small: -5})
+	require.NoError(t, err)
+	assert.JSONEq(t, `{"current":500.5,"withdrawn":42,"small":-0.05}`, string(out))
+}
+
+func TestScanValue(t *testing.T) {
+	var a Amount
+	require.NoError(t, a.Scan("123.45"))
+	assert.Equal(t, Amount(12345), a)
+	require.NoError(t, a.Scan([]byte("0.10")))
+	assert.Equal(t, Amount(10), a)
+	require.NoError(t, a.Scan(nil))
+	assert.Equal(t, Amount(0), a)
+	require.NoError(t, a.Scan(float64(0.3)))
+	assert.Equal(t, Amount(30), a)
+
+	v, err := Amount(12345).Value()
+	require.NoError(t, err)
+	assert.Equal(t, "123.45", v)
+}
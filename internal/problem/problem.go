@@ -0,0 +1,93 @@
+// Package problem - ответы с ошибкой по RFC 7807, общие для обработчиков и middleware
+package problem
+
+import (
+	"encoding/json"
+	"net/http"
+
+	chimw "github.com/go-chi/chi/middleware"
+)
+
+// ContentType - тип тела ответа с ошибкой по RFC 7807
+const ContentType = "application/problem+json"
+
+// typePrefix + код ошибки - URI типа проблемы
+const typePrefix = "urn:gophermart:problem:"
+
+// Стабильные машиночитаемые коды ошибок: клиенту стоит опираться на них, а не на текст
+const (
+	CodeUnauthorized          = "unauthorized"
+	CodeInvalidCredentials    = "invalid_credentials"
+	CodeTokenMissing          = "token_missing"
+	CodeTokenExpired          = "token_expired"
+	CodeTokenInvalid          = "token_invalid"
+	CodeSessionRevoked        = "session_revoked"
+	CodeSessionExpired        = "session_expired"
+	CodeSessionNotFound       = "session_not_found"
+	CodeInvalidAdminToken     = "invalid_admin_token"
+	CodeInvalidContentType    = "invalid_content_type"
+	CodeInvalidBody           = "invalid_body"
+	CodeBodyTooLarge          = "body_too_large"
+	CodeValidationFailed      = "validation_failed"
+	CodeInvalidQuery          = "invalid_query"
+	CodeInvalidOrderNumber    = "invalid_order_number"
+	CodeInvalidAmount         = "invalid_amount"
+	CodeOrderUploadedByUser   = "order_uploaded_by_another_user"
+	CodeOrderAlreadyUsed      = "order_already_used"
+	CodeLoginTaken            = "login_taken"
+	CodeInsufficientFunds     = "insufficient_funds"
+	CodeOrderNotFound         = "order_not_found"
+	CodeOrderNotStuck         = "order_not_stuck"
+	CodeStatusConflict        = "order_status_conflict"
+	CodeUnknownStatus         = "unknown_order_status"
+	CodeIllegalTransition     = "illegal_status_transition"
+	CodeInvalidIdempotencyKey = "invalid_idempotency_key"
+	CodeIdempotencyKeyReused  = "idempotency_key_reused"
+	CodeRequestInProgress     = "request_in_progress"
+	CodeInternal              = "internal_error"
+)
+
+// Problem - тело ответа с ошибкой (RFC 7807) с кодом, ID запроса и ошибками по полям
+type Problem struct {
+	Type      string  `json:"type"`
+	Title     string  `json:"title"`
+	Status    int     `json:"status"`
+	Detail    string  `json:"detail,omitempty"`
+	Instance  string  `json:"instance,omitempty"`
+	Code      string  `json:"code"`
+	RequestID string  `json:"request_id,omitempty"`
+	Errors    []Field `json:"errors,omitempty"`
+}
+
+// Field - ошибка в одном поле запроса
+type Field struct {
+	Field   string `json:"field"`
+	Message string `json:"message"`
+}
+
+// New - проблема со статусом, кодом и текстом для клиента
+func New(status int, code, detail string) *Problem {
+	return &Problem{
+		Type:   typePrefix + code,
+		Title:  http.StatusText(status),
+		Status: status,
+		Detail: detail,
+		Code:   code,
+	}
+}
+
+// WithField добавляет ошибку по полю запроса
+func (p *Problem) WithField(field, message string) *Problem {
+	p.Errors = append(p.Errors, Field{Field: field, Message: message})
+	return p
+}
+
+// Write пишет проблему в ответ, дополняя ее путем и ID запроса. Ошибка - только ошибка записи тела
+func Write(w http.ResponseWriter, r *http.Request, p *Problem) error {
+	p.Instance = r.URL.Path
+	p.RequestID = chimw.GetReqID(r.Context())
+	w.Header().Set("Content-Type", ContentType)
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+	w.WriteHeader(p.Status)
+	return json.NewEncoder(w).Encode(p)
+}
@@ -0,0 +1,41 @@
+package problem
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	chimw "github.com/go-chi/chi/middleware"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestWrite(t *testing.T) {
+	var handler http.Handler = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		p := New(http.StatusBadRequest, CodeInvalidQuery, "limit is invalid").WithField("limit", "must be positive")
+		assert.NoError(t, Write(w, r, p))
+	})
+	handler = chimw.RequestID(handler)
+
+	req := httptest.NewRequest(http.MethodGet, "/api/user/orders?limit=-1", nil)
+	req.Header.Set(chimw.RequestIDHeader, "req-1")
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, req)
+
+	assert.Equal(t, http.StatusBadRequest, rec.Code)
+	assert.Equal(t, ContentType, rec.Header().Get("Content-Type"))
+	assert.Equal(t, "nosniff", rec.Header().Get("X-Content-Type-Options"))
+	var p Problem
+	require.NoError(t, json.NewDecoder(rec.Body).Decode(&p))
+	assert.Equal(t, Problem{
+		Type:      "urn:gophermart:problem:invalid_query",
+		Title:     "Bad Request",
+		Status:    http.StatusBadRequest,
+		Detail:    "limit is invalid",
+		Instance:  "/api/user/orders",
+		Code:      CodeInvalidQuery,
+		RequestID: "req-1",
+		Errors:    []Field{{Field: "limit", Message: "must be positive"}},
+	}, p)
+}
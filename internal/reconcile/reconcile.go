@@ -0,0 +1,69 @@
+package reconcile
+
+import (
+	"context"
+	"time"
+
+	"github.com/NailUsmanov/gophermart/internal/models"
+	"github.com/NailUsmanov/gophermart/internal/storage"
+	"go.uber.org/zap"
+)
+
+// Reconciler пересчитывает балансы по истории движений и сообщает о расхождениях (а при Repair - исправляет их)
+type Reconciler struct {
+	Storage storage.BalanceReconciler
+	Sugar   *zap.SugaredLogger
+	Repair  bool
+}
+
+func NewReconciler(s storage.BalanceReconciler, sugar *zap.SugaredLogger, repair bool) *Reconciler {
+	return &Reconciler{
+		Storage: s,
+		Sugar:   sugar,
+		Repair:  repair,
+	}
+}
+
+// RunOnce выполняет одну сверку и пишет каждое расхождение в лог
+func (r *Reconciler) RunOnce(ctx context.Context) ([]models.BalanceDrift, error) {
+	drifts, err := r.Storage.ReconcileBalances(ctx, r.Repair)
+	if err != nil {
+		return drifts, err
+	}
+	for _, d := range drifts {
+		r.Sugar.Warnw("Balance drift detected",
+			"user_id", d.UserID,
+			"current", d.Current,
+			"expected_current", d.ExpectedCurrent,
+			"withdrawn", d.Withdrawn,
+			"expected_withdrawn", d.ExpectedWithdrawn,
+			"repaired", r.Repair,
+		)
+	}
+	r.Sugar.Infof("Balance reconciliation finished: %d drifts, repair=%v", len(drifts), r.Repair)
+	return drifts, nil
+}
+
+// Start запускает периодическую сверку в фоне до отмены ctx. Канал закрывается, когда сверка остановилась
+// и начатый RunOnce (вместе с исправлениями балансов) закончился
+func (r *Reconciler) Start(ctx context.Context, interval time.Duration) <-chan struct{} {
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-ctx.Done():
+				r.Sugar.Info("Reconciler stopped due to context cancellation")
+				return
+			case <-ticker.C:
+				if _, err := r.RunOnce(ctx); err != nil {
+					r.Sugar.Errorf("Balance reconciliation failed: %v", err)
+				}
+			}
+		}
+	}()
+	return done
+}
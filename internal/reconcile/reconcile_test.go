@@ -0,0 +1,75 @@
+package reconcile
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/NailUsmanov/gophermart/internal/models"
+	"github.com/stretchr/testify/assert"
+	"go.uber.org/zap"
+)
+
+type fakeStorage struct {
+	drifts []models.BalanceDrift
+	err    error
+	repair bool
+}
+
+func (f *fakeStorage) ReconcileBalances(_ context.Context, repair bool) ([]models.BalanceDrift, error) {
+	f.repair = repair
+	return f.drifts, f.err
+}
+
+func TestRunOnce(t *testing.T) {
+	sugar := zap.NewNop().Sugar()
+
+	t.Run("reports drifts and passes repair flag", func(t *testing.T) {
+		st := &fakeStorage{drifts: []models.BalanceDrift{{UserID: 1, Current: 100, ExpectedCurrent: 50}}}
+		drifts, err := NewReconciler(st, sugar, true).RunOnce(context.Background())
+		assert.NoError(t, err)
+		assert.Len(t, drifts, 1)
+		assert.True(t, st.repair)
+	})
+
+	t.Run("storage error", func(t *testing.T) {
+		st := &fakeStorage{err: errors.New("db is down")}
+		_, err := NewReconciler(st, sugar, false).RunOnce(context.Background())
+		assert.Error(t, err)
+		assert.False(t, st.repair)
+	})
+}
+
+// blockingStorage держит сверку, пока тест не отпустит ее; entered - сверка началась
+type blockingStorage struct {
+	entered chan struct{}
+	release chan struct{}
+}
+
+func (b *blockingStorage) ReconcileBalances(_ context.Context, _ bool) ([]models.BalanceDrift, error) {
+	b.entered <- struct{}{}
+	<-b.release
+	return nil, nil
+}
+
+func TestStartWaitsForRunningReconciliation(t *testing.T) {
+	st := &blockingStorage{entered: make(chan struct{}, 1), release: make(chan struct{})}
+	ctx, cancel := context.WithCancel(context.Background())
+	done := NewReconciler(st, zap.NewNop().Sugar(), true).Start(ctx, time.Millisecond)
+
+	// Отмена не закрывает канал, пока начатая сверка не закончилась
+	<-st.entered
+	cancel()
+	select {
+	case <-done:
+		t.Fatal("done is closed while reconciliation is running")
+	case <-time.After(20 * time.Millisecond):
+	}
+	close(st.release)
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("reconciler did not stop")
+	}
+}
@@ -0,0 +1,35 @@
+package service
+
+import (
+	"encoding/base64"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// encodeCursor упаковывает ключ последней записи страницы в непрозрачную для клиента строку
+func encodeCursor(at time.Time, id int64) string {
+	raw := strconv.FormatInt(at.UnixNano(), 10) + ":" + strconv.FormatInt(id, 10)
+	return base64.RawURLEncoding.EncodeToString([]byte(raw))
+}
+
+// decodeCursor - обратное к encodeCursor; любая ошибка разбора - FieldError по cursor
+func decodeCursor(cursor string) (time.Time, int64, error) {
+	raw, err := base64.RawURLEncoding.DecodeString(cursor)
+	if err != nil {
+		return time.Time{}, 0, invalidField("cursor", "is malformed")
+	}
+	nanos, id, ok := strings.Cut(string(raw), ":")
+	if !ok {
+		return time.Time{}, 0, invalidField("cursor", "is malformed")
+	}
+	n, err := strconv.ParseInt(nanos, 10, 64)
+	if err != nil {
+		return time.Time{}, 0, invalidField("cursor", "is malformed")
+	}
+	i, err := strconv.ParseInt(id, 10, 64)
+	if err != nil {
+		return time.Time{}, 0, invalidField("cursor", "is malformed")
+	}
+	return time.Unix(0, n).UTC(), i, nil
+}
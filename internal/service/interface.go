@@ -11,6 +11,7 @@ type ServiceStorage interface {
 	CheckExistOrder(ctx context.Context, numberOrder string) (bool, int, error)
 	CreateNewOrder(ctx context.Context, userID int, orderNum string, sugar *zap.SugaredLogger) error
 	GetOrdersByUserID(ctx context.Context, userID int) ([]storage.Order, error)
+	ListUserOrders(ctx context.Context, userID int, f storage.OrderFilter) (storage.OrderPage, error)
 }
 
 type ServiceInterface interface {
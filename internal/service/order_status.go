@@ -0,0 +1,144 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/NailUsmanov/gophermart/internal/money"
+	"github.com/NailUsmanov/gophermart/internal/storage"
+	"go.uber.org/zap"
+)
+
+var (
+	ErrUnknownStatus     = errors.New("unknown order status")
+	ErrIllegalTransition = errors.New("illegal order status transition")
+	ErrNotStuck          = errors.New("order is not stuck")
+)
+
+// Статусы заказа в системе лояльности
+const (
+	StatusNew        = "NEW"
+	StatusProcessing = "PROCESSING"
+	StatusInvalid    = "INVALID"
+	StatusProcessed  = "PROCESSED"
+	// StatusStuck - accrual так и не дал окончательного статуса, заказ снят с опроса до ручного возврата
+	StatusStuck = "STUCK"
+)
+
+// Источники переходов для order_status_history
+const (
+	// SourceAccrual - по ответу системы расчета начислений
+	SourceAccrual = "accrual"
+	// SourceWorker - воркер снял заказ с опроса
+	SourceWorker = "worker"
+	// SourceAdmin - оператор через админский API
+	SourceAdmin = "admin"
+)
+
+// transitions - допустимые переходы: NEW -> PROCESSING -> INVALID | PROCESSED.
+// Незавершенный заказ может уйти в STUCK, оттуда оператор возвращает его в NEW.
+// INVALID и PROCESSED окончательные
+var transitions = map[string]map[string]bool{
+	StatusNew:        {StatusProcessing: true, StatusStuck: true},
+	StatusProcessing: {StatusInvalid: true, StatusProcessed: true, StatusStuck: true},
+	StatusStuck:      {StatusNew: true},
+}
+
+// FromAccrual переводит статус accrual в статус заказа: REGISTERED у нас - PROCESSING
+func FromAccrual(status string) (string, error) {
+	switch status {
+	case "REGISTERED", StatusProcessing:
+		return StatusProcessing, nil
+	case StatusInvalid, StatusProcessed:
+		return status, nil
+	}
+	return "", fmt.Errorf("%w: %q", ErrUnknownStatus, status)
+}
+
+// CanTransition сообщает, можно ли перевести заказ из from в to
+func CanTransition(from, to string) bool {
+	return transitions[from][to]
+}
+
+// StatusStorage - то, что нужно OrderStatuses от хранилища
+type StatusStorage interface {
+	UpdateOrderStatus(ctx context.Context, t storage.StatusTransition, accrual *money.Amount) error
+}
+
+// OrderStatuses - единственная точка смены статуса заказа: проверяет переход по автомату
+// и только потом пишет его в хранилище
+type OrderStatuses struct {
+	Storage StatusStorage
+	Sugar   *zap.SugaredLogger
+}
+
+func NewOrderStatuses(s StatusStorage, sugar *zap.SugaredLogger) *OrderStatuses {
+	return &OrderStatuses{Storage: s, Sugar: sugar}
+}
+
+// ApplyAccrual применяет к заказу order статус из ответа accrual. Начисление сохраняется только для PROCESSED.
+// changed = false - статус не изменился: ни история, ни расписание опроса не трогаются.
+// Если accrual сразу ответил окончательным статусом, в историю сначала пишется шаг NEW -> PROCESSING.
+// Неизвестный статус и недопустимый переход не пишутся, а логируются и возвращаются ошибкой
+func (o *OrderStatuses) ApplyAccrual(ctx context.Context, order storage.Order, accrualStatus string, accrual *money.Amount) (changed bool, err error) {
+	from := StatusNew
+	if order.Status != nil {
+		from = *order.Status
+	}
+	to, err := FromAccrual(accrualStatus)
+	if err != nil {
+		o.Sugar.Warnw("Rejected accrual status", "order", order.Number, "status", accrualStatus)
+		return false, err
+	}
+	if from == to {
+		return false, nil
+	}
+	if from == StatusNew && to != StatusProcessing {
+		t := storage.StatusTransition{Number: order.Number, From: from, To: StatusProcessing, Source: SourceAccrual}
+		if err := o.Storage.UpdateOrderStatus(ctx, t, nil); err != nil {
+			return false, err
+		}
+		from = StatusProcessing
+	}
+	if !CanTransition(from, to) {
+		o.Sugar.Warnw("Rejected illegal order status transition", "order", order.Number, "from", from, "to", to)
+		return false, fmt.Errorf("%w: %s -> %s", ErrIllegalTransition, from, to)
+	}
+	if to != StatusProcessed {
+		accrual = nil
+	}
+	t := storage.StatusTransition{Number: order.Number, From: from, To: to, Source: SourceAccrual}
+	if err := o.Storage.UpdateOrderStatus(ctx, t, accrual); err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+// MarkStuck снимает незавершенный заказ с опроса accrual
+func (o *OrderStatuses) MarkStuck(ctx context.Context, order storage.Order, reason string) error {
+	from := StatusNew
+	if order.Status != nil {
+		from = *order.Status
+	}
+	if !CanTransition(from, StatusStuck) {
+		return fmt.Errorf("%w: %s -> %s", ErrIllegalTransition, from, StatusStuck)
+	}
+	o.Sugar.Warnw("Order is stuck, polling stopped", "order", order.Number, "status", from, "reason", reason)
+	t := storage.StatusTransition{Number: order.Number, From: from, To: StatusStuck, Source: SourceWorker}
+	return o.Storage.UpdateOrderStatus(ctx, t, order.Accrual)
+}
+
+// Requeue возвращает заказ из STUCK в NEW, и воркер снова начинает его опрашивать
+func (o *OrderStatuses) Requeue(ctx context.Context, number string) error {
+	t := storage.StatusTransition{Number: number, From: StatusStuck, To: StatusNew, Source: SourceAdmin}
+	err := o.Storage.UpdateOrderStatus(ctx, t, nil)
+	if errors.Is(err, storage.ErrStatusConflict) {
+		return fmt.Errorf("%w: %v", ErrNotStuck, err)
+	}
+	if err != nil {
+		return err
+	}
+	o.Sugar.Infow("Order requeued", "order", number)
+	return nil
+}
@@ -0,0 +1,161 @@
+package service
+
+import (
+	"context"
+	"fmt"
+	"testing"
+
+	"github.com/NailUsmanov/gophermart/internal/money"
+	"github.com/NailUsmanov/gophermart/internal/storage"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+)
+
+type statusStorageStub struct {
+	transitions []storage.StatusTransition
+	accruals    []*money.Amount
+	err         error
+}
+
+func (s *statusStorageStub) UpdateOrderStatus(ctx context.Context, t storage.StatusTransition, accrual *money.Amount) error {
+	if s.err != nil {
+		return s.err
+	}
+	s.transitions = append(s.transitions, t)
+	s.accruals = append(s.accruals, accrual)
+	return nil
+}
+
+func TestCanTransition(t *testing.T) {
+	tests := []struct {
+		from, to string
+		want     bool
+	}{
+		{StatusNew, StatusProcessing, true},
+		{StatusNew, StatusInvalid, false},
+		{StatusNew, StatusProcessed, false},
+		{StatusProcessing, StatusProcessing, false},
+		{StatusProcessing, StatusInvalid, true},
+		{StatusProcessing, StatusProcessed, true},
+		{StatusProcessing, StatusNew, false},
+		{StatusProcessed, StatusProcessing, false},
+		{StatusProcessed, StatusProcessed, false},
+		{StatusInvalid, StatusProcessed, false},
+		{StatusNew, StatusStuck, true},
+		{StatusProcessing, StatusStuck, true},
+		{StatusStuck, StatusNew, true},
+		{StatusStuck, StatusProcessed, false},
+		{StatusProcessed, StatusStuck, false},
+		{"UNKNOWN", StatusProcessed, false},
+	}
+	for _, tt := range tests {
+		assert.Equal(t, tt.want, CanTransition(tt.from, tt.to), "%s -> %s", tt.from, tt.to)
+	}
+}
+
+func TestFromAccrual(t *testing.T) {
+	for accrualStatus, want := range map[string]string{
+		"REGISTERED": StatusProcessing,
+		"PROCESSING": StatusProcessing,
+		"INVALID":    StatusInvalid,
+		"PROCESSED":  StatusProcessed,
+	} {
+		got, err := FromAccrual(accrualStatus)
+		require.NoError(t, err)
+		assert.Equal(t, want, got)
+	}
+	for _, bad := range []string{"", "NEW", "processed", "DONE"} {
+		_, err := FromAccrual(bad)
+		assert.ErrorIs(t, err, ErrUnknownStatus, bad)
+	}
+}
+
+func TestApplyAccrual(t *testing.T) {
+	ctx := context.Background()
+	order := func(status string) storage.Order {
+		return storage.Order{Number: "12345678903", Status: &status}
+	}
+	amount := money.Amount(50000)
+
+	t.Run("registered becomes processing without accrual", func(t *testing.T) {
+		st := &statusStorageStub{}
+		changed, err := NewOrderStatuses(st, zap.NewNop().Sugar()).ApplyAccrual(ctx, order(StatusNew), "REGISTERED", &amount)
+		require.NoError(t, err)
+		assert.True(t, changed)
+		require.Len(t, st.transitions, 1)
+		assert.Equal(t, storage.StatusTransition{Number: "12345678903", From: StatusNew, To: StatusProcessing, Source: SourceAccrual}, st.transitions[0])
+		assert.Nil(t, st.accruals[0])
+	})
+
+	t.Run("processed keeps accrual", func(t *testing.T) {
+		st := &statusStorageStub{}
+		changed, err := NewOrderStatuses(st, zap.NewNop().Sugar()).ApplyAccrual(ctx, order(StatusProcessing), "PROCESSED", &amount)
+		require.NoError(t, err)
+		assert.True(t, changed)
+		require.Len(t, st.transitions, 1)
+		assert.Equal(t, StatusProcessed, st.transitions[0].To)
+		assert.Equal(t, &amount, st.accruals[0])
+	})
+
+	t.Run("unchanged status is not written", func(t *testing.T) {
+		st := &statusStorageStub{}
+		for _, accrualStatus := range []string{"REGISTERED", "PROCESSING"} {
+			changed, err := NewOrderStatuses(st, zap.NewNop().Sugar()).ApplyAccrual(ctx, order(StatusProcessing), accrualStatus, nil)
+			require.NoError(t, err)
+			assert.False(t, changed, accrualStatus)
+		}
+		assert.Empty(t, st.transitions)
+	})
+
+	t.Run("final status from new goes through processing", func(t *testing.T) {
+		st := &statusStorageStub{}
+		changed, err := NewOrderStatuses(st, zap.NewNop().Sugar()).ApplyAccrual(ctx, order(StatusNew), "PROCESSED", &amount)
+		require.NoError(t, err)
+		assert.True(t, changed)
+		assert.Equal(t, []storage.StatusTransition{
+			{Number: "12345678903", From: StatusNew, To: StatusProcessing, Source: SourceAccrual},
+			{Number: "12345678903", From: StatusProcessing, To: StatusProcessed, Source: SourceAccrual},
+		}, st.transitions)
+		assert.Equal(t, []*money.Amount{nil, &amount}, st.accruals)
+	})
+
+	t.Run("final status is not rolled back", func(t *testing.T) {
+		st := &statusStorageStub{}
+		_, err := NewOrderStatuses(st, zap.NewNop().Sugar()).ApplyAccrual(ctx, order(StatusProcessed), "PROCESSING", nil)
+		assert.ErrorIs(t, err, ErrIllegalTransition)
+		assert.Empty(t, st.transitions)
+	})
+
+	t.Run("unknown status is rejected", func(t *testing.T) {
+		st := &statusStorageStub{}
+		_, err := NewOrderStatuses(st, zap.NewNop().Sugar()).ApplyAccrual(ctx, order(StatusNew), "DONE", nil)
+		assert.ErrorIs(t, err, ErrUnknownStatus)
+		assert.Empty(t, st.transitions)
+	})
+}
+
+func TestStuckOrders(t *testing.T) {
+	ctx := context.Background()
+	processing := StatusProcessing
+	processed := StatusProcessed
+
+	st := &statusStorageStub{}
+	statuses := NewOrderStatuses(st, zap.NewNop().Sugar())
+	require.NoError(t, statuses.MarkStuck(ctx, storage.Order{Number: "12345678903", Status: &processing}, "max attempts exceeded"))
+	require.NoError(t, statuses.Requeue(ctx, "12345678903"))
+	assert.Equal(t, []storage.StatusTransition{
+		{Number: "12345678903", From: StatusProcessing, To: StatusStuck, Source: SourceWorker},
+		{Number: "12345678903", From: StatusStuck, To: StatusNew, Source: SourceAdmin},
+	}, st.transitions)
+
+	// Обработанный заказ не застревает
+	err := statuses.MarkStuck(ctx, storage.Order{Number: "79927398713", Status: &processed}, "max age exceeded")
+	assert.ErrorIs(t, err, ErrIllegalTransition)
+
+	// Вернуть можно только застрявший заказ
+	st.err = fmt.Errorf("order is NEW, not STUCK: %w", storage.ErrStatusConflict)
+	assert.ErrorIs(t, statuses.Requeue(ctx, "12345678903"), ErrNotStuck)
+	st.err = fmt.Errorf("order: %w", storage.ErrOrderNotFound)
+	assert.ErrorIs(t, statuses.Requeue(ctx, "12345678903"), storage.ErrOrderNotFound)
+}
@@ -3,6 +3,8 @@ package service
 import (
 	"context"
 	"errors"
+	"fmt"
+	"time"
 
 	"github.com/NailUsmanov/gophermart/internal/middleware"
 	"github.com/NailUsmanov/gophermart/internal/storage"
@@ -15,6 +17,32 @@ var (
 	ErrUnauthorized       = errors.New("unauthorized")
 	ErrInternal           = errors.New("internal server error")
 	ErrNoContent          = errors.New("no content inside")
+	// ErrInvalidQuery - неверные параметры постраничного списка; текст ошибки можно вернуть клиенту
+	ErrInvalidQuery = errors.New("invalid query")
+)
+
+// FieldError - ErrInvalidQuery с именем неверного параметра, чтобы клиент мог указать на поле
+type FieldError struct {
+	Field   string
+	Message string
+}
+
+func (e *FieldError) Error() string {
+	return fmt.Sprintf("%v: %s %s", ErrInvalidQuery, e.Field, e.Message)
+}
+
+func (e *FieldError) Unwrap() error {
+	return ErrInvalidQuery
+}
+
+func invalidField(field, format string, args ...any) error {
+	return &FieldError{Field: field, Message: fmt.Sprintf(format, args...)}
+}
+
+// Размер страницы постраничных списков
+const (
+	DefaultPageLimit = 50
+	MaxPageLimit     = 1000
 )
 
 type Service struct {
@@ -43,7 +71,8 @@ func (s *Service) CheckExistUser(ctx context.Context, orderNum string) (exists b
 	// Проверяем существует ли уже запись в базе
 	exists, existingUserID, err := s.Storage.CheckExistOrder(ctx, orderNum)
 	if err != nil {
-		return false, 0, 0, err
+		// Причина сохраняется в тексте, чтобы обработчик залогировал ее вместе с 500
+		return false, 0, 0, fmt.Errorf("%w: %v", ErrInternal, err)
 	}
 	return exists, existingUserID, userID, nil
 }
@@ -74,5 +103,89 @@ func (s *Service) GetUserOrders(ctx context.Context) ([]storage.Order, error) {
 	if len(orders) == 0 {
 		return nil, ErrNoContent
 	}
+	hideStuck(orders)
 	return orders, nil
 }
+
+// OrdersQuery - параметры постраничного списка заказов. Нулевые поля не фильтруют
+type OrdersQuery struct {
+	// Limit - размер страницы; 0 - DefaultPageLimit
+	Limit int
+	// Cursor - NextCursor предыдущей страницы
+	Cursor string
+	// Status - статус заказа, как его видит пользователь
+	Status string
+	// From включительно, To не включительно
+	From time.Time
+	To   time.Time
+}
+
+// OrdersPage - страница заказов; NextCursor пустой, если страница последняя
+type OrdersPage struct {
+	Orders     []storage.Order `json:"orders"`
+	NextCursor string          `json:"next_cursor,omitempty"`
+}
+
+func (s *Service) ListUserOrders(ctx context.Context, q OrdersQuery) (OrdersPage, error) {
+	userID, ok := ctx.Value(middleware.UserLoginKey).(int)
+	if !ok {
+		return OrdersPage{}, ErrUnauthorized
+	}
+	f, err := orderFilter(q)
+	if err != nil {
+		return OrdersPage{}, err
+	}
+	page, err := s.Storage.ListUserOrders(ctx, userID, f)
+	if err != nil {
+		return OrdersPage{}, ErrInternal
+	}
+	hideStuck(page.Orders)
+	result := OrdersPage{Orders: page.Orders}
+	if page.Next != nil {
+		result.NextCursor = encodeCursor(page.Next.UploadedAt, page.Next.ID)
+	}
+	return result, nil
+}
+
+// orderFilter проверяет параметры списка и переводит их в фильтр хранилища
+func orderFilter(q OrdersQuery) (storage.OrderFilter, error) {
+	// Границы с любым смещением сравниваются как моменты в UTC
+	f := storage.OrderFilter{Limit: q.Limit, From: q.From.UTC(), To: q.To.UTC()}
+	if f.Limit == 0 {
+		f.Limit = DefaultPageLimit
+	}
+	if f.Limit < 0 || f.Limit > MaxPageLimit {
+		return f, invalidField("limit", "must be between 1 and %d", MaxPageLimit)
+	}
+	if !f.From.IsZero() && !f.To.IsZero() && !f.From.Before(f.To) {
+		return f, invalidField("from", "must be before to")
+	}
+	switch q.Status {
+	case "":
+	case StatusProcessing:
+		// Пользователь видит STUCK как PROCESSING, поэтому и фильтр находит оба
+		f.Statuses = []string{StatusProcessing, StatusStuck}
+	case StatusNew, StatusInvalid, StatusProcessed:
+		f.Statuses = []string{q.Status}
+	default:
+		return f, invalidField("status", "has unknown value %q", q.Status)
+	}
+	if q.Cursor != "" {
+		at, id, err := decodeCursor(q.Cursor)
+		if err != nil {
+			return f, err
+		}
+		f.After = &storage.OrderCursor{UploadedAt: at, ID: id}
+	}
+	return f, nil
+}
+
+// hideStuck показывает STUCK пользователю как PROCESSING: это внутренний статус
+func hideStuck(orders []storage.Order) {
+	for i := range orders {
+		if orders[i].Status != nil && *orders[i].Status == StatusStuck {
+			status := StatusProcessing
+			orders[i].Status = &status
+		}
+	}
+}
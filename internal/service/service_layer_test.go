@@ -4,10 +4,13 @@ import (
 	"context"
 	"errors"
 	"testing"
+	"time"
 
 	"github.com/NailUsmanov/gophermart/internal/middleware"
+	"github.com/NailUsmanov/gophermart/internal/money"
 	"github.com/NailUsmanov/gophermart/internal/storage"
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
 	"go.uber.org/zap"
 
 	"github.com/NailUsmanov/gophermart/internal/validation"
@@ -18,6 +21,9 @@ type mockStorage struct {
 	exists         bool
 	existingUserID int
 	err            error
+	// page отдается из ListUserOrders, filter - последний запрошенный фильтр
+	page   storage.OrderPage
+	filter storage.OrderFilter
 }
 
 func (m *mockStorage) CheckExistOrder(ctx context.Context, numberOrder string) (bool, int, error) {
@@ -34,7 +40,11 @@ func (m *mockStorage) GetUserOrders(ctx context.Context) ([]storage.Order, error
 func (m *mockStorage) GetOrdersByUserID(ctx context.Context, userID int) ([]storage.Order, error) {
 	return nil, nil
 }
-func (m *mockStorage) AddWithdrawOrder(ctx context.Context, userID int, orderNumber string, sum float64) error {
+func (m *mockStorage) ListUserOrders(ctx context.Context, userID int, f storage.OrderFilter) (storage.OrderPage, error) {
+	m.filter = f
+	return m.page, m.err
+}
+func (m *mockStorage) AddWithdrawOrder(ctx context.Context, userID int, orderNumber string, sum money.Amount) error {
 	return nil
 }
 func TestCheckExistUser(t *testing.T) {
@@ -80,7 +90,66 @@ func TestCheckExistUser(t *testing.T) {
 			service := NewService(tt.storageMock, validation)
 			exists, _, _, err := service.CheckExistUser(ctx, tt.orderNum)
 			assert.Equal(t, tt.expectedExists, exists)
-			assert.Equal(t, tt.expectedErr, err)
+			if tt.expectedErr == nil {
+				assert.NoError(t, err)
+			} else {
+				assert.ErrorIs(t, err, tt.expectedErr)
+			}
+			if tt.storageMock.err != nil {
+				assert.ErrorContains(t, err, tt.storageMock.err.Error())
+			}
 		})
 	}
 }
+
+func TestListUserOrders(t *testing.T) {
+	ctx := context.WithValue(context.Background(), middleware.UserLoginKey, 1)
+	stuck := StatusStuck
+	uploadedAt := time.Date(2025, 3, 1, 12, 0, 0, 123456000, time.UTC)
+	st := &mockStorage{page: storage.OrderPage{
+		Orders: []storage.Order{{Number: "79927398713", Status: &stuck, UploadedAt: uploadedAt}},
+		Next:   &storage.OrderCursor{UploadedAt: uploadedAt, ID: 42},
+	}}
+	serv := NewService(st, &validation.LuhnValidation{})
+
+	// STUCK скрыт и в ответе, и в фильтре
+	page, err := serv.ListUserOrders(ctx, OrdersQuery{Status: StatusProcessing})
+	require.NoError(t, err)
+	assert.Equal(t, StatusProcessing, *page.Orders[0].Status)
+	assert.Equal(t, []string{StatusProcessing, StatusStuck}, st.filter.Statuses)
+	assert.Equal(t, DefaultPageLimit, st.filter.Limit)
+	assert.Nil(t, st.filter.After)
+
+	// Курсор следующей страницы возвращается в хранилище тем же ключом
+	require.NotEmpty(t, page.NextCursor)
+	_, err = serv.ListUserOrders(ctx, OrdersQuery{Limit: 10, Cursor: page.NextCursor})
+	require.NoError(t, err)
+	require.NotNil(t, st.filter.After)
+	assert.True(t, uploadedAt.Equal(st.filter.After.UploadedAt))
+	assert.Equal(t, int64(42), st.filter.After.ID)
+	assert.Nil(t, st.filter.Statuses)
+
+	// Границы с чужим смещением уходят в хранилище в UTC
+	plus3 := time.FixedZone("UTC+3", 3*60*60)
+	_, err = serv.ListUserOrders(ctx, OrdersQuery{From: uploadedAt.In(plus3), To: uploadedAt.Add(time.Hour).In(plus3)})
+	require.NoError(t, err)
+	assert.Equal(t, time.UTC, st.filter.From.Location())
+	assert.Equal(t, time.UTC, st.filter.To.Location())
+	assert.True(t, uploadedAt.Equal(st.filter.From))
+
+	for name, q := range map[string]OrdersQuery{
+		"limit too large": {Limit: MaxPageLimit + 1},
+		"negative limit":  {Limit: -1},
+		"internal status": {Status: StatusStuck},
+		"unknown status":  {Status: "DONE"},
+		"empty range":     {From: uploadedAt, To: uploadedAt},
+		"broken cursor":   {Cursor: "not a cursor"},
+		"foreign cursor":  {Cursor: "MTIz"},
+	} {
+		_, err := serv.ListUserOrders(ctx, q)
+		assert.ErrorIs(t, err, ErrInvalidQuery, name)
+	}
+
+	_, err = serv.ListUserOrders(context.Background(), OrdersQuery{})
+	assert.ErrorIs(t, err, ErrUnauthorized)
+}
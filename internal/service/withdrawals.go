@@ -0,0 +1,93 @@
+package service
+
+import (
+	"context"
+	"time"
+
+	"github.com/NailUsmanov/gophermart/internal/middleware"
+	"github.com/NailUsmanov/gophermart/internal/models"
+	"github.com/NailUsmanov/gophermart/internal/money"
+	"github.com/NailUsmanov/gophermart/internal/storage"
+)
+
+// WithdrawalsQuery - параметры постраничного списка списаний. Нулевые поля не фильтруют
+type WithdrawalsQuery struct {
+	// Limit - размер страницы; 0 - DefaultPageLimit
+	Limit int
+	// Cursor - NextCursor предыдущей страницы
+	Cursor string
+	// From включительно, To не включительно
+	From time.Time
+	To   time.Time
+	// MinSum и MaxSum включительно
+	MinSum *money.Amount
+	MaxSum *money.Amount
+}
+
+// WithdrawalsPage - страница списаний. Total и Count - итог по всем страницам выборки
+type WithdrawalsPage struct {
+	Withdrawals []models.UserWithDraw `json:"withdrawals"`
+	NextCursor  string                `json:"next_cursor,omitempty"`
+	Total       money.Amount          `json:"total"`
+	Count       int                   `json:"count"`
+}
+
+type Withdrawals struct {
+	Storage storage.WithdrawalFetcher
+}
+
+func NewWithdrawals(s storage.WithdrawalFetcher) *Withdrawals {
+	return &Withdrawals{Storage: s}
+}
+
+func (w *Withdrawals) List(ctx context.Context, q WithdrawalsQuery) (WithdrawalsPage, error) {
+	userID, ok := ctx.Value(middleware.UserLoginKey).(int)
+	if !ok {
+		return WithdrawalsPage{}, ErrUnauthorized
+	}
+	f, err := withdrawalFilter(q)
+	if err != nil {
+		return WithdrawalsPage{}, err
+	}
+	page, err := w.Storage.ListUserWithdrawals(ctx, userID, f)
+	if err != nil {
+		return WithdrawalsPage{}, ErrInternal
+	}
+	result := WithdrawalsPage{Withdrawals: page.Withdrawals, Total: page.Total, Count: page.Count}
+	if page.Next != nil {
+		result.NextCursor = encodeCursor(page.Next.ProcessedAt, page.Next.ID)
+	}
+	return result, nil
+}
+
+// withdrawalFilter проверяет параметры списка и переводит их в фильтр хранилища
+func withdrawalFilter(q WithdrawalsQuery) (storage.WithdrawalFilter, error) {
+	// Границы с любым смещением сравниваются как моменты в UTC
+	f := storage.WithdrawalFilter{Limit: q.Limit, From: q.From.UTC(), To: q.To.UTC(), MinSum: q.MinSum, MaxSum: q.MaxSum}
+	if f.Limit == 0 {
+		f.Limit = DefaultPageLimit
+	}
+	if f.Limit < 0 || f.Limit > MaxPageLimit {
+		return f, invalidField("limit", "must be between 1 and %d", MaxPageLimit)
+	}
+	if !f.From.IsZero() && !f.To.IsZero() && !f.From.Before(f.To) {
+		return f, invalidField("from", "must be before to")
+	}
+	if f.MinSum != nil && *f.MinSum < 0 {
+		return f, invalidField("min_sum", "must not be negative")
+	}
+	if f.MaxSum != nil && *f.MaxSum < 0 {
+		return f, invalidField("max_sum", "must not be negative")
+	}
+	if f.MinSum != nil && f.MaxSum != nil && *f.MinSum > *f.MaxSum {
+		return f, invalidField("min_sum", "must not exceed max_sum")
+	}
+	if q.Cursor != "" {
+		at, id, err := decodeCursor(q.Cursor)
+		if err != nil {
+			return f, err
+		}
+		f.After = &storage.WithdrawalCursor{ProcessedAt: at, ID: id}
+	}
+	return f, nil
+}
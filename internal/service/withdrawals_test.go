@@ -0,0 +1,77 @@
+package service
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/NailUsmanov/gophermart/internal/middleware"
+	"github.com/NailUsmanov/gophermart/internal/models"
+	"github.com/NailUsmanov/gophermart/internal/money"
+	"github.com/NailUsmanov/gophermart/internal/storage"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type withdrawalsStub struct {
+	page   storage.WithdrawalPage
+	filter storage.WithdrawalFilter
+}
+
+func (s *withdrawalsStub) GetAllUserWithdrawals(ctx context.Context, userID int) ([]models.UserWithDraw, error) {
+	return nil, nil
+}
+
+func (s *withdrawalsStub) ListUserWithdrawals(ctx context.Context, userID int, f storage.WithdrawalFilter) (storage.WithdrawalPage, error) {
+	s.filter = f
+	return s.page, nil
+}
+
+func TestListWithdrawals(t *testing.T) {
+	ctx := context.WithValue(context.Background(), middleware.UserLoginKey, 1)
+	processedAt := time.Date(2025, 3, 1, 12, 0, 0, 0, time.UTC)
+	st := &withdrawalsStub{page: storage.WithdrawalPage{
+		Withdrawals: []models.UserWithDraw{{NumberOrder: "2377225624", Sum: 500, ProcessedAt: processedAt}},
+		Next:        &storage.WithdrawalCursor{ProcessedAt: processedAt, ID: 9},
+		Total:       1500,
+		Count:       3,
+	}}
+	withdrawals := NewWithdrawals(st)
+
+	page, err := withdrawals.List(ctx, WithdrawalsQuery{})
+	require.NoError(t, err)
+	assert.Equal(t, money.Amount(1500), page.Total)
+	assert.Equal(t, 3, page.Count)
+	assert.Equal(t, DefaultPageLimit, st.filter.Limit)
+
+	minSum := money.Amount(100)
+	_, err = withdrawals.List(ctx, WithdrawalsQuery{Cursor: page.NextCursor, MinSum: &minSum})
+	require.NoError(t, err)
+	require.NotNil(t, st.filter.After)
+	assert.True(t, processedAt.Equal(st.filter.After.ProcessedAt))
+	assert.Equal(t, int64(9), st.filter.After.ID)
+	assert.Equal(t, &minSum, st.filter.MinSum)
+
+	// Границы с чужим смещением уходят в хранилище в UTC
+	plus3 := time.FixedZone("UTC+3", 3*60*60)
+	_, err = withdrawals.List(ctx, WithdrawalsQuery{From: processedAt.In(plus3), To: processedAt.Add(time.Hour).In(plus3)})
+	require.NoError(t, err)
+	assert.Equal(t, time.UTC, st.filter.From.Location())
+	assert.Equal(t, time.UTC, st.filter.To.Location())
+	assert.True(t, processedAt.Equal(st.filter.From))
+
+	negative, less := money.Amount(-1), money.Amount(50)
+	for name, q := range map[string]WithdrawalsQuery{
+		"limit too large":  {Limit: MaxPageLimit + 1},
+		"empty range":      {From: processedAt, To: processedAt.Add(-time.Hour)},
+		"negative sum":     {MinSum: &negative},
+		"min above max":    {MinSum: &minSum, MaxSum: &less},
+		"malformed cursor": {Cursor: "%%%"},
+	} {
+		_, err := withdrawals.List(ctx, q)
+		assert.ErrorIs(t, err, ErrInvalidQuery, name)
+	}
+
+	_, err = withdrawals.List(context.Background(), WithdrawalsQuery{})
+	assert.ErrorIs(t, err, ErrUnauthorized)
+}
@@ -0,0 +1,136 @@
+package storage
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/NailUsmanov/gophermart/internal/models"
+)
+
+// Ключи идемпотентности одинаково устроены во всех трех хранилищах:
+// Begin закрепляет ключ записью без ответа, Complete дописывает ответ, Release удаляет запись без ответа
+
+func (d *DataBaseStorage) BeginIdempotentRequest(ctx context.Context, userID int, key, fingerprint string, ttl time.Duration) (*models.IdempotentResponse, error) {
+	return beginIdempotent(ctx, d.db, userID, key, fingerprint, ttl, PurgeIdempotencyKeysPostgres, InsertIdempotencyKeyPostgres, GetIdempotencyKeyPostgres)
+}
+
+func (d *DataBaseStorage) CompleteIdempotentRequest(ctx context.Context, userID int, key string, resp models.IdempotentResponse) error {
+	if _, err := d.db.ExecContext(ctx, CompleteIdempotencyKeyPostgres, userID, key, resp.Status, resp.ContentType, resp.Body); err != nil {
+		return fmt.Errorf("complete idempotency key: %w", err)
+	}
+	return nil
+}
+
+func (d *DataBaseStorage) ReleaseIdempotentRequest(ctx context.Context, userID int, key string) error {
+	if _, err := d.db.ExecContext(ctx, ReleaseIdempotencyKeyPostgres, userID, key); err != nil {
+		return fmt.Errorf("release idempotency key: %w", err)
+	}
+	return nil
+}
+
+func (s *SQLiteStorage) BeginIdempotentRequest(ctx context.Context, userID int, key, fingerprint string, ttl time.Duration) (*models.IdempotentResponse, error) {
+	return beginIdempotent(ctx, s.db, userID, key, fingerprint, ttl, PurgeIdempotencyKeysSQLite, InsertIdempotencyKeySQLite, GetIdempotencyKeySQLite)
+}
+
+func (s *SQLiteStorage) CompleteIdempotentRequest(ctx context.Context, userID int, key string, resp models.IdempotentResponse) error {
+	if _, err := s.db.ExecContext(ctx, CompleteIdempotencyKeySQLite, userID, key, resp.Status, resp.ContentType, resp.Body); err != nil {
+		return fmt.Errorf("complete idempotency key: %w", err)
+	}
+	return nil
+}
+
+func (s *SQLiteStorage) ReleaseIdempotentRequest(ctx context.Context, userID int, key string) error {
+	if _, err := s.db.ExecContext(ctx, ReleaseIdempotencyKeySQLite, userID, key); err != nil {
+		return fmt.Errorf("release idempotency key: %w", err)
+	}
+	return nil
+}
+
+// beginIdempotent - общая часть Begin для PostgreSQL и SQLite, отличаются только тексты запросов
+func beginIdempotent(ctx context.Context, db *sql.DB, userID int, key, fingerprint string, ttl time.Duration, purge, insert, get string) (*models.IdempotentResponse, error) {
+	if _, err := db.ExecContext(ctx, purge, userID); err != nil {
+		return nil, fmt.Errorf("purge idempotency keys: %w", err)
+	}
+	res, err := db.ExecContext(ctx, insert, userID, key, fingerprint, int64(ttl/time.Second))
+	if err != nil {
+		return nil, fmt.Errorf("insert idempotency key: %w", err)
+	}
+	if n, err := res.RowsAffected(); err != nil {
+		return nil, err
+	} else if n == 1 {
+		return nil, nil
+	}
+
+	var rec models.IdempotentResponse
+	err = db.QueryRowContext(ctx, get, userID, key).Scan(&rec.Fingerprint, &rec.Status, &rec.ContentType, &rec.Body)
+	if errors.Is(err, sql.ErrNoRows) {
+		// Запись успели освободить между INSERT и SELECT - для клиента это тот же запрос в работе
+		return &models.IdempotentResponse{Fingerprint: fingerprint}, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("get idempotency key: %w", err)
+	}
+	return &rec, nil
+}
+
+type memIdempotencyKey struct {
+	userID int
+	key    string
+}
+
+type memIdempotent struct {
+	resp      models.IdempotentResponse
+	expiresAt time.Time
+}
+
+func (m *MemStorage) BeginIdempotentRequest(ctx context.Context, userID int, key, fingerprint string, ttl time.Duration) (*models.IdempotentResponse, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	now := time.Now()
+	k := memIdempotencyKey{userID: userID, key: key}
+	if rec, ok := m.idempotency[k]; ok && rec.expiresAt.After(now) {
+		resp := rec.resp
+		resp.Body = append([]byte(nil), rec.resp.Body...)
+		return &resp, nil
+	}
+	m.idempotency[k] = &memIdempotent{
+		resp:      models.IdempotentResponse{Fingerprint: fingerprint},
+		expiresAt: now.Add(ttl),
+	}
+	return nil, nil
+}
+
+func (m *MemStorage) CompleteIdempotentRequest(ctx context.Context, userID int, key string, resp models.IdempotentResponse) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	rec, ok := m.idempotency[memIdempotencyKey{userID: userID, key: key}]
+	if !ok || rec.resp.Status != 0 {
+		return nil
+	}
+	rec.resp.Status = resp.Status
+	rec.resp.ContentType = resp.ContentType
+	rec.resp.Body = append([]byte(nil), resp.Body...)
+	return nil
+}
+
+func (m *MemStorage) ReleaseIdempotentRequest(ctx context.Context, userID int, key string) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	k := memIdempotencyKey{userID: userID, key: key}
+	if rec, ok := m.idempotency[k]; ok && rec.resp.Status == 0 {
+		delete(m.idempotency, k)
+	}
+	return nil
+}
@@ -3,38 +3,60 @@ package storage
 import (
 	"context"
 	"errors"
+	"time"
 
 	"github.com/NailUsmanov/gophermart/internal/interfaces"
 	"github.com/NailUsmanov/gophermart/internal/models"
+	"github.com/NailUsmanov/gophermart/internal/money"
 	"go.uber.org/zap"
 )
 
 var ErrOrderAlreadyUsed = errors.New("order number already used")
 var ErrOrderAlreadyUploaded = errors.New("order already uploaded by another person")
 var ErrNotEnoughFunds = errors.New("insufficient funds")
+var ErrOrderNotFound = errors.New("order not found")
+var ErrStatusConflict = errors.New("order status changed concurrently")
 
 type OrderOption interface {
 	CreateNewOrder(ctx context.Context, userNumber int, numberOrder string, sugar *zap.SugaredLogger) error
 	CheckExistOrder(ctx context.Context, numberOrder string) (bool, int, error)
 	GetOrdersByUserID(ctx context.Context, userID int) ([]Order, error)
+	// ListUserOrders возвращает страницу заказов пользователя от новых к старым
+	ListUserOrders(ctx context.Context, userID int, f OrderFilter) (OrderPage, error)
 }
 
 type WorkerAccrual interface {
-	// GetOrdersForAccrualUpdate возвращает все заказы со статусами NEW, PROCESSING,
-	// REGISTERED для обновления статуса и начислений
-	GetOrdersForAccrualUpdate(ctx context.Context) ([]Order, error)
+	// ClaimOrdersForAccrual выдает экземпляру owner в аренду на lease до limit заказов со статусами
+	// NEW, PROCESSING, REGISTERED, срок проверки которых наступил, от старых к новым.
+	// Пока аренда не истекла, заказ не выдается никому; UpdateOrderStatus и ScheduleNextCheck ее снимают
+	ClaimOrdersForAccrual(ctx context.Context, owner string, limit int, lease time.Duration) ([]Order, error)
 
-	// UpdateOrderStatus обновляет статус и сумму начислений по номеру заказа
-	// (используется воркером после запроса к accrual-системе)
-	UpdateOrderStatus(ctx context.Context, number string, status string, accrual *float64) error
+	// UpdateOrderStatus переводит заказ из t.From в t.To, сохраняет сумму начислений, сбрасывает счетчик
+	// попыток и аренду. Смена статуса пишется в order_status_history. Допустимость перехода проверяет
+	// сервисный слой; хранилище только убеждается, что заказ все еще в t.From, иначе ErrStatusConflict
+	UpdateOrderStatus(ctx context.Context, t StatusTransition, accrual *money.Amount) error
+
+	// GetOrderStatusHistory возвращает переходы заказа от старых к новым
+	GetOrderStatusHistory(ctx context.Context, number string) ([]StatusTransition, error)
+
+	// ScheduleNextCheck откладывает следующую проверку заказа на delay, увеличивает счетчик попыток и снимает аренду
+	ScheduleNextCheck(ctx context.Context, number string, delay time.Duration) error
+
+	// ReleaseOrderLease снимает аренду owner с заказа, который тот так и не опросил, не трогая счетчик попыток.
+	// Аренду, уже перешедшую к другому экземпляру, не снимает
+	ReleaseOrderLease(ctx context.Context, owner, number string) error
+
+	// NewOrders подписывает на номера заказов, созданных через CreateNewOrder. Доставка не гарантируется:
+	// уведомления могут теряться, поэтому периодический опрос остается. Канал закрывается после отмены ctx
+	NewOrders(ctx context.Context) (<-chan string, error)
 }
 type BalanceIndicator interface {
 	// Для показаний текущего баланса и трат предыдущих
-	GetUserBalance(ctx context.Context, userID int) (float64, float64, error)
+	GetUserBalance(ctx context.Context, userID int) (money.Amount, money.Amount, error)
 	// Нахождение трат пользователя
-	GetUserWithDrawns(ctx context.Context, userID int) (float64, error)
-	// Добавление суммы списаний в таблицу с заказами
-	AddWithdrawOrder(ctx context.Context, userID int, orderNumber string, sum float64) error
+	GetUserWithDrawns(ctx context.Context, userID int) (money.Amount, error)
+	// Проведение списания в ledger_entries
+	AddWithdrawOrder(ctx context.Context, userID int, orderNumber string, sum money.Amount) error
 	// Вывод всех списаний конкретного пользователя
 	GetAllUserWithdrawals(ctx context.Context, userID int) ([]models.UserWithDraw, error)
 }
@@ -42,20 +64,45 @@ type BalanceIndicator interface {
 // Создаем интерфейс для работы с ним в тестах
 type WithdrawLogic interface {
 	CheckExistOrder(ctx context.Context, numberOrder string) (bool, int, error)
-	GetUserBalance(ctx context.Context, userID int) (float64, float64, error)
-	AddWithdrawOrder(ctx context.Context, userID int, number string, sum float64) error
+	GetUserBalance(ctx context.Context, userID int) (money.Amount, money.Amount, error)
+	AddWithdrawOrder(ctx context.Context, userID int, number string, sum money.Amount) error
 }
 
 // Только для хендлера AllUserWithdrawals
 type WithdrawalFetcher interface {
 	GetAllUserWithdrawals(ctx context.Context, userID int) ([]models.UserWithDraw, error)
+	// ListUserWithdrawals возвращает страницу списаний от новых к старым и итог по фильтру
+	ListUserWithdrawals(ctx context.Context, userID int, f WithdrawalFilter) (WithdrawalPage, error)
+}
+
+// Сверка материализованных балансов с историей движений
+type BalanceReconciler interface {
+	ReconcileBalances(ctx context.Context, repair bool) ([]models.BalanceDrift, error)
+}
+
+// Карантин подозрительных ответов accrual для разбора оператором
+type AccrualQuarantine interface {
+	QuarantineAccrualResponse(ctx context.Context, q models.QuarantinedResponse) error
+	// ListQuarantinedResponses возвращает до limit последних ответов, от новых к старым
+	ListQuarantinedResponses(ctx context.Context, limit int) ([]models.QuarantinedResponse, error)
+}
+
+// Заказы, снятые с опроса accrual, для админского API
+type StuckOrderLister interface {
+	// ListStuckOrders возвращает до limit заказов в STUCK, от давно застрявших к недавним
+	ListStuckOrders(ctx context.Context, limit int) ([]models.StuckOrder, error)
 }
 
 type Storage interface {
 	WithdrawLogic
 	interfaces.Auth
+	interfaces.Sessions
+	interfaces.IdempotencyKeys
 	OrderOption
 	WorkerAccrual
 	BalanceIndicator
 	WithdrawalFetcher
+	BalanceReconciler
+	AccrualQuarantine
+	StuckOrderLister
 }
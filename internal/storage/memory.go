@@ -0,0 +1,705 @@
+package storage
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/NailUsmanov/gophermart/internal/auth"
+	"github.com/NailUsmanov/gophermart/internal/models"
+	"github.com/NailUsmanov/gophermart/internal/money"
+	"go.uber.org/zap"
+)
+
+// MemStorage - потокобезопасная реализация Storage в памяти для локальной разработки и тестов.
+// Ошибки совпадают с DataBaseStorage, данные теряются при перезапуске
+type MemStorage struct {
+	mu sync.RWMutex
+
+	nextUserID  int
+	nextOrderID int64
+	nextEntryID int64
+
+	users    map[string]*memUser // по логину
+	orders   map[string]*memOrder
+	ledger   []memEntry
+	balances map[int]*memBalance
+	sessions map[string]*memSession
+	history  []StatusTransition
+	// quarantine - подозрительные ответы accrual в порядке поступления
+	quarantine  []models.QuarantinedResponse
+	idempotency map[memIdempotencyKey]*memIdempotent
+
+	newOrders orderNotifier
+}
+
+type memUser struct {
+	id       int
+	password string
+}
+
+type memOrder struct {
+	id         int64
+	userID     int
+	number     string
+	status     string
+	accrual    *money.Amount
+	uploadedAt time.Time
+	// queuedAt - когда заказ последний раз вернули в опрос; нулевое значение - опрашивается с загрузки
+	queuedAt time.Time
+	// nextCheckAt - не опрашивать accrual раньше; нулевое значение - опросить сразу
+	nextCheckAt time.Time
+	attempts    int
+	// leaseOwner арендовал заказ до leaseExpiresAt
+	leaseOwner     string
+	leaseExpiresAt time.Time
+}
+
+type memEntry struct {
+	id          int64
+	userID      int
+	credit      bool
+	orderNumber string
+	amount      money.Amount
+	createdAt   time.Time
+}
+
+type memBalance struct {
+	current   money.Amount
+	withdrawn money.Amount
+}
+
+type memSession struct {
+	models.Session
+	userID  int
+	revoked bool
+}
+
+func NewMemStorage() *MemStorage {
+	return &MemStorage{
+		users:    make(map[string]*memUser),
+		orders:   make(map[string]*memOrder),
+		balances: make(map[int]*memBalance),
+		sessions: make(map[string]*memSession),
+
+		idempotency: make(map[memIdempotencyKey]*memIdempotent),
+	}
+}
+
+func (m *MemStorage) Registration(ctx context.Context, login, password string) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if _, ok := m.users[login]; ok {
+		return ErrOrderAlreadyUsed
+	}
+	m.nextUserID++
+	m.users[login] = &memUser{id: m.nextUserID, password: password}
+	m.balances[m.nextUserID] = &memBalance{}
+	return nil
+}
+
+func (m *MemStorage) GetUserByLogin(ctx context.Context, login string) (string, error) {
+	if err := ctx.Err(); err != nil {
+		return "", err
+	}
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	u, ok := m.users[login]
+	if !ok {
+		return "", nil
+	}
+	return u.password, nil
+}
+
+func (m *MemStorage) GetUserIDByLogin(ctx context.Context, login string) (int, error) {
+	if err := ctx.Err(); err != nil {
+		return 0, err
+	}
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	u, ok := m.users[login]
+	if !ok {
+		return 0, fmt.Errorf("failed to get user ID: user %q not found", login)
+	}
+	return u.id, nil
+}
+
+func (m *MemStorage) UpdatePasswordHash(ctx context.Context, login, passwordHash string) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if u, ok := m.users[login]; ok {
+		u.password = passwordHash
+	}
+	return nil
+}
+
+func (m *MemStorage) CreateSession(ctx context.Context, userID int, userAgent, ip string, ttl time.Duration) (string, error) {
+	if err := ctx.Err(); err != nil {
+		return "", err
+	}
+	sessionID, err := auth.NewSessionID()
+	if err != nil {
+		return "", err
+	}
+	now := time.Now()
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.sessions[sessionID] = &memSession{
+		Session: models.Session{
+			ID:         sessionID,
+			UserAgent:  userAgent,
+			IP:         ip,
+			CreatedAt:  now,
+			LastSeenAt: now,
+			ExpiresAt:  now.Add(ttl),
+		},
+		userID: userID,
+	}
+	return sessionID, nil
+}
+
+func (m *MemStorage) TouchSession(ctx context.Context, sessionID string, userID int, ttl time.Duration) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	s, ok := m.sessions[sessionID]
+	if !ok || s.userID != userID {
+		return auth.ErrSessionNotFound
+	}
+	if s.revoked {
+		return auth.ErrSessionRevoked
+	}
+	now := time.Now()
+	if !s.ExpiresAt.After(now) {
+		return auth.ErrSessionExpired
+	}
+	s.LastSeenAt = now
+	s.ExpiresAt = now.Add(ttl)
+	return nil
+}
+
+func (m *MemStorage) ListSessions(ctx context.Context, userID int) ([]models.Session, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	now := time.Now()
+	sessions := make([]models.Session, 0)
+	for _, s := range m.sessions {
+		if s.userID == userID && !s.revoked && s.ExpiresAt.After(now) {
+			sessions = append(sessions, s.Session)
+		}
+	}
+	sort.Slice(sessions, func(i, j int) bool {
+		return sessions[i].LastSeenAt.After(sessions[j].LastSeenAt)
+	})
+	return sessions, nil
+}
+
+func (m *MemStorage) RevokeSession(ctx context.Context, userID int, sessionID string) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	s, ok := m.sessions[sessionID]
+	if !ok || s.userID != userID || s.revoked {
+		return auth.ErrSessionNotFound
+	}
+	s.revoked = true
+	return nil
+}
+
+func (m *MemStorage) CreateNewOrder(ctx context.Context, userNumber int, numberOrder string, sugar *zap.SugaredLogger) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if _, ok := m.orders[numberOrder]; ok {
+		return ErrOrderAlreadyUploaded
+	}
+	m.nextOrderID++
+	m.orders[numberOrder] = &memOrder{
+		id:         m.nextOrderID,
+		userID:     userNumber,
+		number:     numberOrder,
+		status:     "NEW",
+		uploadedAt: time.Now(),
+	}
+	m.newOrders.publish(numberOrder)
+	sugar.Infof("Order %s created for user %d", numberOrder, userNumber)
+	return nil
+}
+
+func (m *MemStorage) CheckExistOrder(ctx context.Context, numberOrder string) (bool, int, error) {
+	if err := ctx.Err(); err != nil {
+		return false, -1, err
+	}
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	o, ok := m.orders[numberOrder]
+	if !ok {
+		return false, -1, nil
+	}
+	return true, o.userID, nil
+}
+
+func (m *MemStorage) GetOrdersByUserID(ctx context.Context, userID int) ([]Order, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	found := make([]*memOrder, 0)
+	for _, o := range m.orders {
+		if o.userID == userID {
+			found = append(found, o)
+		}
+	}
+	// Как и в PostgreSQL - от новых к старым
+	sort.Slice(found, func(i, j int) bool {
+		if !found[i].uploadedAt.Equal(found[j].uploadedAt) {
+			return found[i].uploadedAt.After(found[j].uploadedAt)
+		}
+		return found[i].id > found[j].id
+	})
+	orders := make([]Order, 0, len(found))
+	for _, o := range found {
+		orders = append(orders, o.toOrder())
+	}
+	return orders, nil
+}
+
+func (m *MemStorage) ListUserOrders(ctx context.Context, userID int, f OrderFilter) (OrderPage, error) {
+	if err := ctx.Err(); err != nil {
+		return OrderPage{}, err
+	}
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	statuses := make(map[string]bool, len(f.Statuses))
+	for _, st := range f.Statuses {
+		statuses[st] = true
+	}
+	found := make([]*memOrder, 0)
+	for _, o := range m.orders {
+		switch {
+		case o.userID != userID,
+			len(statuses) > 0 && !statuses[o.status],
+			!f.From.IsZero() && o.uploadedAt.Before(f.From),
+			!f.To.IsZero() && !o.uploadedAt.Before(f.To),
+			f.After != nil && !o.before(*f.After):
+			continue
+		}
+		found = append(found, o)
+	}
+	sort.Slice(found, func(i, j int) bool {
+		return found[j].before(OrderCursor{UploadedAt: found[i].uploadedAt, ID: found[i].id})
+	})
+	if len(found) > f.Limit+1 {
+		found = found[:f.Limit+1]
+	}
+	orders := make([]Order, 0, len(found))
+	ids := make([]int64, 0, len(found))
+	for _, o := range found {
+		orders = append(orders, o.toOrder())
+		ids = append(ids, o.id)
+	}
+	return orderPage(orders, ids, f.Limit), nil
+}
+
+// before сообщает, что заказ идет в списке после позиции c: загружен раньше или тогда же, но с меньшим id
+func (o *memOrder) before(c OrderCursor) bool {
+	if !o.uploadedAt.Equal(c.UploadedAt) {
+		return o.uploadedAt.Before(c.UploadedAt)
+	}
+	return o.id < c.ID
+}
+
+func (m *MemStorage) ClaimOrdersForAccrual(ctx context.Context, owner string, limit int, lease time.Duration) ([]Order, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	now := time.Now()
+	due := make([]*memOrder, 0)
+	for _, o := range m.orders {
+		switch o.status {
+		case "NEW", "PROCESSING", "REGISTERED":
+			if !o.nextCheckAt.After(now) && !o.leaseExpiresAt.After(now) {
+				due = append(due, o)
+			}
+		}
+	}
+	// От старых к новым
+	sort.Slice(due, func(i, j int) bool {
+		if !due[i].uploadedAt.Equal(due[j].uploadedAt) {
+			return due[i].uploadedAt.Before(due[j].uploadedAt)
+		}
+		return due[i].id < due[j].id
+	})
+	if len(due) > limit {
+		due = due[:limit]
+	}
+	orders := make([]Order, 0, len(due))
+	for _, o := range due {
+		o.leaseOwner = owner
+		o.leaseExpiresAt = now.Add(lease)
+		order := o.toOrder()
+		order.Attempts = o.attempts
+		order.QueuedAt = o.uploadedAt
+		if !o.queuedAt.IsZero() {
+			order.QueuedAt = o.queuedAt
+		}
+		orders = append(orders, order)
+	}
+	return orders, nil
+}
+
+func (m *MemStorage) ScheduleNextCheck(ctx context.Context, number string, delay time.Duration) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if o, ok := m.orders[number]; ok {
+		o.attempts++
+		o.nextCheckAt = time.Now().Add(delay)
+		o.releaseLease()
+	}
+	return nil
+}
+
+func (m *MemStorage) ReleaseOrderLease(ctx context.Context, owner, number string) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if o, ok := m.orders[number]; ok && o.leaseOwner == owner {
+		o.releaseLease()
+	}
+	return nil
+}
+
+func (m *MemStorage) UpdateOrderStatus(ctx context.Context, t StatusTransition, accrual *money.Amount) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	o, ok := m.orders[t.Number]
+	if !ok {
+		return fmt.Errorf("order %s: %w", t.Number, ErrOrderNotFound)
+	}
+	if o.status != t.From {
+		return fmt.Errorf("order %s is %s, not %s: %w", t.Number, o.status, t.From, ErrStatusConflict)
+	}
+	o.status = t.To
+	if t.To == "NEW" {
+		o.queuedAt = time.Now()
+	}
+	o.attempts = 0
+	o.nextCheckAt = time.Time{}
+	o.releaseLease()
+	o.accrual = nil
+	if accrual != nil {
+		v := *accrual
+		o.accrual = &v
+	}
+	if t.From != t.To {
+		t.ChangedAt = time.Now()
+		m.history = append(m.history, t)
+	}
+	// Начисление проводим один раз на заказ, как UNIQUE (source, order_number) в PostgreSQL
+	if t.To == "PROCESSED" && accrual != nil && *accrual > 0 && !m.hasEntry(true, t.Number) {
+		m.addEntry(o.userID, true, t.Number, *accrual)
+		m.balance(o.userID).current += *accrual
+	}
+	return nil
+}
+
+func (m *MemStorage) GetOrderStatusHistory(ctx context.Context, number string) ([]StatusTransition, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	history := make([]StatusTransition, 0)
+	for _, t := range m.history {
+		if t.Number == number {
+			history = append(history, t)
+		}
+	}
+	return history, nil
+}
+
+func (m *MemStorage) GetUserBalance(ctx context.Context, userID int) (money.Amount, money.Amount, error) {
+	if err := ctx.Err(); err != nil {
+		return 0, 0, err
+	}
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	b, ok := m.balances[userID]
+	if !ok {
+		return 0, 0, nil
+	}
+	return b.current, b.withdrawn, nil
+}
+
+func (m *MemStorage) GetUserWithDrawns(ctx context.Context, userID int) (money.Amount, error) {
+	_, withdrawn, err := m.GetUserBalance(ctx, userID)
+	return withdrawn, err
+}
+
+func (m *MemStorage) AddWithdrawOrder(ctx context.Context, userID int, orderNumber string, sum money.Amount) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	// Проверка и списание под одной блокировкой - параллельные списания не уведут баланс в минус
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.hasEntry(false, orderNumber) {
+		return ErrOrderAlreadyUsed
+	}
+	b := m.balance(userID)
+	if sum > b.current {
+		return ErrNotEnoughFunds
+	}
+	m.addEntry(userID, false, orderNumber, sum)
+	b.current -= sum
+	b.withdrawn += sum
+	return nil
+}
+
+func (m *MemStorage) GetAllUserWithdrawals(ctx context.Context, userID int) ([]models.UserWithDraw, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	withdrawals := make([]models.UserWithDraw, 0)
+	// ledger хранится в порядке добавления, идем с конца - от новых к старым
+	for i := len(m.ledger) - 1; i >= 0; i-- {
+		e := m.ledger[i]
+		if e.userID == userID && !e.credit {
+			withdrawals = append(withdrawals, models.UserWithDraw{
+				NumberOrder: e.orderNumber,
+				Sum:         e.amount,
+				ProcessedAt: e.createdAt,
+			})
+		}
+	}
+	return withdrawals, nil
+}
+
+func (m *MemStorage) ListUserWithdrawals(ctx context.Context, userID int, f WithdrawalFilter) (WithdrawalPage, error) {
+	if err := ctx.Err(); err != nil {
+		return WithdrawalPage{}, err
+	}
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	var page WithdrawalPage
+	withdrawals := make([]models.UserWithDraw, 0)
+	ids := make([]int64, 0)
+	// ledger хранится в порядке добавления, идем с конца - от новых к старым
+	for i := len(m.ledger) - 1; i >= 0; i-- {
+		e := m.ledger[i]
+		switch {
+		case e.userID != userID, e.credit,
+			!f.From.IsZero() && e.createdAt.Before(f.From),
+			!f.To.IsZero() && !e.createdAt.Before(f.To),
+			f.MinSum != nil && e.amount < *f.MinSum,
+			f.MaxSum != nil && e.amount > *f.MaxSum:
+			continue
+		}
+		page.Total += e.amount
+		page.Count++
+		if f.After != nil && !e.before(*f.After) || len(withdrawals) > f.Limit {
+			continue
+		}
+		withdrawals = append(withdrawals, models.UserWithDraw{
+			NumberOrder: e.orderNumber,
+			Sum:         e.amount,
+			ProcessedAt: e.createdAt,
+		})
+		ids = append(ids, e.id)
+	}
+	page.Withdrawals, page.Next = withdrawalPage(withdrawals, ids, f.Limit)
+	return page, nil
+}
+
+// before сообщает, что движение идет в списке после позиции c
+func (e memEntry) before(c WithdrawalCursor) bool {
+	if !e.createdAt.Equal(c.ProcessedAt) {
+		return e.createdAt.Before(c.ProcessedAt)
+	}
+	return e.id < c.ID
+}
+
+func (m *MemStorage) QuarantineAccrualResponse(ctx context.Context, q models.QuarantinedResponse) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	q.ID = int64(len(m.quarantine) + 1)
+	q.ReceivedAt = time.Now()
+	m.quarantine = append(m.quarantine, q)
+	return nil
+}
+
+func (m *MemStorage) ListQuarantinedResponses(ctx context.Context, limit int) ([]models.QuarantinedResponse, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	responses := make([]models.QuarantinedResponse, 0, min(limit, len(m.quarantine)))
+	for i := len(m.quarantine) - 1; i >= 0 && len(responses) < limit; i-- {
+		responses = append(responses, m.quarantine[i])
+	}
+	return responses, nil
+}
+
+func (m *MemStorage) ListStuckOrders(ctx context.Context, limit int) ([]models.StuckOrder, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	// Последний переход в STUCK у каждого застрявшего заказа; history уже упорядочена по времени
+	last := make(map[string]int)
+	for i, t := range m.history {
+		if t.To == "STUCK" && m.orders[t.Number].status == "STUCK" {
+			last[t.Number] = i
+		}
+	}
+	idx := make([]int, 0, len(last))
+	for _, i := range last {
+		idx = append(idx, i)
+	}
+	sort.Ints(idx)
+	orders := make([]models.StuckOrder, 0, min(limit, len(idx)))
+	for _, i := range idx {
+		if len(orders) >= limit {
+			break
+		}
+		t := m.history[i]
+		o := m.orders[t.Number]
+		orders = append(orders, models.StuckOrder{
+			Number:     o.number,
+			UserID:     o.userID,
+			UploadedAt: o.uploadedAt,
+			StuckAt:    t.ChangedAt,
+		})
+	}
+	return orders, nil
+}
+
+func (m *MemStorage) ReconcileBalances(ctx context.Context, repair bool) ([]models.BalanceDrift, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	expected := make(map[int]*memBalance)
+	for _, u := range m.users {
+		expected[u.id] = &memBalance{}
+	}
+	for _, e := range m.ledger {
+		b, ok := expected[e.userID]
+		if !ok {
+			b = &memBalance{}
+			expected[e.userID] = b
+		}
+		if e.credit {
+			b.current += e.amount
+		} else {
+			b.current -= e.amount
+			b.withdrawn += e.amount
+		}
+	}
+	drifts := make([]models.BalanceDrift, 0)
+	for userID, want := range expected {
+		got, ok := m.balances[userID]
+		if ok && *got == *want {
+			continue
+		}
+		drift := models.BalanceDrift{UserID: userID, ExpectedCurrent: want.current, ExpectedWithdrawn: want.withdrawn}
+		if ok {
+			drift.Current, drift.Withdrawn = got.current, got.withdrawn
+		}
+		drifts = append(drifts, drift)
+		if repair {
+			v := *want
+			m.balances[userID] = &v
+		}
+	}
+	sort.Slice(drifts, func(i, j int) bool { return drifts[i].UserID < drifts[j].UserID })
+	return drifts, nil
+}
+
+// balance возвращает баланс пользователя, создавая его при необходимости. Вызывать под m.mu
+func (m *MemStorage) balance(userID int) *memBalance {
+	b, ok := m.balances[userID]
+	if !ok {
+		b = &memBalance{}
+		m.balances[userID] = b
+	}
+	return b
+}
+
+// hasEntry проверяет, проведено ли уже движение по номеру заказа. Вызывать под m.mu
+func (m *MemStorage) hasEntry(credit bool, orderNumber string) bool {
+	for _, e := range m.ledger {
+		if e.credit == credit && e.orderNumber == orderNumber {
+			return true
+		}
+	}
+	return false
+}
+
+// addEntry добавляет движение в ledger. Вызывать под m.mu
+func (m *MemStorage) addEntry(userID int, credit bool, orderNumber string, amount money.Amount) {
+	m.nextEntryID++
+	m.ledger = append(m.ledger, memEntry{
+		id:          m.nextEntryID,
+		userID:      userID,
+		credit:      credit,
+		orderNumber: orderNumber,
+		amount:      amount,
+		createdAt:   time.Now(),
+	})
+}
+
+func (o *memOrder) releaseLease() {
+	o.leaseOwner = ""
+	o.leaseExpiresAt = time.Time{}
+}
+
+func (o *memOrder) toOrder() Order {
+	status := o.status
+	order := Order{
+		Number:     o.number,
+		Status:     &status,
+		UploadedAt: o.uploadedAt,
+	}
+	if o.accrual != nil {
+		v := *o.accrual
+		order.Accrual = &v
+	}
+	return order
+}
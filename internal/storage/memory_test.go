@@ -0,0 +1,110 @@
+package storage_test
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"testing"
+
+	"github.com/NailUsmanov/gophermart/internal/money"
+	"github.com/NailUsmanov/gophermart/internal/storage"
+	"github.com/NailUsmanov/gophermart/internal/storage/storagetest"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+)
+
+func TestMemStorageConformance(t *testing.T) {
+	storagetest.Run(t, func(t *testing.T) storage.Storage {
+		return storage.NewMemStorage()
+	})
+}
+
+func TestMemStorageOrders(t *testing.T) {
+	s := storage.NewMemStorage()
+	ctx := context.Background()
+	sugar := zap.NewNop().Sugar()
+
+	require.NoError(t, s.Registration(ctx, "user", "hash"))
+	assert.ErrorIs(t, s.Registration(ctx, "user", "hash"), storage.ErrOrderAlreadyUsed)
+	userID, err := s.GetUserIDByLogin(ctx, "user")
+	require.NoError(t, err)
+
+	require.NoError(t, s.CreateNewOrder(ctx, userID, "12345678903", sugar))
+	assert.ErrorIs(t, s.CreateNewOrder(ctx, userID, "12345678903", sugar), storage.ErrOrderAlreadyUploaded)
+
+	exists, owner, err := s.CheckExistOrder(ctx, "12345678903")
+	require.NoError(t, err)
+	assert.True(t, exists)
+	assert.Equal(t, userID, owner)
+
+	pending, err := s.ClaimOrdersForAccrual(ctx, "test", 10, 0)
+	require.NoError(t, err)
+	assert.Len(t, pending, 1)
+
+	accrual := money.Amount(50000)
+	require.NoError(t, s.UpdateOrderStatus(ctx, storage.StatusTransition{Number: "12345678903", From: "NEW", To: "PROCESSED", Source: "test"}, &accrual))
+	require.NoError(t, s.UpdateOrderStatus(ctx, storage.StatusTransition{Number: "12345678903", From: "PROCESSED", To: "PROCESSED", Source: "test"}, &accrual))
+	assert.Error(t, s.UpdateOrderStatus(ctx, storage.StatusTransition{Number: "79927398713", From: "NEW", To: "PROCESSED", Source: "test"}, &accrual))
+
+	pending, err = s.ClaimOrdersForAccrual(ctx, "test", 10, 0)
+	require.NoError(t, err)
+	assert.Empty(t, pending)
+
+	require.NoError(t, s.AddWithdrawOrder(ctx, userID, "2377225624", money.Amount(10000)))
+	assert.ErrorIs(t, s.AddWithdrawOrder(ctx, userID, "2377225624", money.Amount(100)), storage.ErrOrderAlreadyUsed)
+	assert.ErrorIs(t, s.AddWithdrawOrder(ctx, userID, "49927398716", money.Amount(50000)), storage.ErrNotEnoughFunds)
+
+	current, withdrawn, err := s.GetUserBalance(ctx, userID)
+	require.NoError(t, err)
+	assert.Equal(t, money.Amount(40000), current)
+	assert.Equal(t, money.Amount(10000), withdrawn)
+
+	drifts, err := s.ReconcileBalances(ctx, false)
+	require.NoError(t, err)
+	assert.Empty(t, drifts)
+}
+
+func TestMemStorageWithdrawConcurrent(t *testing.T) {
+	s := storage.NewMemStorage()
+	ctx := context.Background()
+	sugar := zap.NewNop().Sugar()
+
+	require.NoError(t, s.Registration(ctx, "user", "hash"))
+	userID, err := s.GetUserIDByLogin(ctx, "user")
+	require.NoError(t, err)
+	require.NoError(t, s.CreateNewOrder(ctx, userID, "12345678903", sugar))
+	accrual := money.Amount(10000)
+	require.NoError(t, s.UpdateOrderStatus(ctx, storage.StatusTransition{Number: "12345678903", From: "NEW", To: "PROCESSED", Source: "test"}, &accrual))
+
+	const attempts = 300
+	var (
+		wg        sync.WaitGroup
+		mu        sync.Mutex
+		succeeded int
+	)
+	for i := 0; i < attempts; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			err := s.AddWithdrawOrder(ctx, userID, fmt.Sprintf("w%d", i), money.Amount(100))
+			if err != nil && !errors.Is(err, storage.ErrNotEnoughFunds) {
+				t.Errorf("unexpected error: %v", err)
+				return
+			}
+			if err == nil {
+				mu.Lock()
+				succeeded++
+				mu.Unlock()
+			}
+		}(i)
+	}
+	wg.Wait()
+
+	current, withdrawn, err := s.GetUserBalance(ctx, userID)
+	require.NoError(t, err)
+	assert.Equal(t, 100, succeeded)
+	assert.Equal(t, money.Amount(0), current)
+	assert.Equal(t, money.Amount(10000), withdrawn)
+}
@@ -0,0 +1,127 @@
+package storage
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/jackc/pgx/v5/stdlib"
+)
+
+// NewOrdersChannel - канал LISTEN/NOTIFY в PostgreSQL, в который CreateNewOrder пишет номер заказа
+const NewOrdersChannel = "new_orders"
+
+// Размер буфера подписки: уведомления сверх него отбрасываются, заказ все равно заберет тикер воркера
+const notifyBuffer = 64
+
+// Пауза перед повторным LISTEN после потери соединения
+const relistenDelay = time.Second
+
+// orderNotifier рассылает номера новых заказов подписчикам внутри процесса.
+// Нулевое значение готово к работе
+type orderNotifier struct {
+	mu   sync.Mutex
+	subs map[chan string]struct{}
+}
+
+// subscribe возвращает канал с номерами новых заказов; канал закрывается после отмены ctx
+func (n *orderNotifier) subscribe(ctx context.Context) <-chan string {
+	ch := make(chan string, notifyBuffer)
+	n.mu.Lock()
+	if n.subs == nil {
+		n.subs = make(map[chan string]struct{})
+	}
+	n.subs[ch] = struct{}{}
+	n.mu.Unlock()
+
+	go func() {
+		<-ctx.Done()
+		n.mu.Lock()
+		defer n.mu.Unlock()
+		delete(n.subs, ch)
+		close(ch)
+	}()
+	return ch
+}
+
+// publish не блокируется: медленный подписчик теряет уведомление, а не тормозит создание заказа
+func (n *orderNotifier) publish(number string) {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	for ch := range n.subs {
+		select {
+		case ch <- number:
+		default:
+		}
+	}
+}
+
+func (m *MemStorage) NewOrders(ctx context.Context) (<-chan string, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	return m.newOrders.subscribe(ctx), nil
+}
+
+// SQLite работает внутри одного процесса, поэтому хватает рассылки в памяти
+func (s *SQLiteStorage) NewOrders(ctx context.Context) (<-chan string, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	return s.newOrders.subscribe(ctx), nil
+}
+
+// NewOrders слушает NewOrdersChannel на отдельном соединении из пула. Уведомления приходят от всех
+// экземпляров сервиса. Если соединение оборвалось, LISTEN повторяется, пока ctx не отменен
+func (d *DataBaseStorage) NewOrders(ctx context.Context) (<-chan string, error) {
+	conn, err := d.db.Conn(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get listen connection: %w", err)
+	}
+	ch := make(chan string, notifyBuffer)
+	go func() {
+		defer close(ch)
+		for {
+			d.listen(ctx, conn, ch)
+			if ctx.Err() != nil {
+				return
+			}
+			select {
+			case <-ctx.Done():
+				return
+			case <-time.After(relistenDelay):
+			}
+			// При ошибке conn == nil, и listen сразу вернется к паузе
+			conn, _ = d.db.Conn(ctx)
+		}
+	}()
+	return ch, nil
+}
+
+// listen держит LISTEN до ошибки соединения или отмены ctx, после чего закрывает conn
+func (d *DataBaseStorage) listen(ctx context.Context, conn *sql.Conn, ch chan<- string) {
+	if conn == nil {
+		return
+	}
+	defer conn.Close()
+	_ = conn.Raw(func(driverConn any) error {
+		pgConn := driverConn.(*stdlib.Conn).Conn()
+		if _, err := pgConn.Exec(ctx, "LISTEN "+NewOrdersChannel); err != nil {
+			return driver.ErrBadConn
+		}
+		for {
+			n, err := pgConn.WaitForNotification(ctx)
+			if err != nil {
+				// Соединение с активным LISTEN нельзя возвращать в пул
+				return driver.ErrBadConn
+			}
+			select {
+			case ch <- n.Payload:
+			default:
+			}
+		}
+	})
+}
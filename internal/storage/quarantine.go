@@ -0,0 +1,56 @@
+package storage
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/NailUsmanov/gophermart/internal/models"
+)
+
+func (d *DataBaseStorage) QuarantineAccrualResponse(ctx context.Context, q models.QuarantinedResponse) error {
+	_, err := d.db.ExecContext(ctx, QuarantineAccrualResponsePostgres, q.OrderNumber, q.Reason, q.Response)
+	if err != nil {
+		return fmt.Errorf("failed to quarantine accrual response: %w", err)
+	}
+	return nil
+}
+
+func (d *DataBaseStorage) ListQuarantinedResponses(ctx context.Context, limit int) ([]models.QuarantinedResponse, error) {
+	responses := make([]models.QuarantinedResponse, 0)
+	rows, err := d.db.QueryContext(ctx, ListQuarantinedResponsesPostgres, limit)
+	if err != nil {
+		return nil, fmt.Errorf("db query: %v", err)
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var q models.QuarantinedResponse
+		if err := rows.Scan(&q.ID, &q.OrderNumber, &q.Reason, &q.Response, &q.ReceivedAt); err != nil {
+			return nil, fmt.Errorf("scan row: %v", err)
+		}
+		responses = append(responses, q)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("row iteration: %v", err)
+	}
+	return responses, nil
+}
+
+func (d *DataBaseStorage) ListStuckOrders(ctx context.Context, limit int) ([]models.StuckOrder, error) {
+	orders := make([]models.StuckOrder, 0)
+	rows, err := d.db.QueryContext(ctx, ListStuckOrdersPostgres, limit)
+	if err != nil {
+		return nil, fmt.Errorf("db query: %v", err)
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var o models.StuckOrder
+		if err := rows.Scan(&o.Number, &o.UserID, &o.UploadedAt, &o.StuckAt); err != nil {
+			return nil, fmt.Errorf("scan row: %v", err)
+		}
+		orders = append(orders, o)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("row iteration: %v", err)
+	}
+	return orders, nil
+}
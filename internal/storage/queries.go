@@ -1,10 +1,23 @@
 package storage
 
-var RegistrationPostgres string = "INSERT INTO personal_account (login, password) VALUES ($1, $2)"
+var RegistrationPostgres string = `
+WITH account AS (
+	INSERT INTO personal_account (login, password) VALUES ($1, $2) RETURNING id
+)
+INSERT INTO balances (user_id) SELECT id FROM account
+`
 var CheckLoginPostgres = "SELECT password FROM personal_account WHERE login = $1"
-var CheckHashPasswordPostgres string = "SELECT password FROM personal_account WHERE login = $1"
+var UpdatePasswordHashPostgres string = "UPDATE personal_account SET password = $1 WHERE login = $2"
 var CheckUserOrderPostgres = "SELECT user_id FROM orders WHERE order_number = $1"
-var CreateNewOrderPostgres = "INSERT INTO orders (order_number, user_id, status) VALUES ($1, $2,'NEW')"
+
+// NOTIFY уходит подписчикам только после коммита, поэтому воркер не увидит заказ раньше, чем тот появится в базе
+var CreateNewOrderPostgres = `
+WITH inserted AS (
+	INSERT INTO orders (order_number, user_id, status) VALUES ($1, $2, 'NEW')
+	RETURNING order_number
+)
+SELECT pg_notify('` + NewOrdersChannel + `', order_number) FROM inserted
+`
 var LoginIDPostgres string = "SELECT id FROM personal_account WHERE login = $1"
 var GetUserOrdersQuery string = `
 	SELECT order_number, status, accrual, uploaded_at
@@ -12,33 +25,216 @@ var GetUserOrdersQuery string = `
 	WHERE user_id = $1
 	ORDER BY uploaded_at DESC;
 `
+
+// Пустой массив статусов и NULL во времени отключают соответствующий фильтр
+var ListUserOrdersPostgres string = `
+SELECT id, order_number, status, accrual, uploaded_at
+FROM orders
+WHERE user_id = $1
+	AND (cardinality($2::text[]) = 0 OR COALESCE(status, 'NEW') = ANY($2::text[]))
+	AND ($3::timestamp IS NULL OR uploaded_at >= $3)
+	AND ($4::timestamp IS NULL OR uploaded_at < $4)
+	AND ($5::timestamp IS NULL OR (uploaded_at, id) < ($5, $6))
+ORDER BY uploaded_at DESC, id DESC
+LIMIT $7
+`
+var LockOrderStatusPostgres string = "SELECT user_id, COALESCE(status, 'NEW') FROM orders WHERE order_number = $1 FOR UPDATE"
 var UpdateOrderStatusPostgres string = `
 UPDATE orders
-SET status = $1, accrual = $2
+SET status = $1, accrual = $2, attempts = 0, next_check_at = NULL, lease_owner = NULL, lease_expires_at = NULL,
+	queued_at = CASE WHEN $1 = 'NEW' THEN now() ELSE queued_at END
 WHERE order_number = $3
 `
-var GetOrdersForAccrual string = `
-SELECT order_number, accrual, uploaded_at 
-FROM orders 
-WHERE status IN ('NEW', 'PROCESSING', 'REGISTERED')
+var AddStatusHistoryPostgres string = `
+INSERT INTO order_status_history (order_number, from_status, to_status, source)
+VALUES ($1, $2, $3, $4)
 `
-var GetBalanceIncome string = `
-SELECT SUM(accrual)
-FROM orders
-WHERE status = 'PROCESSED' AND user_id = $1
+var GetOrderStatusHistoryPostgres string = `
+SELECT from_status, to_status, source, changed_at
+FROM order_status_history
+WHERE order_number = $1
+ORDER BY id
 `
-var GetBalanceWithDrawn string = `
-SELECT SUM(accrual)
-FROM orders
-WHERE status = 'WITHDRAWN' AND user_id = $1
+var ClaimOrdersForAccrualPostgres string = `
+WITH due AS (
+	SELECT id
+	FROM orders
+	WHERE status IN ('NEW', 'PROCESSING', 'REGISTERED')
+		AND (next_check_at IS NULL OR next_check_at <= now())
+		AND (lease_expires_at IS NULL OR lease_expires_at <= now())
+	ORDER BY uploaded_at, id
+	LIMIT $2
+	FOR UPDATE SKIP LOCKED
+), claimed AS (
+	UPDATE orders o
+	SET lease_owner = $1, lease_expires_at = now() + $3 * interval '1 second'
+	FROM due
+	WHERE o.id = due.id
+	RETURNING o.id, o.order_number, COALESCE(o.status, 'NEW') AS status, o.accrual, o.uploaded_at, o.queued_at, o.attempts
+)
+SELECT order_number, status, accrual, uploaded_at, queued_at, attempts
+FROM claimed
+ORDER BY uploaded_at, id
+`
+var ScheduleNextCheckPostgres string = `
+UPDATE orders
+SET attempts = attempts + 1, next_check_at = now() + $2 * interval '1 second',
+	lease_owner = NULL, lease_expires_at = NULL
+WHERE order_number = $1
+`
+var ReleaseOrderLeasePostgres string = `
+UPDATE orders
+SET lease_owner = NULL, lease_expires_at = NULL
+WHERE order_number = $1 AND lease_owner = $2
+`
+var AddLedgerCreditPostgres string = `
+INSERT INTO ledger_entries (user_id, entry_type, source, order_number, amount)
+VALUES ($1, 'CREDIT', 'ACCRUAL', $2, $3)
+ON CONFLICT (source, order_number) DO NOTHING
+`
+var CreditBalancePostgres string = `
+INSERT INTO balances (user_id, current) VALUES ($1, $2)
+ON CONFLICT (user_id) DO UPDATE
+SET current = balances.current + EXCLUDED.current, updated_at = now()
+`
+var EnsureBalancePostgres string = "INSERT INTO balances (user_id) VALUES ($1) ON CONFLICT (user_id) DO NOTHING"
+var LockBalancePostgres string = "SELECT current FROM balances WHERE user_id = $1 FOR UPDATE"
+var DebitBalancePostgres string = `
+UPDATE balances
+SET current = current - $2, withdrawn = withdrawn + $2, updated_at = now()
+WHERE user_id = $1
+`
+var GetBalancePostgres string = "SELECT current, withdrawn FROM balances WHERE user_id = $1"
+var GetBalanceFromLedger string = `
+SELECT
+	COALESCE(SUM(amount) FILTER (WHERE entry_type = 'CREDIT'), 0),
+	COALESCE(SUM(amount) FILTER (WHERE entry_type = 'DEBIT'), 0)
+FROM ledger_entries
+WHERE user_id = $1
+`
+var FindBalanceDriftPostgres string = `
+SELECT p.id,
+	COALESCE(b.current, 0), COALESCE(b.withdrawn, 0),
+	COALESCE(l.credited, 0) - COALESCE(l.debited, 0), COALESCE(l.debited, 0)
+FROM personal_account p
+LEFT JOIN balances b ON b.user_id = p.id
+LEFT JOIN (
+	SELECT user_id,
+		SUM(amount) FILTER (WHERE entry_type = 'CREDIT') AS credited,
+		SUM(amount) FILTER (WHERE entry_type = 'DEBIT') AS debited
+	FROM ledger_entries
+	GROUP BY user_id
+) l ON l.user_id = p.id
+WHERE b.user_id IS NULL
+	OR b.current <> COALESCE(l.credited, 0) - COALESCE(l.debited, 0)
+	OR b.withdrawn <> COALESCE(l.debited, 0)
+ORDER BY p.id
+`
+var RepairBalancePostgres string = `
+INSERT INTO balances (user_id, current, withdrawn) VALUES ($1, $2, $3)
+ON CONFLICT (user_id) DO UPDATE
+SET current = EXCLUDED.current, withdrawn = EXCLUDED.withdrawn, updated_at = now()
+`
+var CheckWithdrawalExistsPostgres string = `
+SELECT EXISTS (
+	SELECT 1 FROM ledger_entries WHERE source = 'WITHDRAWAL' AND order_number = $1
+)
 `
 var AddWithdrawOrderPostgres string = `
-INSERT INTO orders (user_id, order_number, accrual, status)
-VALUES ($1, $2, $3, 'WITHDRAWN')
+INSERT INTO ledger_entries (user_id, entry_type, source, order_number, amount)
+VALUES ($1, 'DEBIT', 'WITHDRAWAL', $2, $3)
 `
 var GetAllWithDrawals string = `
-SELECT order_number, accrual, uploaded_at 
-FROM orders
-WHERE user_id = $1 AND status = 'WITHDRAWN'
-ORDER BY uploaded_at DESC;
+SELECT order_number, amount, created_at
+FROM ledger_entries
+WHERE user_id = $1 AND entry_type = 'DEBIT'
+ORDER BY created_at DESC, id DESC;
+`
+
+// Фильтры списаний: NULL отключает фильтр. Итог считается по тем же фильтрам, но без курсора
+var ListUserWithdrawalsPostgres string = `
+SELECT id, order_number, amount, created_at
+FROM ledger_entries
+WHERE user_id = $1 AND entry_type = 'DEBIT'
+	AND ($2::timestamp IS NULL OR created_at >= $2)
+	AND ($3::timestamp IS NULL OR created_at < $3)
+	AND ($4::numeric IS NULL OR amount >= $4)
+	AND ($5::numeric IS NULL OR amount <= $5)
+	AND ($6::timestamp IS NULL OR (created_at, id) < ($6, $7))
+ORDER BY created_at DESC, id DESC
+LIMIT $8
+`
+var WithdrawalsTotalPostgres string = `
+SELECT COALESCE(SUM(amount), 0), COUNT(*)
+FROM ledger_entries
+WHERE user_id = $1 AND entry_type = 'DEBIT'
+	AND ($2::timestamp IS NULL OR created_at >= $2)
+	AND ($3::timestamp IS NULL OR created_at < $3)
+	AND ($4::numeric IS NULL OR amount >= $4)
+	AND ($5::numeric IS NULL OR amount <= $5)
+`
+var CreateSessionPostgres string = `
+INSERT INTO sessions (id, user_id, user_agent, ip, expires_at)
+VALUES ($1, $2, $3, $4, now() + $5 * interval '1 second')
+`
+var TouchSessionPostgres string = `
+UPDATE sessions
+SET last_seen_at = now(), expires_at = now() + $3 * interval '1 second'
+WHERE id = $1 AND user_id = $2 AND revoked_at IS NULL AND expires_at > now()
+`
+var GetSessionStatePostgres string = `
+SELECT revoked_at IS NOT NULL, expires_at <= now()
+FROM sessions
+WHERE id = $1 AND user_id = $2
+`
+var ListSessionsPostgres string = `
+SELECT id, user_agent, ip, created_at, last_seen_at, expires_at
+FROM sessions
+WHERE user_id = $1 AND revoked_at IS NULL AND expires_at > now()
+ORDER BY last_seen_at DESC;
+`
+var RevokeSessionPostgres string = `
+UPDATE sessions
+SET revoked_at = now()
+WHERE id = $1 AND user_id = $2 AND revoked_at IS NULL
+`
+var QuarantineAccrualResponsePostgres string = `
+INSERT INTO accrual_quarantine (order_number, reason, response)
+VALUES ($1, $2, $3)
+`
+var ListQuarantinedResponsesPostgres string = `
+SELECT id, order_number, reason, response, received_at
+FROM accrual_quarantine
+ORDER BY id DESC
+LIMIT $1
+`
+var ListStuckOrdersPostgres string = `
+SELECT o.order_number, o.user_id, o.uploaded_at, h.changed_at
+FROM orders o
+JOIN order_status_history h ON h.id = (
+	SELECT MAX(id) FROM order_status_history
+	WHERE order_number = o.order_number AND to_status = 'STUCK'
+)
+WHERE o.status = 'STUCK'
+ORDER BY h.id
+LIMIT $1
+`
+
+// Истекшие ключи пользователя удаляются перед закреплением нового, поэтому ключ можно переиспользовать после TTL
+var PurgeIdempotencyKeysPostgres string = "DELETE FROM idempotency_keys WHERE user_id = $1 AND expires_at <= now()"
+var InsertIdempotencyKeyPostgres string = `
+INSERT INTO idempotency_keys (user_id, idem_key, fingerprint, expires_at)
+VALUES ($1, $2, $3, now() + make_interval(secs => $4))
+ON CONFLICT (user_id, idem_key) DO NOTHING
+`
+var GetIdempotencyKeyPostgres string = `
+SELECT fingerprint, COALESCE(status, 0), COALESCE(content_type, ''), COALESCE(body, ''::bytea)
+FROM idempotency_keys
+WHERE user_id = $1 AND idem_key = $2
+`
+var CompleteIdempotencyKeyPostgres string = `
+UPDATE idempotency_keys
+SET status = $3, content_type = $4, body = $5
+WHERE user_id = $1 AND idem_key = $2 AND status IS NULL
 `
+var ReleaseIdempotencyKeyPostgres string = "DELETE FROM idempotency_keys WHERE user_id = $1 AND idem_key = $2 AND status IS NULL"
@@ -0,0 +1,228 @@
+package storage
+
+// Запросы для SQLite. Суммы передаются и читаются целым числом сотых долей (money.Amount.Minor),
+// время хранится текстом с миллисекундами, поэтому "сейчас" везде считаем через sqliteNow
+const sqliteNow = "strftime('%Y-%m-%d %H:%M:%f', 'now')"
+
+var RegistrationSQLite string = "INSERT INTO personal_account (login, password) VALUES (?, ?) RETURNING id"
+var EnsureBalanceSQLite string = "INSERT INTO balances (user_id) VALUES (?) ON CONFLICT (user_id) DO NOTHING"
+var CheckLoginSQLite = "SELECT password FROM personal_account WHERE login = ?"
+var UpdatePasswordHashSQLite string = "UPDATE personal_account SET password = ? WHERE login = ?"
+var CheckUserOrderSQLite = "SELECT user_id FROM orders WHERE order_number = ?"
+var CreateNewOrderSQLite = "INSERT INTO orders (order_number, user_id, status) VALUES (?, ?, 'NEW')"
+var LoginIDSQLite string = "SELECT id FROM personal_account WHERE login = ?"
+var GetUserOrdersSQLite string = `
+	SELECT order_number, status, accrual, uploaded_at
+	FROM orders
+	WHERE user_id = ?
+	ORDER BY uploaded_at DESC, id DESC;
+`
+
+// Параметры пронумерованы, потому что используются по нескольку раз. Статусы передаются JSON-массивом;
+// пустой массив и NULL во времени отключают соответствующий фильтр
+var ListUserOrdersSQLite string = `
+SELECT id, order_number, status, accrual, uploaded_at
+FROM orders
+WHERE user_id = ?1
+	AND (json_array_length(?2) = 0 OR COALESCE(status, 'NEW') IN (SELECT value FROM json_each(?2)))
+	AND (?3 IS NULL OR uploaded_at >= ?3)
+	AND (?4 IS NULL OR uploaded_at < ?4)
+	AND (?5 IS NULL OR (uploaded_at, id) < (?5, ?6))
+ORDER BY uploaded_at DESC, id DESC
+LIMIT ?7
+`
+
+// FOR UPDATE не нужен: транзакции открываются с _txlock=immediate и сразу блокируют базу на запись
+var LockOrderStatusSQLite string = "SELECT user_id, COALESCE(status, 'NEW') FROM orders WHERE order_number = ?"
+var UpdateOrderStatusSQLite string = `
+UPDATE orders
+SET status = ?1, accrual = ?2, attempts = 0, next_check_at = NULL, lease_owner = NULL, lease_expires_at = NULL,
+	queued_at = CASE WHEN ?1 = 'NEW' THEN ` + sqliteNow + ` ELSE queued_at END
+WHERE order_number = ?3
+`
+var AddStatusHistorySQLite string = `
+INSERT INTO order_status_history (order_number, from_status, to_status, source)
+VALUES (?, ?, ?, ?)
+`
+var GetOrderStatusHistorySQLite string = `
+SELECT from_status, to_status, source, changed_at
+FROM order_status_history
+WHERE order_number = ?
+ORDER BY id
+`
+
+// SKIP LOCKED в SQLite нет и не нужен: UPDATE с подзапросом выполняется под блокировкой базы на запись
+var ClaimOrdersForAccrualSQLite string = `
+UPDATE orders
+SET lease_owner = ?1, lease_expires_at = strftime('%Y-%m-%d %H:%M:%f', 'now', ?3 || ' seconds')
+WHERE id IN (
+	SELECT id
+	FROM orders
+	WHERE status IN ('NEW', 'PROCESSING', 'REGISTERED')
+		AND (next_check_at IS NULL OR next_check_at <= ` + sqliteNow + `)
+		AND (lease_expires_at IS NULL OR lease_expires_at <= ` + sqliteNow + `)
+	ORDER BY uploaded_at, id
+	LIMIT ?2
+)
+RETURNING order_number, COALESCE(status, 'NEW'), accrual, uploaded_at, queued_at, attempts
+`
+var ScheduleNextCheckSQLite string = `
+UPDATE orders
+SET attempts = attempts + 1, next_check_at = strftime('%Y-%m-%d %H:%M:%f', 'now', ?2 || ' seconds'),
+	lease_owner = NULL, lease_expires_at = NULL
+WHERE order_number = ?1
+`
+var ReleaseOrderLeaseSQLite string = `
+UPDATE orders
+SET lease_owner = NULL, lease_expires_at = NULL
+WHERE order_number = ? AND lease_owner = ?
+`
+var AddLedgerCreditSQLite string = `
+INSERT INTO ledger_entries (user_id, entry_type, source, order_number, amount)
+VALUES (?, 'CREDIT', 'ACCRUAL', ?, ?)
+ON CONFLICT (source, order_number) DO NOTHING
+`
+var CreditBalanceSQLite string = `
+INSERT INTO balances (user_id, current) VALUES (?, ?)
+ON CONFLICT (user_id) DO UPDATE
+SET current = balances.current + excluded.current, updated_at = ` + sqliteNow
+
+var GetBalanceSQLite string = "SELECT current, withdrawn FROM balances WHERE user_id = ?"
+var DebitBalanceSQLite string = `
+UPDATE balances
+SET current = current - ?1, withdrawn = withdrawn + ?1, updated_at = ` + sqliteNow + `
+WHERE user_id = ?2
+`
+var GetBalanceFromLedgerSQLite string = `
+SELECT
+	COALESCE(SUM(amount) FILTER (WHERE entry_type = 'CREDIT'), 0),
+	COALESCE(SUM(amount) FILTER (WHERE entry_type = 'DEBIT'), 0)
+FROM ledger_entries
+WHERE user_id = ?
+`
+var FindBalanceDriftSQLite string = `
+SELECT p.id,
+	COALESCE(b.current, 0), COALESCE(b.withdrawn, 0),
+	COALESCE(l.credited, 0) - COALESCE(l.debited, 0), COALESCE(l.debited, 0)
+FROM personal_account p
+LEFT JOIN balances b ON b.user_id = p.id
+LEFT JOIN (
+	SELECT user_id,
+		SUM(amount) FILTER (WHERE entry_type = 'CREDIT') AS credited,
+		SUM(amount) FILTER (WHERE entry_type = 'DEBIT') AS debited
+	FROM ledger_entries
+	GROUP BY user_id
+) l ON l.user_id = p.id
+WHERE b.user_id IS NULL
+	OR b.current <> COALESCE(l.credited, 0) - COALESCE(l.debited, 0)
+	OR b.withdrawn <> COALESCE(l.debited, 0)
+ORDER BY p.id
+`
+var RepairBalanceSQLite string = `
+INSERT INTO balances (user_id, current, withdrawn) VALUES (?, ?, ?)
+ON CONFLICT (user_id) DO UPDATE
+SET current = excluded.current, withdrawn = excluded.withdrawn, updated_at = ` + sqliteNow
+
+var CheckWithdrawalExistsSQLite string = `
+SELECT EXISTS (
+	SELECT 1 FROM ledger_entries WHERE source = 'WITHDRAWAL' AND order_number = ?
+)
+`
+var AddWithdrawOrderSQLite string = `
+INSERT INTO ledger_entries (user_id, entry_type, source, order_number, amount)
+VALUES (?, 'DEBIT', 'WITHDRAWAL', ?, ?)
+`
+var GetAllWithDrawalsSQLite string = `
+SELECT order_number, amount, created_at
+FROM ledger_entries
+WHERE user_id = ? AND entry_type = 'DEBIT'
+ORDER BY created_at DESC, id DESC;
+`
+
+// Фильтры списаний: NULL отключает фильтр, суммы - в сотых долях. Итог считается по тем же фильтрам, но без курсора
+var ListUserWithdrawalsSQLite string = `
+SELECT id, order_number, amount, created_at
+FROM ledger_entries
+WHERE user_id = ?1 AND entry_type = 'DEBIT'
+	AND (?2 IS NULL OR created_at >= ?2)
+	AND (?3 IS NULL OR created_at < ?3)
+	AND (?4 IS NULL OR amount >= ?4)
+	AND (?5 IS NULL OR amount <= ?5)
+	AND (?6 IS NULL OR (created_at, id) < (?6, ?7))
+ORDER BY created_at DESC, id DESC
+LIMIT ?8
+`
+var WithdrawalsTotalSQLite string = `
+SELECT COALESCE(SUM(amount), 0), COUNT(*)
+FROM ledger_entries
+WHERE user_id = ?1 AND entry_type = 'DEBIT'
+	AND (?2 IS NULL OR created_at >= ?2)
+	AND (?3 IS NULL OR created_at < ?3)
+	AND (?4 IS NULL OR amount >= ?4)
+	AND (?5 IS NULL OR amount <= ?5)
+`
+var CreateSessionSQLite string = `
+INSERT INTO sessions (id, user_id, user_agent, ip, expires_at)
+VALUES (?, ?, ?, ?, strftime('%Y-%m-%d %H:%M:%f', 'now', ? || ' seconds'))
+`
+var TouchSessionSQLite string = `
+UPDATE sessions
+SET last_seen_at = ` + sqliteNow + `, expires_at = strftime('%Y-%m-%d %H:%M:%f', 'now', ?3 || ' seconds')
+WHERE id = ?1 AND user_id = ?2 AND revoked_at IS NULL AND expires_at > ` + sqliteNow
+
+var GetSessionStateSQLite string = `
+SELECT revoked_at IS NOT NULL, expires_at <= ` + sqliteNow + `
+FROM sessions
+WHERE id = ? AND user_id = ?
+`
+var ListSessionsSQLite string = `
+SELECT id, user_agent, ip, created_at, last_seen_at, expires_at
+FROM sessions
+WHERE user_id = ? AND revoked_at IS NULL AND expires_at > ` + sqliteNow + `
+ORDER BY last_seen_at DESC;
+`
+var RevokeSessionSQLite string = `
+UPDATE sessions
+SET revoked_at = ` + sqliteNow + `
+WHERE id = ? AND user_id = ? AND revoked_at IS NULL
+`
+var QuarantineAccrualResponseSQLite string = `
+INSERT INTO accrual_quarantine (order_number, reason, response)
+VALUES (?, ?, ?)
+`
+var ListQuarantinedResponsesSQLite string = `
+SELECT id, order_number, reason, response, received_at
+FROM accrual_quarantine
+ORDER BY id DESC
+LIMIT ?
+`
+var ListStuckOrdersSQLite string = `
+SELECT o.order_number, o.user_id, o.uploaded_at, h.changed_at
+FROM orders o
+JOIN order_status_history h ON h.id = (
+	SELECT MAX(id) FROM order_status_history
+	WHERE order_number = o.order_number AND to_status = 'STUCK'
+)
+WHERE o.status = 'STUCK'
+ORDER BY h.id
+LIMIT ?
+`
+
+// Истекшие ключи пользователя удаляются перед закреплением нового, поэтому ключ можно переиспользовать после TTL
+var PurgeIdempotencyKeysSQLite string = "DELETE FROM idempotency_keys WHERE user_id = ? AND expires_at <= " + sqliteNow
+var InsertIdempotencyKeySQLite string = `
+INSERT INTO idempotency_keys (user_id, idem_key, fingerprint, expires_at)
+VALUES (?, ?, ?, strftime('%Y-%m-%d %H:%M:%f', 'now', ? || ' seconds'))
+ON CONFLICT (user_id, idem_key) DO NOTHING
+`
+var GetIdempotencyKeySQLite string = `
+SELECT fingerprint, COALESCE(status, 0), COALESCE(content_type, ''), COALESCE(body, X'')
+FROM idempotency_keys
+WHERE user_id = ? AND idem_key = ?
+`
+var CompleteIdempotencyKeySQLite string = `
+UPDATE idempotency_keys
+SET status = ?3, content_type = ?4, body = ?5
+WHERE user_id = ?1 AND idem_key = ?2 AND status IS NULL
+`
+var ReleaseIdempotencyKeySQLite string = "DELETE FROM idempotency_keys WHERE user_id = ? AND idem_key = ? AND status IS NULL"
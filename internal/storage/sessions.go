@@ -0,0 +1,86 @@
+package storage
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"time"
+
+	"github.com/NailUsmanov/gophermart/internal/auth"
+	"github.com/NailUsmanov/gophermart/internal/models"
+)
+
+func (d *DataBaseStorage) CreateSession(ctx context.Context, userID int, userAgent, ip string, ttl time.Duration) (string, error) {
+	sessionID, err := auth.NewSessionID()
+	if err != nil {
+		return "", err
+	}
+	_, err = d.db.ExecContext(ctx, CreateSessionPostgres, sessionID, userID, userAgent, ip, ttl.Seconds())
+	if err != nil {
+		return "", fmt.Errorf("failed to create session: %w", err)
+	}
+	return sessionID, nil
+}
+
+func (d *DataBaseStorage) TouchSession(ctx context.Context, sessionID string, userID int, ttl time.Duration) error {
+	// Продлеваем только активную сессию
+	res, err := d.db.ExecContext(ctx, TouchSessionPostgres, sessionID, userID, ttl.Seconds())
+	if err != nil {
+		return fmt.Errorf("failed to touch session: %w", err)
+	}
+	affected, err := res.RowsAffected()
+	if err != nil {
+		return fmt.Errorf("failed to touch session: %w", err)
+	}
+	if affected > 0 {
+		return nil
+	}
+	// Ничего не обновили - выясняем причину, чтобы вернуть понятную ошибку
+	var revoked, expired bool
+	err = d.db.QueryRowContext(ctx, GetSessionStatePostgres, sessionID, userID).Scan(&revoked, &expired)
+	if err == sql.ErrNoRows {
+		return auth.ErrSessionNotFound
+	}
+	if err != nil {
+		return fmt.Errorf("failed to get session state: %w", err)
+	}
+	if revoked {
+		return auth.ErrSessionRevoked
+	}
+	return auth.ErrSessionExpired
+}
+
+func (d *DataBaseStorage) ListSessions(ctx context.Context, userID int) ([]models.Session, error) {
+	sessions := make([]models.Session, 0)
+	rows, err := d.db.QueryContext(ctx, ListSessionsPostgres, userID)
+	if err != nil {
+		return nil, fmt.Errorf("db query: %v", err)
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var s models.Session
+		if err := rows.Scan(&s.ID, &s.UserAgent, &s.IP, &s.CreatedAt, &s.LastSeenAt, &s.ExpiresAt); err != nil {
+			return nil, fmt.Errorf("scan row: %v", err)
+		}
+		sessions = append(sessions, s)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("rows iteration error: %w", err)
+	}
+	return sessions, nil
+}
+
+func (d *DataBaseStorage) RevokeSession(ctx context.Context, userID int, sessionID string) error {
+	res, err := d.db.ExecContext(ctx, RevokeSessionPostgres, sessionID, userID)
+	if err != nil {
+		return fmt.Errorf("failed to revoke session: %w", err)
+	}
+	affected, err := res.RowsAffected()
+	if err != nil {
+		return fmt.Errorf("failed to revoke session: %w", err)
+	}
+	if affected == 0 {
+		return auth.ErrSessionNotFound
+	}
+	return nil
+}
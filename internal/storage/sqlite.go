@@ -0,0 +1,712 @@
+package storage
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/NailUsmanov/gophermart/internal/auth"
+	"github.com/NailUsmanov/gophermart/internal/models"
+	"github.com/NailUsmanov/gophermart/internal/money"
+	sqlitemigrations "github.com/NailUsmanov/gophermart/migrations/sqlite"
+	"github.com/golang-migrate/migrate/v4"
+	"github.com/golang-migrate/migrate/v4/database/sqlite"
+	"github.com/golang-migrate/migrate/v4/source/iofs"
+	"go.uber.org/zap"
+	_ "modernc.org/sqlite"
+)
+
+// SQLiteScheme - префикс DATABASE_URI, по которому выбирается SQLite:
+// sqlite://gophermart.db, sqlite:///var/lib/gophermart.db, sqlite://:memory:
+const SQLiteScheme = "sqlite://"
+
+// SQLiteStorage - реализация Storage поверх SQLite для одноузловых установок и CI без PostgreSQL
+type SQLiteStorage struct {
+	db        *sql.DB
+	newOrders orderNotifier
+}
+
+// New открывает хранилище по DATABASE_URI: sqlite://... - SQLite, иначе PostgreSQL
+func New(dsn string) (Storage, error) {
+	if path, ok := strings.CutPrefix(dsn, SQLiteScheme); ok {
+		s, err := NewSQLiteStorage(path)
+		if err != nil {
+			return nil, err
+		}
+		return s, nil
+	}
+	s, err := NewDataBaseStorage(dsn)
+	if err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+// NewSQLiteStorage открывает файл базы (или :memory:) и применяет миграции из migrations/sqlite
+func NewSQLiteStorage(path string) (*SQLiteStorage, error) {
+	if path == "" {
+		return nil, errors.New("empty SQLite database path")
+	}
+	sep := "?"
+	if strings.Contains(path, "?") {
+		sep = "&"
+	}
+	// _txlock=immediate берет блокировку на запись в начале транзакции, а не при первом UPDATE
+	dsn := path + sep + "_pragma=foreign_keys(1)&_pragma=busy_timeout(5000)&_txlock=immediate"
+	db, err := sql.Open("sqlite", dsn)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open SQLite: %v", err)
+	}
+	// SQLite допускает одного писателя; одно соединение выстраивает транзакции в очередь
+	// и сохраняет базу :memory:, которая живет, пока открыто соединение
+	db.SetMaxOpenConns(1)
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	if err := db.PingContext(ctx); err != nil {
+		return nil, fmt.Errorf("failed to ping SQLite: %v", err)
+	}
+
+	driver, err := sqlite.WithInstance(db, &sqlite.Config{})
+	if err != nil {
+		return nil, fmt.Errorf("failed to create migrate driver: %w", err)
+	}
+	source, err := iofs.New(sqlitemigrations.FS, ".")
+	if err != nil {
+		return nil, fmt.Errorf("failed to open migrations: %w", err)
+	}
+	m, err := migrate.NewWithInstance(
+		"iofs", source,
+		"sqlite", driver)
+	if err != nil {
+		return nil, fmt.Errorf("failed to initialise migrate driver: %w", err)
+	}
+	if err := m.Up(); err != nil && err != migrate.ErrNoChange {
+		return nil, fmt.Errorf("failed to apply migrations: %w", err)
+	}
+	return &SQLiteStorage{db: db}, nil
+}
+
+// Close закрывает базу, дождавшись уже начатых запросов
+func (s *SQLiteStorage) Close() error {
+	return s.db.Close()
+}
+
+// isUniqueViolation распознает нарушение UNIQUE/PRIMARY KEY в SQLite
+func isUniqueViolation(err error) bool {
+	return strings.Contains(err.Error(), "UNIQUE constraint failed")
+}
+
+func (s *SQLiteStorage) Registration(ctx context.Context, login, password string) (err error) {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer func() {
+		if err != nil {
+			_ = tx.Rollback()
+		}
+	}()
+	var userID int
+	if err = tx.QueryRowContext(ctx, RegistrationSQLite, login, password).Scan(&userID); err != nil {
+		if isUniqueViolation(err) {
+			return ErrOrderAlreadyUsed
+		}
+		return fmt.Errorf("failed to save new user: %v", err)
+	}
+	if _, err = tx.ExecContext(ctx, EnsureBalanceSQLite, userID); err != nil {
+		return fmt.Errorf("failed to create balance: %w", err)
+	}
+	if err = tx.Commit(); err != nil {
+		return fmt.Errorf("failed to commit registration: %w", err)
+	}
+	return nil
+}
+
+func (s *SQLiteStorage) GetUserByLogin(ctx context.Context, login string) (string, error) {
+	var hashedPassword string
+	err := s.db.QueryRowContext(ctx, CheckLoginSQLite, login).Scan(&hashedPassword)
+	if err == sql.ErrNoRows {
+		return "", nil
+	}
+	if err != nil {
+		return "", fmt.Errorf("failed to get user: %v", err)
+	}
+	return hashedPassword, nil
+}
+
+func (s *SQLiteStorage) GetUserIDByLogin(ctx context.Context, login string) (int, error) {
+	var userID int
+	err := s.db.QueryRowContext(ctx, LoginIDSQLite, login).Scan(&userID)
+	if err != nil {
+		return 0, fmt.Errorf("failed to get user ID: %w", err)
+	}
+	return userID, nil
+}
+
+func (s *SQLiteStorage) UpdatePasswordHash(ctx context.Context, login, passwordHash string) error {
+	_, err := s.db.ExecContext(ctx, UpdatePasswordHashSQLite, passwordHash, login)
+	if err != nil {
+		return fmt.Errorf("failed to update password hash: %w", err)
+	}
+	return nil
+}
+
+func (s *SQLiteStorage) CreateNewOrder(ctx context.Context, userNumber int, numberOrder string, sugar *zap.SugaredLogger) error {
+	_, err := s.db.ExecContext(ctx, CreateNewOrderSQLite, numberOrder, userNumber)
+	if err != nil {
+		if isUniqueViolation(err) {
+			return ErrOrderAlreadyUploaded
+		}
+		return fmt.Errorf("failed to insert new order: %w", err)
+	}
+	s.newOrders.publish(numberOrder)
+	sugar.Infof("Order %s created for user %d", numberOrder, userNumber)
+	return nil
+}
+
+func (s *SQLiteStorage) CheckExistOrder(ctx context.Context, numberOrder string) (bool, int, error) {
+	var existingUserID int
+	err := s.db.QueryRowContext(ctx, CheckUserOrderSQLite, numberOrder).Scan(&existingUserID)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return false, -1, nil
+		}
+		return false, -1, fmt.Errorf("failed to check order existence: %w", err)
+	}
+	return true, existingUserID, nil
+}
+
+func (s *SQLiteStorage) GetOrdersByUserID(ctx context.Context, userID int) ([]Order, error) {
+	orders := make([]Order, 0)
+	rows, err := s.db.QueryContext(ctx, GetUserOrdersSQLite, userID)
+	if err != nil {
+		return nil, fmt.Errorf("db query: %v", err)
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var (
+			order   Order
+			accrual sql.NullInt64
+		)
+		if err := rows.Scan(&order.Number, &order.Status, &accrual, &order.UploadedAt); err != nil {
+			return nil, fmt.Errorf("scan row: %v", err)
+		}
+		order.Accrual = nullAmount(accrual)
+		orders = append(orders, order)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("rows iteration error: %w", err)
+	}
+	return orders, nil
+}
+
+func (s *SQLiteStorage) ListUserOrders(ctx context.Context, userID int, f OrderFilter) (OrderPage, error) {
+	statuses := []byte("[]")
+	if len(f.Statuses) > 0 {
+		// Срез строк всегда сериализуется
+		statuses, _ = json.Marshal(f.Statuses)
+	}
+	var after OrderCursor
+	if f.After != nil {
+		after = *f.After
+	}
+	rows, err := s.db.QueryContext(ctx, ListUserOrdersSQLite,
+		userID, string(statuses), sqliteTime(f.From), sqliteTime(f.To), sqliteTime(after.UploadedAt), after.ID, f.Limit+1)
+	if err != nil {
+		return OrderPage{}, fmt.Errorf("db query: %v", err)
+	}
+	defer rows.Close()
+	orders := make([]Order, 0)
+	ids := make([]int64, 0)
+	for rows.Next() {
+		var (
+			order   Order
+			id      int64
+			accrual sql.NullInt64
+		)
+		if err := rows.Scan(&id, &order.Number, &order.Status, &accrual, &order.UploadedAt); err != nil {
+			return OrderPage{}, fmt.Errorf("scan row: %v", err)
+		}
+		order.Accrual = nullAmount(accrual)
+		orders = append(orders, order)
+		ids = append(ids, id)
+	}
+	if err := rows.Err(); err != nil {
+		return OrderPage{}, fmt.Errorf("rows iteration error: %w", err)
+	}
+	return orderPage(orders, ids, f.Limit), nil
+}
+
+func (s *SQLiteStorage) ClaimOrdersForAccrual(ctx context.Context, owner string, limit int, lease time.Duration) ([]Order, error) {
+	orders := make([]Order, 0)
+	rows, err := s.db.QueryContext(ctx, ClaimOrdersForAccrualSQLite, owner, limit, lease.Seconds())
+	if err != nil {
+		return nil, fmt.Errorf("db query: %v", err)
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var (
+			order    Order
+			accrual  sql.NullInt64
+			queuedAt sql.NullTime
+		)
+		if err := rows.Scan(&order.Number, &order.Status, &accrual, &order.UploadedAt, &queuedAt, &order.Attempts); err != nil {
+			return nil, fmt.Errorf("scan row: %v", err)
+		}
+		order.Accrual = nullAmount(accrual)
+		order.QueuedAt = queuedSince(order.UploadedAt, queuedAt)
+		orders = append(orders, order)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("row iteration: %v", err)
+	}
+	// RETURNING не гарантирует порядок строк
+	sort.SliceStable(orders, func(i, j int) bool {
+		return orders[i].UploadedAt.Before(orders[j].UploadedAt)
+	})
+	return orders, nil
+}
+
+// UpdateOrderStatus обновляет заказ и, если он перешел в PROCESSED, в той же транзакции
+// проводит начисление в ledger_entries и увеличивает balances. Повторное начисление за тот же заказ не проводится
+func (s *SQLiteStorage) UpdateOrderStatus(ctx context.Context, t StatusTransition, accrual *money.Amount) (err error) {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer func() {
+		if err != nil {
+			_ = tx.Rollback()
+		}
+	}()
+
+	var (
+		userID int
+		status string
+	)
+	err = tx.QueryRowContext(ctx, LockOrderStatusSQLite, t.Number).Scan(&userID, &status)
+	if err == sql.ErrNoRows {
+		return fmt.Errorf("order %s: %w", t.Number, ErrOrderNotFound)
+	}
+	if err != nil {
+		return fmt.Errorf("lock order: %v", err)
+	}
+	if status != t.From {
+		return fmt.Errorf("order %s is %s, not %s: %w", t.Number, status, t.From, ErrStatusConflict)
+	}
+	var accrualMinor sql.NullInt64
+	if accrual != nil {
+		accrualMinor = sql.NullInt64{Int64: accrual.Minor(), Valid: true}
+	}
+	if _, err = tx.ExecContext(ctx, UpdateOrderStatusSQLite, t.To, accrualMinor, t.Number); err != nil {
+		return fmt.Errorf("exec row: %v", err)
+	}
+	if t.From != t.To {
+		if _, err = tx.ExecContext(ctx, AddStatusHistorySQLite, t.Number, t.From, t.To, t.Source); err != nil {
+			return fmt.Errorf("failed to add status history: %w", err)
+		}
+	}
+	if t.To == "PROCESSED" && accrual != nil && *accrual > 0 {
+		var res sql.Result
+		res, err = tx.ExecContext(ctx, AddLedgerCreditSQLite, userID, t.Number, accrual.Minor())
+		if err != nil {
+			return fmt.Errorf("failed to add ledger credit: %w", err)
+		}
+		var inserted int64
+		if inserted, err = res.RowsAffected(); err != nil {
+			return fmt.Errorf("failed to add ledger credit: %w", err)
+		}
+		if inserted > 0 {
+			if _, err = tx.ExecContext(ctx, CreditBalanceSQLite, userID, accrual.Minor()); err != nil {
+				return fmt.Errorf("failed to credit balance: %w", err)
+			}
+		}
+	}
+	if err = tx.Commit(); err != nil {
+		return fmt.Errorf("failed to commit order status: %w", err)
+	}
+	return nil
+}
+
+func (s *SQLiteStorage) GetOrderStatusHistory(ctx context.Context, number string) ([]StatusTransition, error) {
+	history := make([]StatusTransition, 0)
+	rows, err := s.db.QueryContext(ctx, GetOrderStatusHistorySQLite, number)
+	if err != nil {
+		return nil, fmt.Errorf("db query: %v", err)
+	}
+	defer rows.Close()
+	for rows.Next() {
+		t := StatusTransition{Number: number}
+		if err := rows.Scan(&t.From, &t.To, &t.Source, &t.ChangedAt); err != nil {
+			return nil, fmt.Errorf("scan row: %v", err)
+		}
+		history = append(history, t)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("row iteration: %v", err)
+	}
+	return history, nil
+}
+
+func (s *SQLiteStorage) ScheduleNextCheck(ctx context.Context, number string, delay time.Duration) error {
+	_, err := s.db.ExecContext(ctx, ScheduleNextCheckSQLite, number, delay.Seconds())
+	if err != nil {
+		return fmt.Errorf("failed to schedule next check: %w", err)
+	}
+	return nil
+}
+
+func (s *SQLiteStorage) ReleaseOrderLease(ctx context.Context, owner, number string) error {
+	if _, err := s.db.ExecContext(ctx, ReleaseOrderLeaseSQLite, number, owner); err != nil {
+		return fmt.Errorf("failed to release order lease: %w", err)
+	}
+	return nil
+}
+
+func (s *SQLiteStorage) GetUserBalance(ctx context.Context, userID int) (current, withdrawn money.Amount, err error) {
+	var cur, wd int64
+	err = s.db.QueryRowContext(ctx, GetBalanceSQLite, userID).Scan(&cur, &wd)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return 0, 0, nil
+		}
+		return 0, 0, fmt.Errorf("failed scan query row: %v", err)
+	}
+	return money.FromMinor(cur), money.FromMinor(wd), nil
+}
+
+func (s *SQLiteStorage) GetUserWithDrawns(ctx context.Context, userID int) (money.Amount, error) {
+	_, withdrawn, err := s.GetUserBalance(ctx, userID)
+	return withdrawn, err
+}
+
+// AddWithdrawOrder списывает баллы в одной транзакции. Транзакция открывается с блокировкой на запись
+// (_txlock=immediate), поэтому проверка баланса и списание не пересекаются с другими писателями
+func (s *SQLiteStorage) AddWithdrawOrder(ctx context.Context, userID int, orderNumber string, sum money.Amount) (err error) {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer func() {
+		if err != nil {
+			_ = tx.Rollback()
+		}
+	}()
+
+	if _, err = tx.ExecContext(ctx, EnsureBalanceSQLite, userID); err != nil {
+		return fmt.Errorf("failed to create balance: %w", err)
+	}
+	var current, withdrawn int64
+	if err = tx.QueryRowContext(ctx, GetBalanceSQLite, userID).Scan(&current, &withdrawn); err != nil {
+		return fmt.Errorf("failed to read balance: %w", err)
+	}
+
+	// Один номер заказа можно оплатить баллами только один раз
+	var used bool
+	if err = tx.QueryRowContext(ctx, CheckWithdrawalExistsSQLite, orderNumber).Scan(&used); err != nil {
+		return fmt.Errorf("failed to check withdrawal existence: %w", err)
+	}
+	if used {
+		return ErrOrderAlreadyUsed
+	}
+
+	if sum > money.FromMinor(current) {
+		return ErrNotEnoughFunds
+	}
+	if _, err = tx.ExecContext(ctx, AddWithdrawOrderSQLite, userID, orderNumber, sum.Minor()); err != nil {
+		if isUniqueViolation(err) {
+			return ErrOrderAlreadyUsed
+		}
+		return fmt.Errorf("failed to add ledger debit: %v", err)
+	}
+	if _, err = tx.ExecContext(ctx, DebitBalanceSQLite, sum.Minor(), userID); err != nil {
+		return fmt.Errorf("failed to debit balance: %w", err)
+	}
+	if err = tx.Commit(); err != nil {
+		return fmt.Errorf("failed to commit withdrawal: %w", err)
+	}
+	return nil
+}
+
+func (s *SQLiteStorage) GetAllUserWithdrawals(ctx context.Context, userID int) ([]models.UserWithDraw, error) {
+	allWithDrawls := make([]models.UserWithDraw, 0)
+	rows, err := s.db.QueryContext(ctx, GetAllWithDrawalsSQLite, userID)
+	if err != nil {
+		return nil, fmt.Errorf("db query: %v", err)
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var (
+			order models.UserWithDraw
+			sum   int64
+		)
+		if err := rows.Scan(&order.NumberOrder, &sum, &order.ProcessedAt); err != nil {
+			return nil, fmt.Errorf("scan row: %v", err)
+		}
+		order.Sum = money.FromMinor(sum)
+		allWithDrawls = append(allWithDrawls, order)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("rows iteration error: %w", err)
+	}
+	return allWithDrawls, nil
+}
+
+func (s *SQLiteStorage) ListUserWithdrawals(ctx context.Context, userID int, f WithdrawalFilter) (WithdrawalPage, error) {
+	var (
+		page  WithdrawalPage
+		total int64
+	)
+	from, to := sqliteTime(f.From), sqliteTime(f.To)
+	minSum, maxSum := minorArg(f.MinSum), minorArg(f.MaxSum)
+	if err := s.db.QueryRowContext(ctx, WithdrawalsTotalSQLite, userID, from, to, minSum, maxSum).
+		Scan(&total, &page.Count); err != nil {
+		return WithdrawalPage{}, fmt.Errorf("db query: %v", err)
+	}
+	page.Total = money.FromMinor(total)
+
+	var after WithdrawalCursor
+	if f.After != nil {
+		after = *f.After
+	}
+	rows, err := s.db.QueryContext(ctx, ListUserWithdrawalsSQLite,
+		userID, from, to, minSum, maxSum, sqliteTime(after.ProcessedAt), after.ID, f.Limit+1)
+	if err != nil {
+		return WithdrawalPage{}, fmt.Errorf("db query: %v", err)
+	}
+	defer rows.Close()
+	withdrawals := make([]models.UserWithDraw, 0)
+	ids := make([]int64, 0)
+	for rows.Next() {
+		var (
+			w   models.UserWithDraw
+			id  int64
+			sum int64
+		)
+		if err := rows.Scan(&id, &w.NumberOrder, &sum, &w.ProcessedAt); err != nil {
+			return WithdrawalPage{}, fmt.Errorf("scan row: %v", err)
+		}
+		w.Sum = money.FromMinor(sum)
+		withdrawals = append(withdrawals, w)
+		ids = append(ids, id)
+	}
+	if err := rows.Err(); err != nil {
+		return WithdrawalPage{}, fmt.Errorf("rows iteration error: %w", err)
+	}
+	page.Withdrawals, page.Next = withdrawalPage(withdrawals, ids, f.Limit)
+	return page, nil
+}
+
+// minorArg - сумма в сотых долях или NULL
+func minorArg(a *money.Amount) sql.NullInt64 {
+	if a == nil {
+		return sql.NullInt64{}
+	}
+	return sql.NullInt64{Int64: a.Minor(), Valid: true}
+}
+
+func (s *SQLiteStorage) QuarantineAccrualResponse(ctx context.Context, q models.QuarantinedResponse) error {
+	_, err := s.db.ExecContext(ctx, QuarantineAccrualResponseSQLite, q.OrderNumber, q.Reason, q.Response)
+	if err != nil {
+		return fmt.Errorf("failed to quarantine accrual response: %w", err)
+	}
+	return nil
+}
+
+func (s *SQLiteStorage) ListQuarantinedResponses(ctx context.Context, limit int) ([]models.QuarantinedResponse, error) {
+	responses := make([]models.QuarantinedResponse, 0)
+	rows, err := s.db.QueryContext(ctx, ListQuarantinedResponsesSQLite, limit)
+	if err != nil {
+		return nil, fmt.Errorf("db query: %v", err)
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var q models.QuarantinedResponse
+		if err := rows.Scan(&q.ID, &q.OrderNumber, &q.Reason, &q.Response, &q.ReceivedAt); err != nil {
+			return nil, fmt.Errorf("scan row: %v", err)
+		}
+		responses = append(responses, q)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("row iteration: %v", err)
+	}
+	return responses, nil
+}
+
+func (s *SQLiteStorage) ListStuckOrders(ctx context.Context, limit int) ([]models.StuckOrder, error) {
+	orders := make([]models.StuckOrder, 0)
+	rows, err := s.db.QueryContext(ctx, ListStuckOrdersSQLite, limit)
+	if err != nil {
+		return nil, fmt.Errorf("db query: %v", err)
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var o models.StuckOrder
+		if err := rows.Scan(&o.Number, &o.UserID, &o.UploadedAt, &o.StuckAt); err != nil {
+			return nil, fmt.Errorf("scan row: %v", err)
+		}
+		orders = append(orders, o)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("row iteration: %v", err)
+	}
+	return orders, nil
+}
+
+// ReconcileBalances сверяет balances с историей в ledger_entries и возвращает расхождения.
+// При repair = true баланс каждого расходящегося пользователя пересчитывается в транзакции и перезаписывается
+func (s *SQLiteStorage) ReconcileBalances(ctx context.Context, repair bool) ([]models.BalanceDrift, error) {
+	drifts := make([]models.BalanceDrift, 0)
+	rows, err := s.db.QueryContext(ctx, FindBalanceDriftSQLite)
+	if err != nil {
+		return nil, fmt.Errorf("db query: %v", err)
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var (
+			drift                                        models.BalanceDrift
+			current, withdrawn, expCurrent, expWithdrawn int64
+		)
+		if err := rows.Scan(&drift.UserID, &current, &withdrawn, &expCurrent, &expWithdrawn); err != nil {
+			return nil, fmt.Errorf("scan row: %v", err)
+		}
+		drift.Current, drift.Withdrawn = money.FromMinor(current), money.FromMinor(withdrawn)
+		drift.ExpectedCurrent, drift.ExpectedWithdrawn = money.FromMinor(expCurrent), money.FromMinor(expWithdrawn)
+		drifts = append(drifts, drift)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("rows iteration error: %w", err)
+	}
+	// Закрываем курсор до транзакций: соединение у SQLite одно
+	rows.Close()
+	if !repair {
+		return drifts, nil
+	}
+	for _, drift := range drifts {
+		if err := s.repairBalance(ctx, drift.UserID); err != nil {
+			return drifts, err
+		}
+	}
+	return drifts, nil
+}
+
+func (s *SQLiteStorage) repairBalance(ctx context.Context, userID int) (err error) {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer func() {
+		if err != nil {
+			_ = tx.Rollback()
+		}
+	}()
+	var credited, debited int64
+	if err = tx.QueryRowContext(ctx, GetBalanceFromLedgerSQLite, userID).Scan(&credited, &debited); err != nil {
+		return fmt.Errorf("failed scan query row: %v", err)
+	}
+	if _, err = tx.ExecContext(ctx, RepairBalanceSQLite, userID, credited-debited, debited); err != nil {
+		return fmt.Errorf("failed to repair balance: %w", err)
+	}
+	if err = tx.Commit(); err != nil {
+		return fmt.Errorf("failed to commit balance repair: %w", err)
+	}
+	return nil
+}
+
+func (s *SQLiteStorage) CreateSession(ctx context.Context, userID int, userAgent, ip string, ttl time.Duration) (string, error) {
+	sessionID, err := auth.NewSessionID()
+	if err != nil {
+		return "", err
+	}
+	_, err = s.db.ExecContext(ctx, CreateSessionSQLite, sessionID, userID, userAgent, ip, int64(ttl.Seconds()))
+	if err != nil {
+		return "", fmt.Errorf("failed to create session: %w", err)
+	}
+	return sessionID, nil
+}
+
+func (s *SQLiteStorage) TouchSession(ctx context.Context, sessionID string, userID int, ttl time.Duration) error {
+	// Продлеваем только активную сессию
+	res, err := s.db.ExecContext(ctx, TouchSessionSQLite, sessionID, userID, int64(ttl.Seconds()))
+	if err != nil {
+		return fmt.Errorf("failed to touch session: %w", err)
+	}
+	affected, err := res.RowsAffected()
+	if err != nil {
+		return fmt.Errorf("failed to touch session: %w", err)
+	}
+	if affected > 0 {
+		return nil
+	}
+	var revoked, expired bool
+	err = s.db.QueryRowContext(ctx, GetSessionStateSQLite, sessionID, userID).Scan(&revoked, &expired)
+	if err == sql.ErrNoRows {
+		return auth.ErrSessionNotFound
+	}
+	if err != nil {
+		return fmt.Errorf("failed to get session state: %w", err)
+	}
+	if revoked {
+		return auth.ErrSessionRevoked
+	}
+	return auth.ErrSessionExpired
+}
+
+func (s *SQLiteStorage) ListSessions(ctx context.Context, userID int) ([]models.Session, error) {
+	sessions := make([]models.Session, 0)
+	rows, err := s.db.QueryContext(ctx, ListSessionsSQLite, userID)
+	if err != nil {
+		return nil, fmt.Errorf("db query: %v", err)
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var session models.Session
+		if err := rows.Scan(&session.ID, &session.UserAgent, &session.IP, &session.CreatedAt, &session.LastSeenAt, &session.ExpiresAt); err != nil {
+			return nil, fmt.Errorf("scan row: %v", err)
+		}
+		sessions = append(sessions, session)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("rows iteration error: %w", err)
+	}
+	return sessions, nil
+}
+
+func (s *SQLiteStorage) RevokeSession(ctx context.Context, userID int, sessionID string) error {
+	res, err := s.db.ExecContext(ctx, RevokeSessionSQLite, sessionID, userID)
+	if err != nil {
+		return fmt.Errorf("failed to revoke session: %w", err)
+	}
+	affected, err := res.RowsAffected()
+	if err != nil {
+		return fmt.Errorf("failed to revoke session: %w", err)
+	}
+	if affected == 0 {
+		return auth.ErrSessionNotFound
+	}
+	return nil
+}
+
+// sqliteTime приводит время к формату, в котором SQLite хранит метки времени (см. sqliteNow), чтобы их
+// можно было сравнивать как строки; нулевое время - NULL
+func sqliteTime(t time.Time) sql.NullString {
+	if t.IsZero() {
+		return sql.NullString{}
+	}
+	return sql.NullString{String: t.UTC().Format("2006-01-02 15:04:05.000"), Valid: true}
+}
+
+// nullAmount переводит nullable-сумму в сотых долях в *money.Amount
+func nullAmount(v sql.NullInt64) *money.Amount {
+	if !v.Valid {
+		return nil
+	}
+	a := money.FromMinor(v.Int64)
+	return &a
+}
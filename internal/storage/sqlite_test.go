@@ -0,0 +1,111 @@
+package storage_test
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/NailUsmanov/gophermart/internal/auth"
+	"github.com/NailUsmanov/gophermart/internal/money"
+	"github.com/NailUsmanov/gophermart/internal/storage"
+	"github.com/NailUsmanov/gophermart/internal/storage/storagetest"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+)
+
+// newTestSQLiteStorage открывает SQLite во временном файле теста
+func newTestSQLiteStorage(t *testing.T) storage.Storage {
+	t.Helper()
+	s, err := storage.New(storage.SQLiteScheme + filepath.Join(t.TempDir(), "gophermart.db"))
+	require.NoError(t, err)
+	return s
+}
+
+func TestSQLiteStorageConformance(t *testing.T) {
+	storagetest.Run(t, newTestSQLiteStorage)
+}
+
+func TestSQLiteWithdrawConcurrent(t *testing.T) {
+	s := newTestSQLiteStorage(t)
+	ctx := context.Background()
+
+	require.NoError(t, s.Registration(ctx, "user", "hash"))
+	userID, err := s.GetUserIDByLogin(ctx, "user")
+	require.NoError(t, err)
+	require.NoError(t, s.CreateNewOrder(ctx, userID, "12345678903", zap.NewNop().Sugar()))
+	accrual := money.Amount(10000)
+	require.NoError(t, s.UpdateOrderStatus(ctx, storage.StatusTransition{Number: "12345678903", From: "NEW", To: "PROCESSED", Source: "test"}, &accrual))
+
+	const attempts = 150
+	var (
+		wg        sync.WaitGroup
+		mu        sync.Mutex
+		succeeded int
+	)
+	for i := 0; i < attempts; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			err := s.AddWithdrawOrder(ctx, userID, fmt.Sprintf("w%d", i), money.Amount(100))
+			if err != nil && !errors.Is(err, storage.ErrNotEnoughFunds) {
+				t.Errorf("unexpected error: %v", err)
+				return
+			}
+			if err == nil {
+				mu.Lock()
+				succeeded++
+				mu.Unlock()
+			}
+		}(i)
+	}
+	wg.Wait()
+
+	current, withdrawn, err := s.GetUserBalance(ctx, userID)
+	require.NoError(t, err)
+	assert.Equal(t, 100, succeeded)
+	assert.Equal(t, money.Amount(0), current)
+	assert.Equal(t, money.Amount(10000), withdrawn)
+}
+
+func TestSQLiteInMemory(t *testing.T) {
+	s, err := storage.New("sqlite://:memory:")
+	require.NoError(t, err)
+	ctx := context.Background()
+	require.NoError(t, s.Registration(ctx, "user", "hash"))
+	hash, err := s.GetUserByLogin(ctx, "user")
+	require.NoError(t, err)
+	assert.Equal(t, "hash", hash)
+}
+
+func TestSQLiteSessions(t *testing.T) {
+	s := newTestSQLiteStorage(t)
+	ctx := context.Background()
+	require.NoError(t, s.Registration(ctx, "user", "hash"))
+	userID, err := s.GetUserIDByLogin(ctx, "user")
+	require.NoError(t, err)
+
+	sessionID, err := s.CreateSession(ctx, userID, "curl", "127.0.0.1", time.Hour)
+	require.NoError(t, err)
+	require.NoError(t, s.TouchSession(ctx, sessionID, userID, time.Hour))
+
+	sessions, err := s.ListSessions(ctx, userID)
+	require.NoError(t, err)
+	require.Len(t, sessions, 1)
+	assert.Equal(t, "curl", sessions[0].UserAgent)
+	assert.True(t, sessions[0].ExpiresAt.After(sessions[0].LastSeenAt))
+
+	require.NoError(t, s.RevokeSession(ctx, userID, sessionID))
+	assert.ErrorIs(t, s.TouchSession(ctx, sessionID, userID, time.Hour), auth.ErrSessionRevoked)
+	assert.ErrorIs(t, s.RevokeSession(ctx, userID, sessionID), auth.ErrSessionNotFound)
+
+	// Сессия с нулевым сроком сразу истекает
+	expiredID, err := s.CreateSession(ctx, userID, "curl", "127.0.0.1", 0)
+	require.NoError(t, err)
+	assert.ErrorIs(t, s.TouchSession(ctx, expiredID, userID, time.Hour), auth.ErrSessionExpired)
+	assert.ErrorIs(t, s.TouchSession(ctx, "missing", userID, time.Hour), auth.ErrSessionNotFound)
+}
@@ -2,18 +2,19 @@ package storage
 
 import (
 	"context"
-	"crypto/sha256"
 	"database/sql"
-	"encoding/hex"
 	"fmt"
 	"strings"
 	"time"
 
 	"github.com/NailUsmanov/gophermart/internal/models"
+	"github.com/NailUsmanov/gophermart/internal/money"
+	"github.com/NailUsmanov/gophermart/migrations"
 	"github.com/golang-migrate/migrate/v4"
 	"github.com/golang-migrate/migrate/v4/database/postgres"
-	_ "github.com/golang-migrate/migrate/v4/source/file"
-	_ "github.com/jackc/pgx/v5/stdlib"
+	"github.com/golang-migrate/migrate/v4/source/iofs"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/stdlib"
 	"go.uber.org/zap"
 )
 
@@ -21,29 +22,105 @@ type DataBaseStorage struct {
 	db *sql.DB
 }
 
+// queryRower - общее у *sql.DB и *sql.Tx, чтобы одни и те же запросы работали внутри транзакции и вне ее
+type queryRower interface {
+	QueryRowContext(ctx context.Context, query string, args ...any) *sql.Row
+}
+
 type Order struct {
-	Number     string    `json:"number"`
-	Status     *string   `json:"status"`
-	Accrual    *float64  `json:"accrual,omitempty"`
-	UploadedAt time.Time `json:"uploaded_at"`
+	Number     string        `json:"number"`
+	Status     *string       `json:"status"`
+	Accrual    *money.Amount `json:"accrual,omitempty"`
+	UploadedAt time.Time     `json:"uploaded_at"`
+	// Attempts - сколько раз подряд accrual не дал ответа; заполняется только для опроса
+	Attempts int `json:"-"`
+	// QueuedAt - с какого момента заказ опрашивается: загрузка или последний возврат в опрос из STUCK.
+	// Заполняется только для опроса
+	QueuedAt time.Time `json:"-"`
+}
+
+// StatusTransition - переход заказа между статусами, строка order_status_history
+type StatusTransition struct {
+	Number string
+	From   string
+	To     string
+	// Source - кто перевел заказ, например accrual
+	Source string
+	// ChangedAt заполняется хранилищем
+	ChangedAt time.Time
+}
+
+// OrderCursor - позиция в списке заказов пользователя, отсортированном от новых к старым
+type OrderCursor struct {
+	UploadedAt time.Time
+	ID         int64
+}
+
+// OrderFilter - страница заказов пользователя. Нулевые поля не фильтруют
+type OrderFilter struct {
+	Limit int
+	// After - последний заказ предыдущей страницы; nil - первая страница
+	After *OrderCursor
+	// Statuses - статусы в хранилище; заказ без статуса считается NEW
+	Statuses []string
+	// From включительно, To не включительно
+	From time.Time
+	To   time.Time
+}
+
+// OrderPage - заказы одной страницы и курсор следующей
+type OrderPage struct {
+	Orders []Order
+	// Next - nil, если страница последняя
+	Next *OrderCursor
 }
 
-func HashPassword(password string) string {
+// WithdrawalCursor - позиция в списке списаний пользователя, отсортированном от новых к старым
+type WithdrawalCursor struct {
+	ProcessedAt time.Time
+	ID          int64
+}
 
-	// создаём новый hash.Hash, вычисляющий контрольную сумму SHA-256
-	h := sha256.New()
-	// передаём байты для хеширования
-	h.Write([]byte(password))
-	// вычисляем хеш
-	return hex.EncodeToString(h.Sum(nil))
+// WithdrawalFilter - страница списаний пользователя. Нулевые поля не фильтруют
+type WithdrawalFilter struct {
+	Limit int
+	// After - последнее списание предыдущей страницы; nil - первая страница
+	After *WithdrawalCursor
+	// From включительно, To не включительно
+	From time.Time
+	To   time.Time
+	// MinSum и MaxSum включительно
+	MinSum *money.Amount
+	MaxSum *money.Amount
+}
 
+// WithdrawalPage - списания одной страницы, курсор следующей и итог по всему фильтру без учета страниц
+type WithdrawalPage struct {
+	Withdrawals []models.UserWithDraw
+	// Next - nil, если страница последняя
+	Next  *WithdrawalCursor
+	Total money.Amount
+	Count int
 }
 
+// NewDataBaseStorage подключается к PostgreSQL и применяет миграции. Сессии всегда работают в UTC,
+// даже если в DSN задан другой timezone
 func NewDataBaseStorage(dsn string) (*DataBaseStorage, error) {
-	db, err := sql.Open("pgx", dsn)
+	cfg, err := pgx.ParseConfig(dsn)
 	if err != nil {
-		return nil, fmt.Errorf("failed to connect to PostgreSQL: %v", err)
+		return nil, fmt.Errorf("failed to parse PostgreSQL DSN: %w", err)
 	}
+	// Колонки времени - TIMESTAMP без зоны: now() пишет в них часы сессии, а фильтры приходят в UTC.
+	// Чтобы сравнение шло по моментам, часы сессии тоже UTC
+	for name := range cfg.RuntimeParams {
+		if strings.EqualFold(name, "timezone") {
+			delete(cfg.RuntimeParams, name)
+		}
+	}
+	cfg.RuntimeParams["timezone"] = "UTC"
+	db := stdlib.OpenDB(*cfg)
+	// Ограничиваем пул: списания держат соединение, пока ждут блокировку пользователя
+	db.SetMaxOpenConns(25)
 	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
 	defer cancel()
 
@@ -57,9 +134,15 @@ func NewDataBaseStorage(dsn string) (*DataBaseStorage, error) {
 		return nil, fmt.Errorf("failed to create migrate driver: %w", err)
 	}
 
+	// Миграции встроены в бинарник
+	source, err := iofs.New(migrations.FS, ".")
+	if err != nil {
+		return nil, fmt.Errorf("failed to open migrations: %w", err)
+	}
+
 	// Инициализация мигратора
-	m, err := migrate.NewWithDatabaseInstance(
-		"file://migrations",
+	m, err := migrate.NewWithInstance(
+		"iofs", source,
 		"postgres", driver)
 	if err != nil {
 		return nil, fmt.Errorf("failed to initialise migrate driver: %w", err)
@@ -71,6 +154,11 @@ func NewDataBaseStorage(dsn string) (*DataBaseStorage, error) {
 	return &DataBaseStorage{db: db}, nil
 }
 
+// Close закрывает пул соединений, дождавшись уже начатых запросов
+func (d *DataBaseStorage) Close() error {
+	return d.db.Close()
+}
+
 func (d *DataBaseStorage) Registration(ctx context.Context, login, password string) error {
 	// Проверим, нет ли пользователя уже в базе
 	_, err := d.db.ExecContext(ctx, RegistrationPostgres, login, password)
@@ -105,35 +193,24 @@ func (d *DataBaseStorage) GetUserIDByLogin(ctx context.Context, login string) (i
 	return userID, nil
 }
 
-func (d *DataBaseStorage) CheckHashMatch(ctx context.Context, login, password string) error {
-	var usersHash string
-	err := d.db.QueryRowContext(ctx, CheckHashPasswordPostgres, login).Scan(&usersHash)
-	if err == sql.ErrNoRows {
-		return fmt.Errorf("don't have userHash: %v", err)
-	}
+// UpdatePasswordHash заменяет хэш пароля пользователя (используется при пересчете хэша после входа)
+func (d *DataBaseStorage) UpdatePasswordHash(ctx context.Context, login, passwordHash string) error {
+	_, err := d.db.ExecContext(ctx, UpdatePasswordHashPostgres, passwordHash, login)
 	if err != nil {
-		return err
-	}
-	if usersHash != HashPassword(password) {
-		return fmt.Errorf("invalid password hash")
+		return fmt.Errorf("failed to update password hash: %w", err)
 	}
 	return nil
 }
 
 func (d *DataBaseStorage) CreateNewOrder(ctx context.Context, userNumber int, numberOrder string, sugar *zap.SugaredLogger) error {
 	sugar.Infof(">>> Creating order: order=%s, userID=%d", numberOrder, userNumber)
-	fmt.Printf(">>> DEBUG: orderNum=%s, userID=%d\n", numberOrder, userNumber)
 	_, err := d.db.ExecContext(ctx, CreateNewOrderPostgres, numberOrder, userNumber)
 	if err != nil {
-		fmt.Printf(">>> DEBUG CreateNewOrder error: %v\n", err)
 		if strings.Contains(err.Error(), "duplicate key") {
-			fmt.Println(">>> DEBUG Detected duplicate key violation")
 			return ErrOrderAlreadyUploaded
 		}
-		fmt.Printf(">>> DEBUG CreateNewOrder error: %v\n", err)
 		return fmt.Errorf("failed to insert new order: %w", err)
 	}
-	fmt.Println(">>> DEBUG CreateNewOrder completed successfully")
 	return nil
 }
 
@@ -178,26 +255,91 @@ func (d *DataBaseStorage) GetOrdersByUserID(ctx context.Context, userID int) ([]
 	return orders, nil
 }
 
-func (d *DataBaseStorage) GetOrdersForAccrualUpdate(ctx context.Context) ([]Order, error) {
+// ListUserOrders выбирает страницу заказов по ключу (uploaded_at, id); лишняя строка в выборке
+// показывает, есть ли следующая страница
+func (d *DataBaseStorage) ListUserOrders(ctx context.Context, userID int, f OrderFilter) (OrderPage, error) {
+	statuses := f.Statuses
+	if statuses == nil {
+		statuses = []string{}
+	}
+	var after OrderCursor
+	if f.After != nil {
+		after = *f.After
+	}
+	rows, err := d.db.QueryContext(ctx, ListUserOrdersPostgres,
+		userID, statuses, nullTime(f.From), nullTime(f.To), nullTime(after.UploadedAt), after.ID, f.Limit+1)
+	if err != nil {
+		return OrderPage{}, fmt.Errorf("db query: %v", err)
+	}
+	defer rows.Close()
+	orders := make([]Order, 0)
+	ids := make([]int64, 0)
+	for rows.Next() {
+		var (
+			order Order
+			id    int64
+		)
+		if err := rows.Scan(&id, &order.Number, &order.Status, &order.Accrual, &order.UploadedAt); err != nil {
+			return OrderPage{}, fmt.Errorf("scan row: %v", err)
+		}
+		orders = append(orders, order)
+		ids = append(ids, id)
+	}
+	if err := rows.Err(); err != nil {
+		return OrderPage{}, fmt.Errorf("rows iteration error: %w", err)
+	}
+	return orderPage(orders, ids, f.Limit), nil
+}
+
+// orderPage отрезает лишнюю строку выборки и ставит курсор на последний заказ страницы
+func orderPage(orders []Order, ids []int64, limit int) OrderPage {
+	if len(orders) <= limit {
+		return OrderPage{Orders: orders}
+	}
+	last := limit - 1
+	return OrderPage{
+		Orders: orders[:limit],
+		Next:   &OrderCursor{UploadedAt: orders[last].UploadedAt, ID: ids[last]},
+	}
+}
+
+// queuedSince - начало опроса заказа: возврат в опрос, а если его не было - загрузка
+func queuedSince(uploadedAt time.Time, queuedAt sql.NullTime) time.Time {
+	if queuedAt.Valid {
+		return queuedAt.Time
+	}
+	return uploadedAt
+}
+
+// nullTime - NULL вместо нулевого времени, чтобы фильтр в запросе не применялся
+func nullTime(t time.Time) sql.NullTime {
+	// pgx пишет в TIMESTAMP показания часов без смещения, поэтому момент переводим в UTC - зону сессии
+	return sql.NullTime{Time: t.UTC(), Valid: !t.IsZero()}
+}
+
+// ClaimOrdersForAccrual арендует заказы для опроса. SKIP LOCKED пропускает строки, которые в этот момент
+// арендует другой экземпляр, поэтому параллельные воркеры получают непересекающиеся пачки
+func (d *DataBaseStorage) ClaimOrdersForAccrual(ctx context.Context, owner string, limit int, lease time.Duration) ([]Order, error) {
 	select {
 	case <-ctx.Done():
 		return nil, ctx.Err()
 	default:
 	}
-	// Создаем массив структур Order
 	orders := make([]Order, 0)
-	// Выполняем запрос в БД orders
-	rows, err := d.db.QueryContext(ctx, GetOrdersForAccrual)
+	rows, err := d.db.QueryContext(ctx, ClaimOrdersForAccrualPostgres, owner, limit, lease.Seconds())
 	if err != nil {
 		return nil, fmt.Errorf("db query: %v", err)
 	}
 	defer rows.Close()
-	// Сканируем полученные значения в массив структур orders
 	for rows.Next() {
-		var order Order
-		if err := rows.Scan(&order.Number, &order.Accrual, &order.UploadedAt); err != nil {
+		var (
+			order    Order
+			queuedAt sql.NullTime
+		)
+		if err := rows.Scan(&order.Number, &order.Status, &order.Accrual, &order.UploadedAt, &queuedAt, &order.Attempts); err != nil {
 			return nil, fmt.Errorf("scan row: %v", err)
 		}
+		order.QueuedAt = queuedSince(order.UploadedAt, queuedAt)
 		orders = append(orders, order)
 	}
 	if err := rows.Err(); err != nil {
@@ -206,82 +348,188 @@ func (d *DataBaseStorage) GetOrdersForAccrualUpdate(ctx context.Context) ([]Orde
 	return orders, nil
 }
 
-func (d *DataBaseStorage) UpdateOrderStatus(ctx context.Context, number string, status string, accrual *float64) error {
+// UpdateOrderStatus обновляет заказ и, если он перешел в PROCESSED, в той же транзакции
+// проводит начисление в ledger_entries и увеличивает balances. Повторное начисление за тот же заказ не проводится
+func (d *DataBaseStorage) UpdateOrderStatus(ctx context.Context, t StatusTransition, accrual *money.Amount) (err error) {
 	select {
 	case <-ctx.Done():
 		return ctx.Err()
 	default:
 	}
-	// Выполняем обновление БД orders
-	_, err := d.db.ExecContext(ctx, UpdateOrderStatusPostgres, status, accrual, number)
+	tx, err := d.db.BeginTx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer func() {
+		if err != nil {
+			_ = tx.Rollback()
+		}
+	}()
+
+	// Блокируем заказ, чтобы статус не поменялся между проверкой и обновлением
+	var (
+		userID int
+		status string
+	)
+	err = tx.QueryRowContext(ctx, LockOrderStatusPostgres, t.Number).Scan(&userID, &status)
+	if err == sql.ErrNoRows {
+		return fmt.Errorf("order %s: %w", t.Number, ErrOrderNotFound)
+	}
 	if err != nil {
+		return fmt.Errorf("lock order: %v", err)
+	}
+	if status != t.From {
+		return fmt.Errorf("order %s is %s, not %s: %w", t.Number, status, t.From, ErrStatusConflict)
+	}
+	if _, err = tx.ExecContext(ctx, UpdateOrderStatusPostgres, t.To, accrual, t.Number); err != nil {
 		return fmt.Errorf("exec row: %v", err)
 	}
+	if t.From != t.To {
+		if _, err = tx.ExecContext(ctx, AddStatusHistoryPostgres, t.Number, t.From, t.To, t.Source); err != nil {
+			return fmt.Errorf("failed to add status history: %w", err)
+		}
+	}
+	if t.To == "PROCESSED" && accrual != nil && *accrual > 0 {
+		var res sql.Result
+		res, err = tx.ExecContext(ctx, AddLedgerCreditPostgres, userID, t.Number, *accrual)
+		if err != nil {
+			return fmt.Errorf("failed to add ledger credit: %w", err)
+		}
+		var inserted int64
+		if inserted, err = res.RowsAffected(); err != nil {
+			return fmt.Errorf("failed to add ledger credit: %w", err)
+		}
+		if inserted > 0 {
+			if _, err = tx.ExecContext(ctx, CreditBalancePostgres, userID, *accrual); err != nil {
+				return fmt.Errorf("failed to credit balance: %w", err)
+			}
+		}
+	}
+	if err = tx.Commit(); err != nil {
+		return fmt.Errorf("failed to commit order status: %w", err)
+	}
 	return nil
 }
 
-func (d *DataBaseStorage) GetUserBalance(ctx context.Context, userID int) (current, withdrawn float64, err error) {
-	select {
-	case <-ctx.Done():
-		return 0, 0, ctx.Err()
-	default:
-	}
-	var result sql.NullFloat64
-	err = d.db.QueryRowContext(ctx, GetBalanceIncome, userID).Scan(&result)
+func (d *DataBaseStorage) GetOrderStatusHistory(ctx context.Context, number string) ([]StatusTransition, error) {
+	history := make([]StatusTransition, 0)
+	rows, err := d.db.QueryContext(ctx, GetOrderStatusHistoryPostgres, number)
 	if err != nil {
-		if err == sql.ErrNoRows {
-			return 0, 0, nil
+		return nil, fmt.Errorf("db query: %v", err)
+	}
+	defer rows.Close()
+	for rows.Next() {
+		t := StatusTransition{Number: number}
+		if err := rows.Scan(&t.From, &t.To, &t.Source, &t.ChangedAt); err != nil {
+			return nil, fmt.Errorf("scan row: %v", err)
 		}
-		return 0, 0, fmt.Errorf("failed scan query row: %v", err)
+		history = append(history, t)
 	}
-	withdrawns, err := d.GetUserWithDrawns(ctx, userID)
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("row iteration: %v", err)
+	}
+	return history, nil
+}
+
+func (d *DataBaseStorage) ScheduleNextCheck(ctx context.Context, number string, delay time.Duration) error {
+	_, err := d.db.ExecContext(ctx, ScheduleNextCheckPostgres, number, delay.Seconds())
 	if err != nil {
-		return 0, 0, err
+		return fmt.Errorf("failed to schedule next check: %w", err)
+	}
+	return nil
+}
+
+func (d *DataBaseStorage) ReleaseOrderLease(ctx context.Context, owner, number string) error {
+	if _, err := d.db.ExecContext(ctx, ReleaseOrderLeasePostgres, number, owner); err != nil {
+		return fmt.Errorf("failed to release order lease: %w", err)
 	}
-	total := result.Float64 - withdrawns
-	return total, withdrawns, nil
+	return nil
 }
 
-func (d *DataBaseStorage) GetUserWithDrawns(ctx context.Context, userID int) (float64, error) {
+// GetUserBalance читает материализованный баланс из balances
+func (d *DataBaseStorage) GetUserBalance(ctx context.Context, userID int) (current, withdrawn money.Amount, err error) {
 	select {
 	case <-ctx.Done():
-		return 0, ctx.Err()
+		return 0, 0, ctx.Err()
 	default:
 	}
-	var result sql.NullFloat64
-	err := d.db.QueryRowContext(ctx, GetBalanceWithDrawn, userID).Scan(&result)
+	err = d.db.QueryRowContext(ctx, GetBalancePostgres, userID).Scan(&current, &withdrawn)
 	if err != nil {
 		if err == sql.ErrNoRows {
-			return 0, nil
+			return 0, 0, nil
 		}
-		return 0, fmt.Errorf("failed scan query row: %v", err)
+		return 0, 0, fmt.Errorf("failed scan query row: %v", err)
+	}
+	return current, withdrawn, nil
+}
+
+func (d *DataBaseStorage) GetUserWithDrawns(ctx context.Context, userID int) (money.Amount, error) {
+	_, withdrawn, err := d.GetUserBalance(ctx, userID)
+	return withdrawn, err
+}
+
+// ledgerBalance пересчитывает баланс пользователя по истории движений в ledger_entries
+func ledgerBalance(ctx context.Context, q queryRower, userID int) (current, withdrawn money.Amount, err error) {
+	var credited, debited money.Amount
+	err = q.QueryRowContext(ctx, GetBalanceFromLedger, userID).Scan(&credited, &debited)
+	if err != nil {
+		return 0, 0, fmt.Errorf("failed scan query row: %v", err)
 	}
-	return result.Float64, nil
+	return credited - debited, debited, nil
 }
 
-func (d *DataBaseStorage) AddWithdrawOrder(ctx context.Context, userID int, orderNumber string, sum float64) error {
+// AddWithdrawOrder списывает баллы в одной транзакции: строка баланса блокируется через FOR UPDATE,
+// поэтому параллельные списания одного пользователя выполняются по очереди и не могут увести баланс в минус
+func (d *DataBaseStorage) AddWithdrawOrder(ctx context.Context, userID int, orderNumber string, sum money.Amount) (err error) {
 	select {
 	case <-ctx.Done():
 		return ctx.Err()
 	default:
 	}
-	ok, _, err := d.CheckExistOrder(ctx, orderNumber)
+	tx, err := d.db.BeginTx(ctx, nil)
 	if err != nil {
-		return fmt.Errorf("method CheckExistOrder failed: %v", err)
+		return fmt.Errorf("failed to begin transaction: %w", err)
 	}
-	if ok {
-		return ErrOrderAlreadyUsed
+	defer func() {
+		if err != nil {
+			_ = tx.Rollback()
+		}
+	}()
+
+	// Блокируем баланс пользователя до конца транзакции
+	if _, err = tx.ExecContext(ctx, EnsureBalancePostgres, userID); err != nil {
+		return fmt.Errorf("failed to create balance: %w", err)
 	}
-	currentBalance, _, err := d.GetUserBalance(ctx, userID)
-	if err != nil {
-		return fmt.Errorf("method GetUserBalance failed: %v", err)
+	var currentBalance money.Amount
+	if err = tx.QueryRowContext(ctx, LockBalancePostgres, userID).Scan(&currentBalance); err != nil {
+		return fmt.Errorf("failed to lock balance: %w", err)
 	}
+
+	// Один номер заказа можно оплатить баллами только один раз
+	var used bool
+	if err = tx.QueryRowContext(ctx, CheckWithdrawalExistsPostgres, orderNumber).Scan(&used); err != nil {
+		return fmt.Errorf("failed to check withdrawal existence: %w", err)
+	}
+	if used {
+		return ErrOrderAlreadyUsed
+	}
+
 	if sum > currentBalance {
 		return ErrNotEnoughFunds
 	}
-	_, err = d.db.ExecContext(ctx, AddWithdrawOrderPostgres, userID, orderNumber, sum)
+	_, err = tx.ExecContext(ctx, AddWithdrawOrderPostgres, userID, orderNumber, sum)
 	if err != nil {
-		return fmt.Errorf("failed to update table orders: %v", err)
+		// Номер могли занять параллельно другим пользователем
+		if strings.Contains(err.Error(), "duplicate key") {
+			return ErrOrderAlreadyUsed
+		}
+		return fmt.Errorf("failed to add ledger debit: %v", err)
+	}
+	if _, err = tx.ExecContext(ctx, DebitBalancePostgres, userID, sum); err != nil {
+		return fmt.Errorf("failed to debit balance: %w", err)
+	}
+	if err = tx.Commit(); err != nil {
+		return fmt.Errorf("failed to commit withdrawal: %w", err)
 	}
 	return nil
 }
@@ -294,7 +542,7 @@ func (d *DataBaseStorage) GetAllUserWithdrawals(ctx context.Context, userID int)
 	}
 	// Создаем массив структур для ответа
 	allWithDrawls := make([]models.UserWithDraw, 0)
-	// Выполняем запрос в БД orders, чтобы достать все позиции по конкретному Юзеру
+	// Достаем все списания конкретного пользователя из ledger_entries
 	rows, err := d.db.QueryContext(ctx, GetAllWithDrawals, userID)
 	if err != nil {
 		return nil, fmt.Errorf("db query: %v", err)
@@ -313,3 +561,121 @@ func (d *DataBaseStorage) GetAllUserWithdrawals(ctx context.Context, userID int)
 	}
 	return allWithDrawls, nil
 }
+
+// ListUserWithdrawals выбирает страницу списаний по ключу (created_at, id) и отдельным запросом - итог по фильтру
+func (d *DataBaseStorage) ListUserWithdrawals(ctx context.Context, userID int, f WithdrawalFilter) (WithdrawalPage, error) {
+	var page WithdrawalPage
+	from, to := nullTime(f.From), nullTime(f.To)
+	minSum, maxSum := nullAmountArg(f.MinSum), nullAmountArg(f.MaxSum)
+	if err := d.db.QueryRowContext(ctx, WithdrawalsTotalPostgres, userID, from, to, minSum, maxSum).
+		Scan(&page.Total, &page.Count); err != nil {
+		return WithdrawalPage{}, fmt.Errorf("db query: %v", err)
+	}
+
+	var after WithdrawalCursor
+	if f.After != nil {
+		after = *f.After
+	}
+	rows, err := d.db.QueryContext(ctx, ListUserWithdrawalsPostgres,
+		userID, from, to, minSum, maxSum, nullTime(after.ProcessedAt), after.ID, f.Limit+1)
+	if err != nil {
+		return WithdrawalPage{}, fmt.Errorf("db query: %v", err)
+	}
+	defer rows.Close()
+	withdrawals := make([]models.UserWithDraw, 0)
+	ids := make([]int64, 0)
+	for rows.Next() {
+		var (
+			w  models.UserWithDraw
+			id int64
+		)
+		if err := rows.Scan(&id, &w.NumberOrder, &w.Sum, &w.ProcessedAt); err != nil {
+			return WithdrawalPage{}, fmt.Errorf("scan row: %v", err)
+		}
+		withdrawals = append(withdrawals, w)
+		ids = append(ids, id)
+	}
+	if err := rows.Err(); err != nil {
+		return WithdrawalPage{}, fmt.Errorf("rows iteration error: %w", err)
+	}
+	page.Withdrawals, page.Next = withdrawalPage(withdrawals, ids, f.Limit)
+	return page, nil
+}
+
+// withdrawalPage отрезает лишнюю строку выборки и ставит курсор на последнее списание страницы
+func withdrawalPage(withdrawals []models.UserWithDraw, ids []int64, limit int) ([]models.UserWithDraw, *WithdrawalCursor) {
+	if len(withdrawals) <= limit {
+		return withdrawals, nil
+	}
+	last := limit - 1
+	return withdrawals[:limit], &WithdrawalCursor{ProcessedAt: withdrawals[last].ProcessedAt, ID: ids[last]}
+}
+
+// nullAmountArg - NULL вместо отсутствующей суммы, чтобы фильтр в запросе не применялся
+func nullAmountArg(a *money.Amount) any {
+	if a == nil {
+		return nil
+	}
+	return *a
+}
+
+// ReconcileBalances сверяет balances с историей в ledger_entries и возвращает расхождения.
+// При repair = true баланс каждого расходящегося пользователя пересчитывается под блокировкой и перезаписывается
+func (d *DataBaseStorage) ReconcileBalances(ctx context.Context, repair bool) ([]models.BalanceDrift, error) {
+	drifts := make([]models.BalanceDrift, 0)
+	rows, err := d.db.QueryContext(ctx, FindBalanceDriftPostgres)
+	if err != nil {
+		return nil, fmt.Errorf("db query: %v", err)
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var drift models.BalanceDrift
+		if err := rows.Scan(&drift.UserID, &drift.Current, &drift.Withdrawn, &drift.ExpectedCurrent, &drift.ExpectedWithdrawn); err != nil {
+			return nil, fmt.Errorf("scan row: %v", err)
+		}
+		drifts = append(drifts, drift)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("rows iteration error: %w", err)
+	}
+	if !repair {
+		return drifts, nil
+	}
+	for _, drift := range drifts {
+		if err := d.repairBalance(ctx, drift.UserID); err != nil {
+			return drifts, err
+		}
+	}
+	return drifts, nil
+}
+
+// repairBalance пересчитывает баланс пользователя, заблокировав его строку, чтобы не потерять параллельные движения
+func (d *DataBaseStorage) repairBalance(ctx context.Context, userID int) (err error) {
+	tx, err := d.db.BeginTx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer func() {
+		if err != nil {
+			_ = tx.Rollback()
+		}
+	}()
+	if _, err = tx.ExecContext(ctx, EnsureBalancePostgres, userID); err != nil {
+		return fmt.Errorf("failed to create balance: %w", err)
+	}
+	var locked money.Amount
+	if err = tx.QueryRowContext(ctx, LockBalancePostgres, userID).Scan(&locked); err != nil {
+		return fmt.Errorf("failed to lock balance: %w", err)
+	}
+	current, withdrawn, err := ledgerBalance(ctx, tx, userID)
+	if err != nil {
+		return err
+	}
+	if _, err = tx.ExecContext(ctx, RepairBalancePostgres, userID, current, withdrawn); err != nil {
+		return fmt.Errorf("failed to repair balance: %w", err)
+	}
+	if err = tx.Commit(); err != nil {
+		return fmt.Errorf("failed to commit balance repair: %w", err)
+	}
+	return nil
+}
@@ -5,6 +5,7 @@ import (
 	"testing"
 
 	"github.com/NailUsmanov/gophermart/internal/mocks"
+	"github.com/NailUsmanov/gophermart/internal/money"
 	"github.com/NailUsmanov/gophermart/internal/storage"
 	"github.com/stretchr/testify/assert"
 	"go.uber.org/mock/gomock"
@@ -18,10 +19,10 @@ func TestAddWithdrawOrder(t *testing.T) {
 
 		mockServ := mocks.NewMockWithdrawLogic(ctrl)
 		mockServ.EXPECT().CheckExistOrder(gomock.Any(), "1234567890").Return(false, 0, nil)
-		mockServ.EXPECT().GetUserBalance(gomock.Any(), 1).Return(100.0, 0.00, nil)
-		mockServ.EXPECT().AddWithdrawOrder(gomock.Any(), 1, "1234567890", 50.0).Return(nil)
+		mockServ.EXPECT().GetUserBalance(gomock.Any(), 1).Return(money.Amount(10000), money.Amount(0), nil)
+		mockServ.EXPECT().AddWithdrawOrder(gomock.Any(), 1, "1234567890", money.Amount(5000)).Return(nil)
 
-		err := ProcessWithdraw(context.Background(), mockServ, 1, "1234567890", 50.0)
+		err := ProcessWithdraw(context.Background(), mockServ, 1, "1234567890", money.Amount(5000))
 		assert.NoError(t, err)
 	})
 
@@ -32,7 +33,7 @@ func TestAddWithdrawOrder(t *testing.T) {
 		mockServ := mocks.NewMockWithdrawLogic(ctrl)
 		mockServ.EXPECT().CheckExistOrder(gomock.Any(), "1234567890").Return(true, 1, nil)
 
-		err := ProcessWithdraw(context.Background(), mockServ, 1, "1234567890", 50.0)
+		err := ProcessWithdraw(context.Background(), mockServ, 1, "1234567890", money.Amount(5000))
 
 		assert.ErrorIs(t, storage.ErrOrderAlreadyUploaded, err)
 	})
@@ -43,14 +44,14 @@ func TestAddWithdrawOrder(t *testing.T) {
 
 		mockServ := mocks.NewMockWithdrawLogic(ctrl)
 		mockServ.EXPECT().CheckExistOrder(gomock.Any(), "1234567890").Return(false, 0, nil)
-		mockServ.EXPECT().GetUserBalance(gomock.Any(), 1).Return(30.0, 10.0, nil)
+		mockServ.EXPECT().GetUserBalance(gomock.Any(), 1).Return(money.Amount(3000), money.Amount(1000), nil)
 
-		err := ProcessWithdraw(context.Background(), mockServ, 1, "1234567890", 50.0)
+		err := ProcessWithdraw(context.Background(), mockServ, 1, "1234567890", money.Amount(5000))
 		assert.ErrorIs(t, err, storage.ErrNotEnoughFunds)
 	})
 }
 
-func ProcessWithdraw(ctx context.Context, logic storage.WithdrawLogic, userID int, number string, sum float64) error {
+func ProcessWithdraw(ctx context.Context, logic storage.WithdrawLogic, userID int, number string, sum money.Amount) error {
 	exists, _, err := logic.CheckExistOrder(ctx, number)
 	if err != nil {
 		return err
@@ -0,0 +1,827 @@
+// Package storagetest - общий набор контрактных тестов для реализаций storage.Storage.
+//
+// Реализация подключает его из своего _test.go:
+//
+//	storagetest.Run(t, func(t *testing.T) storage.Storage { return storage.NewMemStorage() })
+//
+// Тесты не рассчитывают на пустое хранилище: логины и номера заказов уникальны в каждом запуске,
+// поэтому фабрика может отдавать одну и ту же базу PostgreSQL.
+package storagetest
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/NailUsmanov/gophermart/internal/models"
+	"github.com/NailUsmanov/gophermart/internal/money"
+	"github.com/NailUsmanov/gophermart/internal/storage"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+)
+
+// Factory создает хранилище для одного теста. Пропустить тест (t.Skip) можно прямо в фабрике
+type Factory func(t *testing.T) storage.Storage
+
+var seq atomic.Int64
+
+// unique возвращает строку, не повторяющуюся между тестами и запусками
+func unique(prefix string) string {
+	return fmt.Sprintf("%s%d%04d", prefix, time.Now().UnixNano(), seq.Add(1)%10000)
+}
+
+// Run прогоняет весь контракт против хранилища из фабрики
+func Run(t *testing.T, newStorage Factory) {
+	tests := []struct {
+		name string
+		fn   func(t *testing.T, s storage.Storage)
+	}{
+		{"Registration", testRegistration},
+		{"OrderOwnership", testOrderOwnership},
+		{"StatusTransitions", testStatusTransitions},
+		{"OrderPages", testOrderPages},
+		{"PollScheduling", testPollScheduling},
+		{"Claiming", testClaiming},
+		{"NewOrders", testNewOrders},
+		{"Quarantine", testQuarantine},
+		{"StuckOrders", testStuckOrders},
+		{"BalanceArithmetic", testBalanceArithmetic},
+		{"WithdrawalOrdering", testWithdrawalOrdering},
+		{"WithdrawalPages", testWithdrawalPages},
+		{"IdempotencyKeys", testIdempotencyKeys},
+		{"ContextCancellation", testContextCancellation},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tt.fn(t, newStorage(t))
+		})
+	}
+}
+
+// newUser регистрирует пользователя и возвращает его ID
+func newUser(t *testing.T, s storage.Storage) int {
+	t.Helper()
+	ctx := context.Background()
+	login := unique("user-")
+	require.NoError(t, s.Registration(ctx, login, "hash"))
+	userID, err := s.GetUserIDByLogin(ctx, login)
+	require.NoError(t, err)
+	return userID
+}
+
+// transition - переход заказа, выполненный тестом
+func transition(number, from, to string) storage.StatusTransition {
+	return storage.StatusTransition{Number: number, From: from, To: to, Source: "test"}
+}
+
+// credit начисляет пользователю баллы через обработанный заказ
+func credit(t *testing.T, s storage.Storage, userID int, amount money.Amount) string {
+	t.Helper()
+	ctx := context.Background()
+	order := unique("")
+	require.NoError(t, s.CreateNewOrder(ctx, userID, order, zap.NewNop().Sugar()))
+	require.NoError(t, s.UpdateOrderStatus(ctx, transition(order, "NEW", "PROCESSED"), &amount))
+	return order
+}
+
+func testRegistration(t *testing.T, s storage.Storage) {
+	ctx := context.Background()
+	login := unique("login-")
+
+	hash, err := s.GetUserByLogin(ctx, login)
+	require.NoError(t, err)
+	assert.Empty(t, hash)
+	_, err = s.GetUserIDByLogin(ctx, login)
+	assert.Error(t, err)
+
+	require.NoError(t, s.Registration(ctx, login, "hash-1"))
+	assert.ErrorIs(t, s.Registration(ctx, login, "hash-2"), storage.ErrOrderAlreadyUsed)
+
+	hash, err = s.GetUserByLogin(ctx, login)
+	require.NoError(t, err)
+	assert.Equal(t, "hash-1", hash)
+
+	require.NoError(t, s.UpdatePasswordHash(ctx, login, "hash-3"))
+	hash, err = s.GetUserByLogin(ctx, login)
+	require.NoError(t, err)
+	assert.Equal(t, "hash-3", hash)
+
+	// Новый пользователь начинает с нулевого баланса
+	userID, err := s.GetUserIDByLogin(ctx, login)
+	require.NoError(t, err)
+	current, withdrawn, err := s.GetUserBalance(ctx, userID)
+	require.NoError(t, err)
+	assert.Equal(t, money.Amount(0), current)
+	assert.Equal(t, money.Amount(0), withdrawn)
+}
+
+func testOrderOwnership(t *testing.T, s storage.Storage) {
+	ctx := context.Background()
+	sugar := zap.NewNop().Sugar()
+	owner := newUser(t, s)
+	other := newUser(t, s)
+	order := unique("")
+
+	exists, _, err := s.CheckExistOrder(ctx, order)
+	require.NoError(t, err)
+	assert.False(t, exists)
+
+	require.NoError(t, s.CreateNewOrder(ctx, owner, order, sugar))
+	assert.ErrorIs(t, s.CreateNewOrder(ctx, owner, order, sugar), storage.ErrOrderAlreadyUploaded)
+	assert.ErrorIs(t, s.CreateNewOrder(ctx, other, order, sugar), storage.ErrOrderAlreadyUploaded)
+
+	exists, userID, err := s.CheckExistOrder(ctx, order)
+	require.NoError(t, err)
+	assert.True(t, exists)
+	assert.Equal(t, owner, userID)
+
+	orders, err := s.GetOrdersByUserID(ctx, owner)
+	require.NoError(t, err)
+	require.Len(t, orders, 1)
+	assert.Equal(t, order, orders[0].Number)
+	require.NotNil(t, orders[0].Status)
+	assert.Equal(t, "NEW", *orders[0].Status)
+
+	orders, err = s.GetOrdersByUserID(ctx, other)
+	require.NoError(t, err)
+	assert.Empty(t, orders)
+}
+
+// orderPages проходит по всем страницам заказов пользователя и возвращает номера по порядку
+func orderPages(t *testing.T, s storage.Storage, userID int, f storage.OrderFilter) ([]string, int) {
+	t.Helper()
+	numbers := make([]string, 0)
+	pages := 0
+	var last time.Time
+	for {
+		page, err := s.ListUserOrders(context.Background(), userID, f)
+		require.NoError(t, err)
+		pages++
+		require.LessOrEqual(t, len(page.Orders), f.Limit)
+		for _, o := range page.Orders {
+			if !last.IsZero() {
+				assert.False(t, o.UploadedAt.After(last), "orders must go from newest to oldest")
+			}
+			last = o.UploadedAt
+			numbers = append(numbers, o.Number)
+		}
+		if page.Next == nil {
+			return numbers, pages
+		}
+		f.After = page.Next
+	}
+}
+
+func testOrderPages(t *testing.T, s storage.Storage) {
+	ctx := context.Background()
+	sugar := zap.NewNop().Sugar()
+	userID := newUser(t, s)
+	numbers := make([]string, 0, 5)
+	for i := 0; i < 5; i++ {
+		n := unique("")
+		require.NoError(t, s.CreateNewOrder(ctx, userID, n, sugar))
+		numbers = append(numbers, n)
+	}
+	require.NoError(t, s.CreateNewOrder(ctx, newUser(t, s), unique(""), sugar))
+	require.NoError(t, s.UpdateOrderStatus(ctx, transition(numbers[0], "NEW", "INVALID"), nil))
+	require.NoError(t, s.UpdateOrderStatus(ctx, transition(numbers[1], "NEW", "PROCESSING"), nil))
+
+	// Страницы не теряют и не повторяют заказы, даже загруженные в одно время
+	got, pages := orderPages(t, s, userID, storage.OrderFilter{Limit: 2})
+	assert.ElementsMatch(t, numbers, got)
+	assert.Equal(t, 3, pages)
+	got, pages = orderPages(t, s, userID, storage.OrderFilter{Limit: 5})
+	assert.Len(t, got, 5)
+	assert.Equal(t, 1, pages)
+
+	got, _ = orderPages(t, s, userID, storage.OrderFilter{Limit: 2, Statuses: []string{"NEW"}})
+	assert.ElementsMatch(t, numbers[2:], got)
+	got, _ = orderPages(t, s, userID, storage.OrderFilter{Limit: 2, Statuses: []string{"INVALID", "PROCESSING"}})
+	assert.ElementsMatch(t, numbers[:2], got)
+
+	// From включает границу, To - нет. Границы берем из самих заказов, чтобы не зависеть от часового пояса базы
+	all, err := s.ListUserOrders(ctx, userID, storage.OrderFilter{Limit: 5})
+	require.NoError(t, err)
+	newest, oldest := all.Orders[0].UploadedAt, all.Orders[4].UploadedAt
+	got, _ = orderPages(t, s, userID, storage.OrderFilter{Limit: 10, From: oldest})
+	assert.Len(t, got, 5)
+	got, _ = orderPages(t, s, userID, storage.OrderFilter{Limit: 10, From: oldest, To: newest})
+	for _, n := range got {
+		assert.NotEqual(t, all.Orders[0].Number, n)
+	}
+	got, _ = orderPages(t, s, userID, storage.OrderFilter{Limit: 10, From: newest.Add(time.Second)})
+	assert.Empty(t, got)
+
+	// Граница с другим смещением - тот же момент, а не те же показания часов
+	plus3 := time.FixedZone("UTC+3", 3*60*60)
+	want, _ := orderPages(t, s, userID, storage.OrderFilter{Limit: 10, From: oldest, To: newest})
+	got, _ = orderPages(t, s, userID, storage.OrderFilter{Limit: 10, From: oldest.In(plus3), To: newest.In(plus3)})
+	assert.Equal(t, want, got)
+	got, _ = orderPages(t, s, userID, storage.OrderFilter{Limit: 10, From: oldest.In(plus3)})
+	assert.Len(t, got, 5)
+}
+
+func testStatusTransitions(t *testing.T, s storage.Storage) {
+	ctx := context.Background()
+	sugar := zap.NewNop().Sugar()
+	userID := newUser(t, s)
+	processed := unique("")
+	invalid := unique("")
+	require.NoError(t, s.CreateNewOrder(ctx, userID, processed, sugar))
+	require.NoError(t, s.CreateNewOrder(ctx, userID, invalid, sugar))
+
+	assert.ElementsMatch(t, []string{processed, invalid}, pendingOf(t, s, processed, invalid))
+
+	require.NoError(t, s.UpdateOrderStatus(ctx, transition(processed, "NEW", "PROCESSING"), nil))
+	require.NoError(t, s.UpdateOrderStatus(ctx, transition(invalid, "NEW", "INVALID"), nil))
+	assert.Equal(t, []string{processed}, pendingOf(t, s, processed, invalid))
+
+	accrual := money.Amount(72998)
+	require.NoError(t, s.UpdateOrderStatus(ctx, transition(processed, "PROCESSING", "PROCESSED"), &accrual))
+	// Повторное обновление не начисляет баллы второй раз и не попадает в историю
+	require.NoError(t, s.UpdateOrderStatus(ctx, transition(processed, "PROCESSED", "PROCESSED"), &accrual))
+	assert.Empty(t, pendingOf(t, s, processed, invalid))
+
+	// Заказ уже не в ожидаемом статусе - обновление отклоняется
+	assert.ErrorIs(t, s.UpdateOrderStatus(ctx, transition(invalid, "NEW", "PROCESSED"), &accrual), storage.ErrStatusConflict)
+	assert.ErrorIs(t, s.UpdateOrderStatus(ctx, transition(unique(""), "NEW", "PROCESSED"), &accrual), storage.ErrOrderNotFound)
+
+	history, err := s.GetOrderStatusHistory(ctx, processed)
+	require.NoError(t, err)
+	require.Len(t, history, 2)
+	for i, want := range [][2]string{{"NEW", "PROCESSING"}, {"PROCESSING", "PROCESSED"}} {
+		assert.Equal(t, processed, history[i].Number)
+		assert.Equal(t, want, [2]string{history[i].From, history[i].To})
+		assert.Equal(t, "test", history[i].Source)
+		assert.False(t, history[i].ChangedAt.IsZero())
+	}
+
+	orders, err := s.GetOrdersByUserID(ctx, userID)
+	require.NoError(t, err)
+	statuses := make(map[string]string)
+	for _, o := range orders {
+		require.NotNil(t, o.Status)
+		statuses[o.Number] = *o.Status
+		if o.Number == processed {
+			require.NotNil(t, o.Accrual)
+			assert.Equal(t, accrual, *o.Accrual)
+		}
+	}
+	assert.Equal(t, map[string]string{processed: "PROCESSED", invalid: "INVALID"}, statuses)
+
+	current, _, err := s.GetUserBalance(ctx, userID)
+	require.NoError(t, err)
+	assert.Equal(t, accrual, current)
+}
+
+// dueOrders возвращает все заказы, которые ждут опроса accrual. Аренда нулевой длины
+// истекает сразу, поэтому выборка не мешает следующим проверкам
+func dueOrders(t *testing.T, s storage.Storage) []storage.Order {
+	t.Helper()
+	orders, err := s.ClaimOrdersForAccrual(context.Background(), unique("probe-"), 1<<20, 0)
+	require.NoError(t, err)
+	return orders
+}
+
+// pendingOf возвращает те из заказов numbers, которые ждут опроса accrual
+func pendingOf(t *testing.T, s storage.Storage, numbers ...string) []string {
+	t.Helper()
+	orders := dueOrders(t, s)
+	want := make(map[string]bool, len(numbers))
+	for _, n := range numbers {
+		want[n] = true
+	}
+	pending := make([]string, 0)
+	for _, o := range orders {
+		if want[o.Number] {
+			pending = append(pending, o.Number)
+		}
+	}
+	return pending
+}
+
+func testPollScheduling(t *testing.T, s storage.Storage) {
+	ctx := context.Background()
+	sugar := zap.NewNop().Sugar()
+	userID := newUser(t, s)
+	older := unique("")
+	newer := unique("")
+	require.NoError(t, s.CreateNewOrder(ctx, userID, older, sugar))
+	// Между заказами проходит время, чтобы uploaded_at различались
+	time.Sleep(20 * time.Millisecond)
+	require.NoError(t, s.CreateNewOrder(ctx, userID, newer, sugar))
+
+	// Заказы для опроса идут от старых к новым
+	assert.Equal(t, []string{older, newer}, pendingOf(t, s, older, newer))
+
+	// Отложенный заказ не попадает в опрос, пока не наступит срок
+	require.NoError(t, s.ScheduleNextCheck(ctx, older, time.Hour))
+	assert.Equal(t, []string{newer}, pendingOf(t, s, older, newer))
+
+	// Уже наступивший срок - заказ снова в опросе, попытки копятся
+	require.NoError(t, s.ScheduleNextCheck(ctx, newer, 0))
+	require.NoError(t, s.ScheduleNextCheck(ctx, newer, 0))
+	assert.Equal(t, 2, attemptsOf(t, s, newer))
+
+	// Ответ accrual сбрасывает счетчик и срок
+	require.NoError(t, s.UpdateOrderStatus(ctx, transition(older, "NEW", "PROCESSING"), nil))
+	require.NoError(t, s.UpdateOrderStatus(ctx, transition(newer, "NEW", "PROCESSING"), nil))
+	assert.Equal(t, []string{older, newer}, pendingOf(t, s, older, newer))
+	assert.Equal(t, 0, attemptsOf(t, s, newer))
+}
+
+// attemptsOf возвращает счетчик попыток заказа из выборки для опроса
+func attemptsOf(t *testing.T, s storage.Storage, number string) int {
+	t.Helper()
+	for _, o := range dueOrders(t, s) {
+		if o.Number == number {
+			return o.Attempts
+		}
+	}
+	t.Fatalf("order %s is not due", number)
+	return 0
+}
+
+// claimedOf возвращает те из заказов numbers, которые достались владельцу owner
+func claimedOf(t *testing.T, s storage.Storage, owner string, lease time.Duration, numbers ...string) []string {
+	t.Helper()
+	orders, err := s.ClaimOrdersForAccrual(context.Background(), owner, 1<<20, lease)
+	require.NoError(t, err)
+	want := make(map[string]bool, len(numbers))
+	for _, n := range numbers {
+		want[n] = true
+	}
+	claimed := make([]string, 0)
+	for _, o := range orders {
+		if want[o.Number] {
+			claimed = append(claimed, o.Number)
+		}
+	}
+	return claimed
+}
+
+func testClaiming(t *testing.T, s storage.Storage) {
+	ctx := context.Background()
+	sugar := zap.NewNop().Sugar()
+	userID := newUser(t, s)
+	numbers := make([]string, 0, 6)
+	for i := 0; i < 6; i++ {
+		n := unique("")
+		require.NoError(t, s.CreateNewOrder(ctx, userID, n, sugar))
+		numbers = append(numbers, n)
+	}
+
+	// Параллельные экземпляры получают непересекающиеся наборы заказов
+	var (
+		wg      sync.WaitGroup
+		mu      sync.Mutex
+		claimed = make(map[string]int)
+	)
+	for i := 0; i < 3; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			got := claimedOf(t, s, unique("instance-"), time.Hour, numbers...)
+			mu.Lock()
+			defer mu.Unlock()
+			for _, n := range got {
+				claimed[n]++
+			}
+		}()
+	}
+	wg.Wait()
+	require.Len(t, claimed, len(numbers))
+	for n, times := range claimed {
+		assert.Equal(t, 1, times, "order %s claimed more than once", n)
+	}
+
+	// Пока аренда не истекла, заказы никому не выдаются
+	assert.Empty(t, claimedOf(t, s, unique("instance-"), time.Hour, numbers...))
+
+	// Ответ accrual и перенос проверки снимают аренду
+	require.NoError(t, s.UpdateOrderStatus(ctx, transition(numbers[0], "NEW", "PROCESSING"), nil))
+	require.NoError(t, s.ScheduleNextCheck(ctx, numbers[1], 0))
+	assert.Equal(t, numbers[:2], claimedOf(t, s, unique("instance-"), 0, numbers...))
+
+	// Экземпляр, остановленный до опроса, снимает свою аренду, и заказ сразу доступен другим.
+	// Чужую аренду снять нельзя
+	owner := unique("stopping-")
+	released := unique("")
+	require.NoError(t, s.CreateNewOrder(ctx, userID, released, sugar))
+	assert.Equal(t, []string{released}, claimedOf(t, s, owner, time.Hour, released))
+	require.NoError(t, s.ReleaseOrderLease(ctx, unique("instance-"), released))
+	assert.Empty(t, claimedOf(t, s, unique("instance-"), time.Hour, released))
+	require.NoError(t, s.ReleaseOrderLease(ctx, owner, released))
+	assert.Equal(t, []string{released}, claimedOf(t, s, unique("instance-"), time.Hour, released))
+
+	// Истекшую аренду упавшего экземпляра забирает другой
+	other := unique("")
+	require.NoError(t, s.CreateNewOrder(ctx, userID, other, sugar))
+	assert.Equal(t, []string{other}, claimedOf(t, s, unique("crashed-"), 50*time.Millisecond, other))
+	assert.Empty(t, claimedOf(t, s, unique("instance-"), time.Hour, other))
+	time.Sleep(100 * time.Millisecond)
+	assert.Equal(t, []string{other}, claimedOf(t, s, unique("instance-"), time.Hour, other))
+}
+
+func testNewOrders(t *testing.T, s storage.Storage) {
+	ctx, cancel := context.WithCancel(context.Background())
+	created, err := s.NewOrders(ctx)
+	require.NoError(t, err)
+
+	userID := newUser(t, s)
+	number := unique("")
+	// LISTEN в PostgreSQL выполняется в фоне, поэтому создаем заказы, пока не придет уведомление
+	deadline := time.After(5 * time.Second)
+	ticker := time.NewTicker(50 * time.Millisecond)
+	defer ticker.Stop()
+	require.NoError(t, s.CreateNewOrder(ctx, userID, number, zap.NewNop().Sugar()))
+	numbers := map[string]bool{number: true}
+wait:
+	for {
+		select {
+		case got := <-created:
+			if numbers[got] {
+				break wait
+			}
+		case <-ticker.C:
+			number = unique("")
+			require.NoError(t, s.CreateNewOrder(ctx, userID, number, zap.NewNop().Sugar()))
+			numbers[number] = true
+		case <-deadline:
+			t.Fatal("no notification about new order")
+		}
+	}
+
+	// После отмены подписки канал закрывается
+	cancel()
+	require.Eventually(t, func() bool {
+		select {
+		case _, ok := <-created:
+			return !ok
+		default:
+			return false
+		}
+	}, 5*time.Second, 10*time.Millisecond)
+}
+
+func testQuarantine(t *testing.T, s storage.Storage) {
+	ctx := context.Background()
+	first := unique("")
+	second := unique("")
+	require.NoError(t, s.QuarantineAccrualResponse(ctx, models.QuarantinedResponse{
+		OrderNumber: first, Reason: "suspicious accrual response: negative accrual -1", Response: `{"accrual":-1}`,
+	}))
+	require.NoError(t, s.QuarantineAccrualResponse(ctx, models.QuarantinedResponse{
+		OrderNumber: second, Reason: "invalid accrual response", Response: "not json",
+	}))
+
+	// От новых к старым
+	responses, err := s.ListQuarantinedResponses(ctx, 2)
+	require.NoError(t, err)
+	require.Len(t, responses, 2)
+	assert.Equal(t, second, responses[0].OrderNumber)
+	assert.Equal(t, "not json", responses[0].Response)
+	assert.Equal(t, first, responses[1].OrderNumber)
+	assert.Equal(t, `{"accrual":-1}`, responses[1].Response)
+	assert.Greater(t, responses[0].ID, responses[1].ID)
+	assert.False(t, responses[0].ReceivedAt.IsZero())
+
+	responses, err = s.ListQuarantinedResponses(ctx, 1)
+	require.NoError(t, err)
+	require.Len(t, responses, 1)
+	assert.Equal(t, second, responses[0].OrderNumber)
+}
+
+// stuckOf возвращает те из заказов numbers, которые есть в списке застрявших
+func stuckOf(t *testing.T, s storage.Storage, numbers ...string) []models.StuckOrder {
+	t.Helper()
+	orders, err := s.ListStuckOrders(context.Background(), 1<<20)
+	require.NoError(t, err)
+	want := make(map[string]bool, len(numbers))
+	for _, n := range numbers {
+		want[n] = true
+	}
+	stuck := make([]models.StuckOrder, 0)
+	for _, o := range orders {
+		if want[o.Number] {
+			stuck = append(stuck, o)
+		}
+	}
+	return stuck
+}
+
+func testStuckOrders(t *testing.T, s storage.Storage) {
+	ctx := context.Background()
+	sugar := zap.NewNop().Sugar()
+	userID := newUser(t, s)
+	first := unique("")
+	second := unique("")
+	require.NoError(t, s.CreateNewOrder(ctx, userID, first, sugar))
+	require.NoError(t, s.CreateNewOrder(ctx, userID, second, sugar))
+	require.NoError(t, s.UpdateOrderStatus(ctx, transition(second, "NEW", "PROCESSING"), nil))
+	// Заказ, который не возвращали в опрос, опрашивается с загрузки
+	for _, o := range dueOrders(t, s) {
+		if o.Number == first || o.Number == second {
+			assert.True(t, o.QueuedAt.Equal(o.UploadedAt), "queued at %v, uploaded at %v", o.QueuedAt, o.UploadedAt)
+		}
+	}
+
+	// Застрявшие заказы не опрашиваются и попадают в список от давно застрявших к недавним
+	require.NoError(t, s.UpdateOrderStatus(ctx, transition(second, "PROCESSING", "STUCK"), nil))
+	require.NoError(t, s.UpdateOrderStatus(ctx, transition(first, "NEW", "STUCK"), nil))
+	assert.Empty(t, pendingOf(t, s, first, second))
+	stuck := stuckOf(t, s, first, second)
+	require.Len(t, stuck, 2)
+	assert.Equal(t, second, stuck[0].Number)
+	assert.Equal(t, first, stuck[1].Number)
+	for _, o := range stuck {
+		assert.Equal(t, userID, o.UserID)
+		assert.False(t, o.UploadedAt.IsZero())
+		assert.False(t, o.StuckAt.IsZero())
+	}
+
+	// Возвращенный заказ снова в опросе и пропадает из списка.
+	// Возраст для ACCRUAL_MAX_AGE отсчитывается от возврата, а не от загрузки
+	time.Sleep(10 * time.Millisecond)
+	require.NoError(t, s.UpdateOrderStatus(ctx, transition(first, "STUCK", "NEW"), nil))
+	assert.Equal(t, []string{first}, pendingOf(t, s, first, second))
+	for _, o := range dueOrders(t, s) {
+		if o.Number == first {
+			assert.True(t, o.QueuedAt.After(o.UploadedAt), "queued at %v, uploaded at %v", o.QueuedAt, o.UploadedAt)
+		}
+	}
+	stuck = stuckOf(t, s, first, second)
+	require.Len(t, stuck, 1)
+	assert.Equal(t, second, stuck[0].Number)
+}
+
+func testBalanceArithmetic(t *testing.T, s storage.Storage) {
+	ctx := context.Background()
+	userID := newUser(t, s)
+	order := credit(t, s, userID, money.Amount(50050))
+
+	// Номер заказа для списания не конфликтует с номером заказа для начисления
+	require.NoError(t, s.AddWithdrawOrder(ctx, userID, order, money.Amount(10001)))
+	assert.ErrorIs(t, s.AddWithdrawOrder(ctx, userID, order, money.Amount(1)), storage.ErrOrderAlreadyUsed)
+	assert.ErrorIs(t, s.AddWithdrawOrder(ctx, userID, unique(""), money.Amount(40050)), storage.ErrNotEnoughFunds)
+	require.NoError(t, s.AddWithdrawOrder(ctx, userID, unique(""), money.Amount(40049)))
+	assert.ErrorIs(t, s.AddWithdrawOrder(ctx, userID, unique(""), money.Amount(1)), storage.ErrNotEnoughFunds)
+
+	current, withdrawn, err := s.GetUserBalance(ctx, userID)
+	require.NoError(t, err)
+	assert.Equal(t, money.Amount(0), current)
+	assert.Equal(t, money.Amount(50050), withdrawn)
+
+	withdrawn, err = s.GetUserWithDrawns(ctx, userID)
+	require.NoError(t, err)
+	assert.Equal(t, money.Amount(50050), withdrawn)
+
+	// Движения через storage не дают расхождений с ledger
+	drifts, err := s.ReconcileBalances(ctx, false)
+	require.NoError(t, err)
+	for _, d := range drifts {
+		assert.NotEqual(t, userID, d.UserID)
+	}
+}
+
+func testWithdrawalOrdering(t *testing.T, s storage.Storage) {
+	ctx := context.Background()
+	userID := newUser(t, s)
+	credit(t, s, userID, money.Amount(100000))
+
+	numbers := []string{unique("w"), unique("w"), unique("w")}
+	for i, n := range numbers {
+		require.NoError(t, s.AddWithdrawOrder(ctx, userID, n, money.Amount(100*(i+1))))
+	}
+
+	withdrawals, err := s.GetAllUserWithdrawals(ctx, userID)
+	require.NoError(t, err)
+	require.Len(t, withdrawals, len(numbers))
+	// От новых к старым
+	for i, w := range withdrawals {
+		j := len(numbers) - 1 - i
+		assert.Equal(t, numbers[j], w.NumberOrder)
+		assert.Equal(t, money.Amount(100*(j+1)), w.Sum)
+		assert.False(t, w.ProcessedAt.IsZero())
+	}
+
+	other := newUser(t, s)
+	withdrawals, err = s.GetAllUserWithdrawals(ctx, other)
+	require.NoError(t, err)
+	assert.Empty(t, withdrawals)
+}
+
+// withdrawalPages проходит по всем страницам списаний и возвращает номера по порядку и итог
+func withdrawalPages(t *testing.T, s storage.Storage, userID int, f storage.WithdrawalFilter) ([]string, money.Amount, int) {
+	t.Helper()
+	numbers := make([]string, 0)
+	var (
+		total money.Amount
+		count int
+		last  time.Time
+	)
+	for {
+		page, err := s.ListUserWithdrawals(context.Background(), userID, f)
+		require.NoError(t, err)
+		require.LessOrEqual(t, len(page.Withdrawals), f.Limit)
+		// Итог не зависит от страницы
+		if f.After != nil {
+			assert.Equal(t, total, page.Total)
+			assert.Equal(t, count, page.Count)
+		}
+		total, count = page.Total, page.Count
+		for _, w := range page.Withdrawals {
+			if !last.IsZero() {
+				assert.False(t, w.ProcessedAt.After(last), "withdrawals must go from newest to oldest")
+			}
+			last = w.ProcessedAt
+			numbers = append(numbers, w.NumberOrder)
+		}
+		if page.Next == nil {
+			return numbers, total, count
+		}
+		f.After = page.Next
+	}
+}
+
+func testWithdrawalPages(t *testing.T, s storage.Storage) {
+	ctx := context.Background()
+	userID := newUser(t, s)
+	credit(t, s, userID, money.Amount(100000))
+	numbers := make([]string, 0, 5)
+	for i := 1; i <= 5; i++ {
+		n := unique("w")
+		require.NoError(t, s.AddWithdrawOrder(ctx, userID, n, money.Amount(100*i)))
+		numbers = append(numbers, n)
+	}
+	other := newUser(t, s)
+	credit(t, s, other, money.Amount(1000))
+	require.NoError(t, s.AddWithdrawOrder(ctx, other, unique("w"), money.Amount(100)))
+
+	got, total, count := withdrawalPages(t, s, userID, storage.WithdrawalFilter{Limit: 2})
+	assert.ElementsMatch(t, numbers, got)
+	assert.Equal(t, money.Amount(1500), total)
+	assert.Equal(t, 5, count)
+
+	// Границы сумм включительно
+	minSum, maxSum := money.Amount(200), money.Amount(400)
+	got, total, count = withdrawalPages(t, s, userID, storage.WithdrawalFilter{Limit: 2, MinSum: &minSum, MaxSum: &maxSum})
+	assert.ElementsMatch(t, numbers[1:4], got)
+	assert.Equal(t, money.Amount(900), total)
+	assert.Equal(t, 3, count)
+
+	// From включает границу, To - нет. Границы берем из самих списаний, чтобы не зависеть от часового пояса базы
+	all, err := s.ListUserWithdrawals(ctx, userID, storage.WithdrawalFilter{Limit: 5})
+	require.NoError(t, err)
+	newest, oldest := all.Withdrawals[0].ProcessedAt, all.Withdrawals[4].ProcessedAt
+	var (
+		before    []string
+		beforeSum money.Amount
+	)
+	for _, w := range all.Withdrawals {
+		if w.ProcessedAt.Before(newest) {
+			before = append(before, w.NumberOrder)
+			beforeSum += w.Sum
+		}
+	}
+	got, total, _ = withdrawalPages(t, s, userID, storage.WithdrawalFilter{Limit: 10, From: oldest, To: newest})
+	assert.ElementsMatch(t, before, got)
+	assert.Equal(t, beforeSum, total)
+	got, total, count = withdrawalPages(t, s, userID, storage.WithdrawalFilter{Limit: 10, From: newest.Add(time.Second)})
+	assert.Empty(t, got)
+	assert.Zero(t, total)
+	assert.Zero(t, count)
+
+	// Граница с другим смещением - тот же момент и для страницы, и для итогов
+	plus3 := time.FixedZone("UTC+3", 3*60*60)
+	got, total, count = withdrawalPages(t, s, userID, storage.WithdrawalFilter{Limit: 10, From: oldest.In(plus3), To: newest.In(plus3)})
+	assert.ElementsMatch(t, before, got)
+	assert.Equal(t, beforeSum, total)
+	assert.Equal(t, len(before), count)
+	got, total, count = withdrawalPages(t, s, userID, storage.WithdrawalFilter{Limit: 10, From: newest.In(plus3)})
+	assert.Len(t, got, 5-len(before))
+	assert.Equal(t, money.Amount(1500)-beforeSum, total)
+	assert.Equal(t, 5-len(before), count)
+}
+
+func testIdempotencyKeys(t *testing.T, s storage.Storage) {
+	ctx := context.Background()
+	userID := newUser(t, s)
+	other := newUser(t, s)
+	key := unique("key-")
+
+	// Первый запрос закрепляет ключ, повтор видит его в работе
+	prev, err := s.BeginIdempotentRequest(ctx, userID, key, "fp1", time.Hour)
+	require.NoError(t, err)
+	assert.Nil(t, prev)
+	prev, err = s.BeginIdempotentRequest(ctx, userID, key, "fp1", time.Hour)
+	require.NoError(t, err)
+	require.NotNil(t, prev)
+	assert.Equal(t, "fp1", prev.Fingerprint)
+	assert.Zero(t, prev.Status)
+
+	// Ключи разных пользователей не пересекаются
+	prev, err = s.BeginIdempotentRequest(ctx, other, key, "fp2", time.Hour)
+	require.NoError(t, err)
+	assert.Nil(t, prev)
+
+	// Освобожденный ключ можно закрепить заново
+	require.NoError(t, s.ReleaseIdempotentRequest(ctx, userID, key))
+	prev, err = s.BeginIdempotentRequest(ctx, userID, key, "fp1", time.Hour)
+	require.NoError(t, err)
+	assert.Nil(t, prev)
+
+	// Сохраненный ответ отдается на повтор, а Release его уже не удаляет
+	resp := models.IdempotentResponse{Status: 202, ContentType: "text/plain", Body: []byte("accepted")}
+	require.NoError(t, s.CompleteIdempotentRequest(ctx, userID, key, resp))
+	require.NoError(t, s.ReleaseIdempotentRequest(ctx, userID, key))
+	prev, err = s.BeginIdempotentRequest(ctx, userID, key, "fp3", time.Hour)
+	require.NoError(t, err)
+	require.NotNil(t, prev)
+	assert.Equal(t, "fp1", prev.Fingerprint)
+	assert.Equal(t, 202, prev.Status)
+	assert.Equal(t, "text/plain", prev.ContentType)
+	assert.Equal(t, []byte("accepted"), prev.Body)
+
+	// Повторный Complete не перезаписывает первый ответ
+	require.NoError(t, s.CompleteIdempotentRequest(ctx, userID, key, models.IdempotentResponse{Status: 500}))
+	prev, err = s.BeginIdempotentRequest(ctx, userID, key, "fp1", time.Hour)
+	require.NoError(t, err)
+	require.NotNil(t, prev)
+	assert.Equal(t, 202, prev.Status)
+
+	// Пустое тело сохраняется как пустое, а не как ошибка сканирования
+	empty := unique("key-")
+	_, err = s.BeginIdempotentRequest(ctx, userID, empty, "fp1", time.Hour)
+	require.NoError(t, err)
+	require.NoError(t, s.CompleteIdempotentRequest(ctx, userID, empty, models.IdempotentResponse{Status: 200}))
+	prev, err = s.BeginIdempotentRequest(ctx, userID, empty, "fp1", time.Hour)
+	require.NoError(t, err)
+	require.NotNil(t, prev)
+	assert.Equal(t, 200, prev.Status)
+	assert.Empty(t, prev.Body)
+
+	// После TTL ключ свободен
+	expiring := unique("key-")
+	_, err = s.BeginIdempotentRequest(ctx, userID, expiring, "fp1", time.Second)
+	require.NoError(t, err)
+	require.NoError(t, s.CompleteIdempotentRequest(ctx, userID, expiring, resp))
+	time.Sleep(1100 * time.Millisecond)
+	prev, err = s.BeginIdempotentRequest(ctx, userID, expiring, "fp2", time.Hour)
+	require.NoError(t, err)
+	assert.Nil(t, prev)
+}
+
+func testContextCancellation(t *testing.T, s storage.Storage) {
+	userID := newUser(t, s)
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	calls := map[string]func() error{
+		"Registration": func() error { return s.Registration(ctx, unique("login-"), "hash") },
+		"CreateNewOrder": func() error {
+			return s.CreateNewOrder(ctx, userID, unique(""), zap.NewNop().Sugar())
+		},
+		"GetOrdersByUserID": func() error {
+			_, err := s.GetOrdersByUserID(ctx, userID)
+			return err
+		},
+		"GetOrderStatusHistory": func() error {
+			_, err := s.GetOrderStatusHistory(ctx, unique(""))
+			return err
+		},
+		"NewOrders": func() error {
+			_, err := s.NewOrders(ctx)
+			return err
+		},
+		"ClaimOrdersForAccrual": func() error {
+			_, err := s.ClaimOrdersForAccrual(ctx, "owner", 10, time.Minute)
+			return err
+		},
+		"GetUserBalance": func() error {
+			_, _, err := s.GetUserBalance(ctx, userID)
+			return err
+		},
+		"AddWithdrawOrder": func() error {
+			return s.AddWithdrawOrder(ctx, userID, unique(""), money.Amount(1))
+		},
+		"GetAllUserWithdrawals": func() error {
+			_, err := s.GetAllUserWithdrawals(ctx, userID)
+			return err
+		},
+	}
+	for name, call := range calls {
+		err := call()
+		// Ошибка не должна выдавать себя за бизнес-ошибку
+		if assert.Error(t, err, name) {
+			assert.False(t, errors.Is(err, storage.ErrNotEnoughFunds), name)
+			assert.False(t, errors.Is(err, storage.ErrOrderAlreadyUsed), name)
+			assert.False(t, errors.Is(err, storage.ErrOrderAlreadyUploaded), name)
+		}
+	}
+}
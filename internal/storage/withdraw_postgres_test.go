@@ -0,0 +1,204 @@
+package storage_test
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/url"
+	"os"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/NailUsmanov/gophermart/internal/money"
+	"github.com/NailUsmanov/gophermart/internal/storage"
+	"github.com/NailUsmanov/gophermart/internal/storage/storagetest"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+)
+
+// newTestDataBaseStorage подключается к PostgreSQL из TEST_DATABASE_URI или пропускает тест
+func newTestDataBaseStorage(t *testing.T) *storage.DataBaseStorage {
+	t.Helper()
+	dsn := os.Getenv("TEST_DATABASE_URI")
+	if dsn == "" {
+		t.Skip("TEST_DATABASE_URI is not set")
+	}
+	s, err := storage.NewDataBaseStorage(dsn)
+	require.NoError(t, err)
+	return s
+}
+
+func TestDataBaseStorageConformance(t *testing.T) {
+	storagetest.Run(t, func(t *testing.T) storage.Storage {
+		return newTestDataBaseStorage(t)
+	})
+}
+
+func TestDataBaseStorageSessionUTC(t *testing.T) {
+	dsn := os.Getenv("TEST_DATABASE_URI")
+	if dsn == "" {
+		t.Skip("TEST_DATABASE_URI is not set")
+	}
+	// Зона из DSN не должна менять, как now() пишет время в TIMESTAMP
+	if strings.Contains(dsn, "://") {
+		u, err := url.Parse(dsn)
+		require.NoError(t, err)
+		q := u.Query()
+		q.Set("timezone", "Asia/Tokyo")
+		u.RawQuery = q.Encode()
+		dsn = u.String()
+	} else {
+		dsn += " timezone=Asia/Tokyo"
+	}
+	s, err := storage.NewDataBaseStorage(dsn)
+	require.NoError(t, err)
+	ctx := context.Background()
+
+	login := fmt.Sprintf("tz-%d", time.Now().UnixNano())
+	require.NoError(t, s.Registration(ctx, login, "hash"))
+	userID, err := s.GetUserIDByLogin(ctx, login)
+	require.NoError(t, err)
+	order := fmt.Sprintf("%d", time.Now().UnixNano())
+	require.NoError(t, s.CreateNewOrder(ctx, userID, order, zap.NewNop().Sugar()))
+	accrual := money.Amount(1000)
+	require.NoError(t, s.UpdateOrderStatus(ctx, storage.StatusTransition{Number: order, From: "NEW", To: "PROCESSED", Source: "test"}, &accrual))
+	require.NoError(t, s.AddWithdrawOrder(ctx, userID, order+"-w", money.Amount(300)))
+
+	now := time.Now().UTC()
+	from, to := now.Add(-time.Minute), now.Add(time.Minute)
+	page, err := s.ListUserOrders(ctx, userID, storage.OrderFilter{Limit: 10, From: from, To: to})
+	require.NoError(t, err)
+	assert.Len(t, page.Orders, 1)
+
+	// Страница и итог списаний считаются по тем же границам
+	withdrawals, err := s.ListUserWithdrawals(ctx, userID, storage.WithdrawalFilter{Limit: 10, From: from, To: to})
+	require.NoError(t, err)
+	assert.Len(t, withdrawals.Withdrawals, 1)
+	assert.Equal(t, money.Amount(300), withdrawals.Total)
+	assert.Equal(t, 1, withdrawals.Count)
+}
+
+func TestAddWithdrawOrderConcurrent(t *testing.T) {
+	s := newTestDataBaseStorage(t)
+	ctx := context.Background()
+	sugar := zap.NewNop().Sugar()
+
+	// Заводим пользователя с балансом 100
+	login := fmt.Sprintf("concurrent-%d", time.Now().UnixNano())
+	require.NoError(t, s.Registration(ctx, login, "hash"))
+	userID, err := s.GetUserIDByLogin(ctx, login)
+	require.NoError(t, err)
+
+	accrualOrder := fmt.Sprintf("%d", time.Now().UnixNano())
+	require.NoError(t, s.CreateNewOrder(ctx, userID, accrualOrder, sugar))
+	accrual := money.Amount(10000)
+	require.NoError(t, s.UpdateOrderStatus(ctx, storage.StatusTransition{Number: accrualOrder, From: "NEW", To: "PROCESSED", Source: "test"}, &accrual))
+
+	// Параллельно пытаемся списать 300 раз по 1 баллу
+	const attempts = 300
+	var (
+		wg        sync.WaitGroup
+		mu        sync.Mutex
+		succeeded int
+		rejected  int
+	)
+	start := make(chan struct{})
+	for i := 0; i < attempts; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			<-start
+			err := s.AddWithdrawOrder(ctx, userID, fmt.Sprintf("%s-w%d", accrualOrder, i), money.Amount(100))
+			mu.Lock()
+			defer mu.Unlock()
+			switch {
+			case err == nil:
+				succeeded++
+			case errors.Is(err, storage.ErrNotEnoughFunds):
+				rejected++
+			default:
+				t.Errorf("unexpected error: %v", err)
+			}
+		}(i)
+	}
+	close(start)
+	wg.Wait()
+
+	current, withdrawn, err := s.GetUserBalance(ctx, userID)
+	require.NoError(t, err)
+	assert.Equal(t, 100, succeeded)
+	assert.Equal(t, attempts-100, rejected)
+	assert.GreaterOrEqual(t, current, money.Amount(0))
+	assert.Equal(t, money.Amount(0), current)
+	assert.Equal(t, money.Amount(10000), withdrawn)
+}
+
+func TestLedgerBalance(t *testing.T) {
+	s := newTestDataBaseStorage(t)
+	ctx := context.Background()
+	sugar := zap.NewNop().Sugar()
+
+	login := fmt.Sprintf("ledger-%d", time.Now().UnixNano())
+	require.NoError(t, s.Registration(ctx, login, "hash"))
+	userID, err := s.GetUserIDByLogin(ctx, login)
+	require.NoError(t, err)
+
+	order := fmt.Sprintf("%d", time.Now().UnixNano())
+	require.NoError(t, s.CreateNewOrder(ctx, userID, order, sugar))
+	accrual := money.Amount(72998)
+	require.NoError(t, s.UpdateOrderStatus(ctx, storage.StatusTransition{Number: order, From: "NEW", To: "PROCESSED", Source: "test"}, &accrual))
+	// Повторная обработка того же заказа не начисляет баллы второй раз
+	require.NoError(t, s.UpdateOrderStatus(ctx, storage.StatusTransition{Number: order, From: "PROCESSED", To: "PROCESSED", Source: "test"}, &accrual))
+
+	// Номер заказа для списания не конфликтует с номером заказа для начисления
+	require.NoError(t, s.AddWithdrawOrder(ctx, userID, order, money.Amount(10050)))
+	assert.ErrorIs(t, s.AddWithdrawOrder(ctx, userID, order, money.Amount(100)), storage.ErrOrderAlreadyUsed)
+
+	current, withdrawn, err := s.GetUserBalance(ctx, userID)
+	require.NoError(t, err)
+	assert.Equal(t, money.Amount(62948), current)
+	assert.Equal(t, money.Amount(10050), withdrawn)
+
+	withdrawals, err := s.GetAllUserWithdrawals(ctx, userID)
+	require.NoError(t, err)
+	require.Len(t, withdrawals, 1)
+	assert.Equal(t, order, withdrawals[0].NumberOrder)
+	assert.Equal(t, money.Amount(10050), withdrawals[0].Sum)
+
+	// Заказ-списание не попадает в список заказов пользователя
+	orders, err := s.GetOrdersByUserID(ctx, userID)
+	require.NoError(t, err)
+	assert.Len(t, orders, 1)
+}
+
+func TestReconcileBalances(t *testing.T) {
+	s := newTestDataBaseStorage(t)
+	ctx := context.Background()
+	sugar := zap.NewNop().Sugar()
+
+	login := fmt.Sprintf("reconcile-%d", time.Now().UnixNano())
+	require.NoError(t, s.Registration(ctx, login, "hash"))
+	userID, err := s.GetUserIDByLogin(ctx, login)
+	require.NoError(t, err)
+
+	order := fmt.Sprintf("%d", time.Now().UnixNano())
+	require.NoError(t, s.CreateNewOrder(ctx, userID, order, sugar))
+	accrual := money.Amount(5000)
+	require.NoError(t, s.UpdateOrderStatus(ctx, storage.StatusTransition{Number: order, From: "NEW", To: "PROCESSED", Source: "test"}, &accrual))
+	require.NoError(t, s.AddWithdrawOrder(ctx, userID, order, money.Amount(1500)))
+
+	// Движения через storage не дают расхождений
+	drifts, err := s.ReconcileBalances(ctx, false)
+	require.NoError(t, err)
+	for _, d := range drifts {
+		assert.NotEqual(t, userID, d.UserID)
+	}
+
+	current, withdrawn, err := s.GetUserBalance(ctx, userID)
+	require.NoError(t, err)
+	assert.Equal(t, money.Amount(3500), current)
+	assert.Equal(t, money.Amount(1500), withdrawn)
+}
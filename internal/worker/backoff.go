@@ -0,0 +1,24 @@
+package worker
+
+import (
+	"math/rand/v2"
+	"time"
+)
+
+// Backoff возвращает паузу перед следующей проверкой заказа после attempts неудачных опросов подряд:
+// base * 2^attempts, но не больше max. Половина паузы случайная, чтобы заказы,
+// загруженные одновременно, не опрашивались одной пачкой
+func Backoff(attempts int, base, max time.Duration) time.Duration {
+	delay := base
+	for i := 0; i < attempts && delay < max; i++ {
+		delay *= 2
+	}
+	if delay > max {
+		delay = max
+	}
+	half := delay / 2
+	if half <= 0 {
+		return delay
+	}
+	return half + rand.N(half+1)
+}
@@ -0,0 +1,30 @@
+package worker
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestBackoff(t *testing.T) {
+	base, max := time.Second, time.Minute
+	tests := []struct {
+		attempts int
+		want     time.Duration
+	}{
+		{attempts: 0, want: time.Second},
+		{attempts: 1, want: 2 * time.Second},
+		{attempts: 3, want: 8 * time.Second},
+		{attempts: 6, want: time.Minute},
+		{attempts: 1000, want: time.Minute},
+	}
+	for _, tt := range tests {
+		for i := 0; i < 100; i++ {
+			got := Backoff(tt.attempts, base, max)
+			// Половина паузы случайная: [want/2, want]
+			assert.GreaterOrEqual(t, got, tt.want/2, "attempts %d", tt.attempts)
+			assert.LessOrEqual(t, got, tt.want, "attempts %d", tt.attempts)
+		}
+	}
+}
@@ -0,0 +1,150 @@
+package worker
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+// Состояния CircuitBreaker
+const (
+	BreakerClosed   = "closed"
+	BreakerOpen     = "open"
+	BreakerHalfOpen = "half-open"
+)
+
+// BreakerState - текущее состояние предохранителя для логов и проверки здоровья
+type BreakerState struct {
+	State string `json:"state"`
+	// Failures - отказов accrual подряд
+	Failures int `json:"failures"`
+	// OpenUntil - до этого момента запросы не отправляются, после него уходит пробный запрос
+	OpenUntil time.Time `json:"open_until"`
+	// Trips - сколько раз предохранитель размыкался
+	Trips int64 `json:"trips"`
+}
+
+// CircuitBreaker - предохранитель на пути запросов в accrual, общий для всех обработчиков.
+// После threshold отказов подряд размыкается и не пропускает запросы cooldown, затем пропускает
+// один пробный: успех замыкает цепь, отказ снова размыкает ее на cooldown
+type CircuitBreaker struct {
+	sugar     *zap.SugaredLogger
+	threshold int
+	cooldown  time.Duration
+
+	mu    sync.Mutex
+	state BreakerState
+	// changed закрывается при каждой смене состояния, чтобы разбудить ждущих
+	changed chan struct{}
+}
+
+func NewCircuitBreaker(sugar *zap.SugaredLogger, threshold int, cooldown time.Duration) *CircuitBreaker {
+	return &CircuitBreaker{
+		sugar:     sugar,
+		threshold: threshold,
+		cooldown:  cooldown,
+		state:     BreakerState{State: BreakerClosed},
+		changed:   make(chan struct{}),
+	}
+}
+
+// Wait блокируется, пока предохранитель не пропустит запрос. В разомкнутом состоянии первый
+// дождавшийся cooldown становится пробным запросом, остальные ждут его результата
+func (b *CircuitBreaker) Wait(ctx context.Context) error {
+	for {
+		b.mu.Lock()
+		now := time.Now()
+		switch {
+		case b.state.State == BreakerClosed:
+			b.mu.Unlock()
+			return nil
+		case b.state.State == BreakerOpen && !now.Before(b.state.OpenUntil):
+			b.setState(BreakerHalfOpen)
+			b.mu.Unlock()
+			return nil
+		}
+		wait := b.state.OpenUntil.Sub(now)
+		changed := b.changed
+		b.mu.Unlock()
+
+		// В полуоткрытом состоянии ждем только результата пробного запроса
+		var (
+			timer   *time.Timer
+			timeout <-chan time.Time
+		)
+		if wait > 0 {
+			timer = time.NewTimer(wait)
+			timeout = timer.C
+		}
+		select {
+		case <-ctx.Done():
+		case <-changed:
+		case <-timeout:
+		}
+		if timer != nil {
+			timer.Stop()
+		}
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+	}
+}
+
+// Allow сообщает, сколько заказов выбрать для опроса: batch, пока цепь замкнута, один для пробного
+// запроса после cooldown и ни одного, пока цепь разомкнута или проба еще идет
+func (b *CircuitBreaker) Allow(batch int) int {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	switch {
+	case b.state.State == BreakerClosed:
+		return batch
+	case b.state.State == BreakerOpen && !time.Now().Before(b.state.OpenUntil):
+		return 1
+	}
+	return 0
+}
+
+// Success учитывает ответ accrual: любой ответ, кроме 5xx и сетевой ошибки, значит, что сервис жив
+func (b *CircuitBreaker) Success() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.state.Failures = 0
+	if b.state.State != BreakerClosed {
+		b.state.OpenUntil = time.Time{}
+		b.setState(BreakerClosed)
+		b.sugar.Infow("Accrual circuit closed, polling resumed")
+	}
+}
+
+// Failure учитывает отказ accrual
+func (b *CircuitBreaker) Failure() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.state.Failures++
+	if b.state.State == BreakerHalfOpen || (b.state.State == BreakerClosed && b.state.Failures >= b.threshold) {
+		b.state.Trips++
+		b.state.OpenUntil = time.Now().Add(b.cooldown)
+		b.setState(BreakerOpen)
+		b.sugar.Warnw("Accrual circuit opened, polling paused",
+			"failures", b.state.Failures,
+			"open_until", b.state.OpenUntil,
+			"trips", b.state.Trips,
+		)
+	}
+}
+
+// State возвращает копию текущего состояния
+func (b *CircuitBreaker) State() BreakerState {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.state
+}
+
+// setState вызывается под mu
+func (b *CircuitBreaker) setState(state string) {
+	b.state.State = state
+	close(b.changed)
+	b.changed = make(chan struct{})
+}
@@ -0,0 +1,74 @@
+package worker
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+)
+
+func TestCircuitBreaker(t *testing.T) {
+	ctx := context.Background()
+	b := NewCircuitBreaker(zap.NewNop().Sugar(), 3, 50*time.Millisecond)
+
+	// Отказы ниже порога и успех между ними цепь не размыкают
+	b.Failure()
+	b.Failure()
+	b.Success()
+	b.Failure()
+	b.Failure()
+	assert.Equal(t, BreakerClosed, b.State().State)
+	assert.Equal(t, 10, b.Allow(10))
+	require.NoError(t, b.Wait(ctx))
+
+	// Третий отказ подряд размыкает цепь: заказы не выбираются, запросы ждут
+	b.Failure()
+	state := b.State()
+	assert.Equal(t, BreakerOpen, state.State)
+	assert.Equal(t, int64(1), state.Trips)
+	assert.Zero(t, b.Allow(10))
+
+	// После cooldown выбирается один заказ для пробного запроса
+	require.Eventually(t, func() bool { return b.Allow(10) == 1 }, time.Second, 5*time.Millisecond)
+	require.NoError(t, b.Wait(ctx))
+	assert.Equal(t, BreakerHalfOpen, b.State().State)
+	assert.Zero(t, b.Allow(10))
+
+	// Пока идет проба, остальные запросы ждут
+	waited := make(chan error, 1)
+	go func() { waited <- b.Wait(ctx) }()
+	select {
+	case <-waited:
+		t.Fatal("second request passed while probe is in flight")
+	case <-time.After(20 * time.Millisecond):
+	}
+
+	// Неудачная проба снова размыкает цепь; ждущий запрос станет следующей пробой
+	b.Failure()
+	assert.Equal(t, BreakerOpen, b.State().State)
+	assert.Equal(t, int64(2), b.State().Trips)
+	select {
+	case err := <-waited:
+		require.NoError(t, err)
+	case <-time.After(time.Second):
+		t.Fatal("probe was not released after cooldown")
+	}
+	assert.Equal(t, BreakerHalfOpen, b.State().State)
+
+	// Удачная проба замыкает цепь
+	b.Success()
+	assert.Equal(t, BreakerClosed, b.State().State)
+	assert.Zero(t, b.State().Failures)
+	require.NoError(t, b.Wait(ctx))
+
+	// Ожидание прерывается отменой контекста
+	for i := 0; i < 3; i++ {
+		b.Failure()
+	}
+	cancelled, cancel := context.WithCancel(ctx)
+	cancel()
+	assert.ErrorIs(t, b.Wait(cancelled), context.Canceled)
+}
@@ -0,0 +1,88 @@
+package worker
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+// LimiterState - текущее состояние ограничителя для логов и метрик
+type LimiterState struct {
+	// PausedUntil - до этого момента запросы в accrual не отправляются
+	PausedUntil time.Time `json:"paused_until"`
+	// Limit - разрешенное число запросов в минуту, 0 - без ограничения
+	Limit int `json:"limit_per_minute"`
+	// Throttled - сколько раз accrual ответил 429
+	Throttled int64 `json:"throttled"`
+}
+
+// RateLimiter - общий для всех обработчиков ограничитель запросов в accrual.
+// После 429 останавливает все запросы до истечения Retry-After и дальше
+// равномерно распределяет запросы под лимит из тела ответа
+type RateLimiter struct {
+	sugar *zap.SugaredLogger
+
+	mu    sync.Mutex
+	state LimiterState
+	// next - раньше этого момента следующий запрос отправлять нельзя
+	next time.Time
+}
+
+func NewRateLimiter(sugar *zap.SugaredLogger) *RateLimiter {
+	return &RateLimiter{sugar: sugar}
+}
+
+// Wait блокируется, пока можно будет отправить запрос, и резервирует для него слот
+func (l *RateLimiter) Wait(ctx context.Context) error {
+	for {
+		l.mu.Lock()
+		now := time.Now()
+		wait := l.state.PausedUntil.Sub(now)
+		if wait <= 0 && l.state.Limit > 0 {
+			if now.Before(l.next) {
+				wait = l.next.Sub(now)
+			} else {
+				l.next = now.Add(time.Minute / time.Duration(l.state.Limit))
+			}
+		}
+		l.mu.Unlock()
+		if wait <= 0 {
+			return nil
+		}
+
+		timer := time.NewTimer(wait)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return ctx.Err()
+		case <-timer.C:
+		}
+	}
+}
+
+// Throttle учитывает ответ 429: ставит паузу на retryAfter и, если limit > 0, новый лимит в минуту
+func (l *RateLimiter) Throttle(retryAfter time.Duration, limit int) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.state.Throttled++
+	if until := time.Now().Add(retryAfter); until.After(l.state.PausedUntil) {
+		l.state.PausedUntil = until
+	}
+	if limit > 0 {
+		l.state.Limit = limit
+	}
+	l.sugar.Warnw("Accrual rate limit hit, pausing requests",
+		"paused_until", l.state.PausedUntil,
+		"limit_per_minute", l.state.Limit,
+		"throttled", l.state.Throttled,
+	)
+}
+
+// State возвращает копию текущего состояния
+func (l *RateLimiter) State() LimiterState {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.state
+}
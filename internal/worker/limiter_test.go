@@ -0,0 +1,42 @@
+package worker
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+)
+
+func TestRateLimiter(t *testing.T) {
+	ctx := context.Background()
+	l := NewRateLimiter(zap.NewNop().Sugar())
+
+	// Без ограничений Wait не ждет
+	start := time.Now()
+	for i := 0; i < 100; i++ {
+		require.NoError(t, l.Wait(ctx))
+	}
+	assert.Less(t, time.Since(start), 50*time.Millisecond)
+
+	// Пауза из Retry-After, затем 6000 запросов в минуту - не чаще раза в 10ms
+	l.Throttle(100*time.Millisecond, 6000)
+	start = time.Now()
+	for i := 0; i < 5; i++ {
+		require.NoError(t, l.Wait(ctx))
+	}
+	assert.GreaterOrEqual(t, time.Since(start), 140*time.Millisecond)
+
+	state := l.State()
+	assert.Equal(t, 6000, state.Limit)
+	assert.Equal(t, int64(1), state.Throttled)
+
+	// Ожидание прерывается отменой контекста
+	l.Throttle(time.Hour, 0)
+	cancelled, cancel := context.WithCancel(ctx)
+	cancel()
+	assert.ErrorIs(t, l.Wait(cancelled), context.Canceled)
+	assert.Equal(t, 6000, l.State().Limit)
+}
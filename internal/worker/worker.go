@@ -2,97 +2,390 @@ package worker
 
 import (
 	"context"
-	"encoding/json"
+	"errors"
 	"fmt"
-	"net/http"
-	"strconv"
+	"math/rand/v2"
+	"os"
+	"strings"
+	"sync"
 	"time"
 
+	"github.com/NailUsmanov/gophermart/internal/accrual"
+	"github.com/NailUsmanov/gophermart/internal/models"
+	"github.com/NailUsmanov/gophermart/internal/service"
 	"github.com/NailUsmanov/gophermart/internal/storage"
 	"go.uber.org/zap"
 )
 
+// Config - настройки опроса accrual. Все поля обязательны: значения по умолчанию задает pkg/config,
+// приложение передает их сюда
+type Config struct {
+	// PoolSize - сколько заказов опрашивается параллельно
+	PoolSize int
+	// Interval - период выборки заказов из хранилища
+	Interval time.Duration
+	// BatchSize - сколько заказов за один тик арендуется и отправляется в пул
+	BatchSize int
+	// Backoff - пауза после первого пустого ответа accrual (204, 5xx), дальше удваивается до MaxDelay
+	Backoff  time.Duration
+	MaxDelay time.Duration
+	// Lease - на сколько заказ закрепляется за экземпляром. Если экземпляр упал,
+	// по истечении аренды заказ заберет другой
+	Lease time.Duration
+	// BreakerThreshold - после стольких отказов accrual подряд (5xx, сетевые ошибки) опрос
+	// останавливается на BreakerCooldown, затем уходит один пробный запрос
+	BreakerThreshold int
+	BreakerCooldown  time.Duration
+	// MaxAttempts и MaxAge ограничивают опрос заказа: после MaxAttempts пустых ответов accrual подряд
+	// или через MaxAge после загрузки (или возврата в опрос) заказ переводится в STUCK и больше не опрашивается
+	MaxAttempts int
+	MaxAge      time.Duration
+}
+
 type Worker struct {
-	Storage     storage.Storage
-	Sugar       *zap.SugaredLogger
-	AccrualHost string
+	Storage storage.Storage
+	Sugar   *zap.SugaredLogger
+	Accrual accrual.Client
+	Config  Config
+	// Statuses проверяет переходы статусов заказа перед записью
+	Statuses *service.OrderStatuses
+	// InstanceID - владелец аренды заказов, уникален для каждого запущенного экземпляра
+	InstanceID string
+	// Limiter общий для всех обработчиков: 429 на одном заказе останавливает опрос всех
+	Limiter *RateLimiter
+	// Breaker останавливает опрос, пока accrual недоступен
+	Breaker *CircuitBreaker
+
+	mu sync.Mutex
+	// inFlight - заказы, которые сейчас опрашиваются; такой заказ не ставится в очередь второй раз
+	inFlight map[string]struct{}
+	// stop прекращает выбор новых заказов, abort обрывает запросы в работе
+	stop  context.CancelFunc
+	abort context.CancelFunc
+	// running - диспетчер и обработчики, которых дожидается Wait
+	running sync.WaitGroup
 }
 
-func NewWorker(storage storage.Storage, sugar *zap.SugaredLogger, acrrualHost string) *Worker {
+func NewWorker(storage storage.Storage, sugar *zap.SugaredLogger, client accrual.Client, cfg Config) *Worker {
 	return &Worker{
-		Storage:     storage,
-		Sugar:       sugar,
-		AccrualHost: acrrualHost,
+		Storage:    storage,
+		Sugar:      sugar,
+		Accrual:    client,
+		Config:     cfg,
+		Statuses:   service.NewOrderStatuses(storage, sugar),
+		InstanceID: newInstanceID(),
+		Limiter:    NewRateLimiter(sugar),
+		Breaker:    NewCircuitBreaker(sugar, cfg.BreakerThreshold, cfg.BreakerCooldown),
+		inFlight:   make(map[string]struct{}),
+	}
+}
+
+// newInstanceID - имя хоста плюс случайный суффикс, чтобы различать экземпляры на одной машине
+func newInstanceID() string {
+	host, err := os.Hostname()
+	if err != nil {
+		host = "gophermart"
 	}
+	return fmt.Sprintf("%s-%08x", host, rand.Uint32())
 }
 
-// Создаем метод StartWorker для фонового вызова воркера с проверкой статуса заказа из Аккруал хендлера
+// Start запускает в фоне диспетчер, который выбирает заказы для проверки в accrual сразу после
+// создания заказа и раз в Interval, и PoolSize обработчиков, которые забирают заказы из общего канала.
+// Отмена ctx равносильна Stop: новые заказы не выбираются, а начатые запросы дописываются
 func (w *Worker) Start(ctx context.Context) {
+	ctx, stop := context.WithCancel(ctx)
+	// Запросы в работе не обрываются вместе с ctx: их дожидается Wait
+	work, abort := context.WithCancel(context.WithoutCancel(ctx))
+	w.mu.Lock()
+	w.stop, w.abort = stop, abort
+	w.mu.Unlock()
+
+	jobs := make(chan storage.Order)
+	w.running.Add(w.Config.PoolSize + 1)
+	for i := 0; i < w.Config.PoolSize; i++ {
+		go func() {
+			defer w.running.Done()
+			w.fetch(ctx, work, jobs)
+		}()
+	}
+	// Без подписки на новые заказы воркер работает только по тикеру
+	created, err := w.Storage.NewOrders(ctx)
+	if err != nil {
+		w.Sugar.Errorf("Subscription to new orders failed, polling by ticker only: %v", err)
+	}
 	go func() {
-		ticker := time.NewTicker(5 * time.Second)
-		defer ticker.Stop()
+		defer w.running.Done()
+		w.dispatch(ctx, jobs, created)
+	}()
+}
+
+// Stop прекращает выбор новых заказов и не ждет; дождаться запросов в работе можно через Wait
+func (w *Worker) Stop() {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if w.stop != nil {
+		w.stop()
+	}
+}
+
+// Wait дожидается остановки воркера после Stop или отмены ctx из Start. Запросы в accrual, начатые
+// до остановки, дописываются; если ctx истек раньше, они обрываются, и Wait возвращает ошибку ctx
+func (w *Worker) Wait(ctx context.Context) error {
+	done := make(chan struct{})
+	go func() {
+		w.running.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+		w.Sugar.Info("Worker drained")
+		return nil
+	case <-ctx.Done():
+	}
+	w.mu.Lock()
+	if w.abort != nil {
+		w.abort()
+	}
+	w.mu.Unlock()
+	<-done
+	w.Sugar.Warnf("Worker drain interrupted: %v", ctx.Err())
+	return ctx.Err()
+}
+
+func (w *Worker) dispatch(ctx context.Context, jobs chan<- storage.Order, created <-chan string) {
+	defer close(jobs)
+	ticker := time.NewTicker(w.Config.Interval)
+	defer ticker.Stop()
 
-		for {
-			select {
-			case <-ctx.Done():
-				w.Sugar.Info("Worker stopped due to context cancellation")
+	for {
+		select {
+		case <-ctx.Done():
+			w.Sugar.Info("Worker stopped due to context cancellation")
+			return
+		case <-ticker.C:
+			w.tick(ctx, jobs)
+		case _, ok := <-created:
+			if !ok {
+				created = nil
+				continue
+			}
+			// Несколько заказов подряд забираем одним тиком
+			drain(created)
+			w.tick(ctx, jobs)
+		}
+	}
+}
+
+// drain вычитывает уже пришедшие уведомления, не дожидаясь новых
+func drain(created <-chan string) {
+	for {
+		select {
+		case _, ok := <-created:
+			if !ok {
 				return
-			case <-ticker.C:
-				orders, err := w.Storage.GetOrdersForAccrualUpdate(ctx)
-				w.Sugar.Infof(">>> Worker tick: found %d orders", len(orders))
-				if err != nil {
-					w.Sugar.Errorf("Method GetOrdersForAccrualUpdate has err: %v", err)
-					continue
-				}
-				for _, order := range orders {
-					// GET запрос в accrual систему: http://{accrualHost}/api/orders/{order.Number}
-					url := fmt.Sprintf("%s/api/orders/%s", w.AccrualHost, order.Number)
-					resp, err := http.Get(url)
-					if err != nil {
-						w.Sugar.Errorf("HTTP GET failed for %s: %v", url, err)
-						continue
-					}
-					func() {
-						defer resp.Body.Close()
-						// Обработка ответа
-						if resp.StatusCode == http.StatusNoContent {
-							return
-						}
-						// В случае, когда превышено количество запросов, ждем время,
-						// которое указно в хедере Retry - After
-						if resp.StatusCode == http.StatusTooManyRequests {
-							retryAfter := resp.Header.Get("Retry-After")
-							if sec, err := strconv.Atoi(retryAfter); err == nil && sec > 0 {
-								w.Sugar.Warnf("Too many requests, sleeping for %d seconds", sec)
-								time.Sleep(time.Duration(sec) * time.Second)
-							}
-							return
-						}
-						if resp.StatusCode != http.StatusOK {
-							w.Sugar.Warnf("Unexpected status from accrual: %d", resp.StatusCode)
-							return
-						}
-						// Создаем структуру аккруал, в которую дальше будем декодировать данные из тела ответа JSON
-						var accrualResp struct {
-							Order   string   `json:"order"`
-							Status  string   `json:"status"`
-							Accrual *float64 `json:"accrual,omitempty"`
-						}
-						if err := json.NewDecoder(resp.Body).Decode(&accrualResp); err != nil {
-							w.Sugar.Errorf("Failed to decode accrual response: %v", err)
-							return
-						}
-						// Вызываем метод для обновления данных
-						err = w.Storage.UpdateOrderStatus(ctx, accrualResp.Order, accrualResp.Status, accrualResp.Accrual)
-						if err != nil {
-							w.Sugar.Errorf("UpdateOrderStatus failed: %v", err)
-							return
-						}
-						w.Sugar.Infof("Updated order %s to %s", accrualResp.Order, accrualResp.Status)
-					}()
-				}
 			}
+		default:
+			return
 		}
-	}()
+	}
+}
+
+// tick арендует до BatchSize заказов и ставит в очередь те, которые еще не опрашиваются.
+// Пока пул занят, отправка блокируется, и следующий тик ждет окончания текущего
+func (w *Worker) tick(ctx context.Context, jobs chan<- storage.Order) {
+	// Пока accrual недоступен, заказы не выбираются, чтобы не держать их аренду
+	limit := w.Breaker.Allow(w.Config.BatchSize)
+	if limit == 0 {
+		return
+	}
+	orders, err := w.Storage.ClaimOrdersForAccrual(ctx, w.InstanceID, limit, w.Config.Lease)
+	if err != nil {
+		w.Sugar.Errorf("Method ClaimOrdersForAccrual has err: %v", err)
+		return
+	}
+	queued := 0
+	for i, order := range orders {
+		if !w.acquire(order.Number) {
+			continue
+		}
+		select {
+		case jobs <- order:
+			queued++
+		case <-ctx.Done():
+			w.release(order.Number)
+			// Остаток пачки этот экземпляр уже не опросит: отдаем его другим, не дожидаясь конца аренды
+			w.releaseSkipped(context.WithoutCancel(ctx), orders[i:])
+			return
+		}
+	}
+	w.Sugar.Infof("Worker tick: found %d orders, queued %d", len(orders), queued)
+}
+
+// releaseSkipped снимает аренду с заказов, которые воркер взял, но не опросил из-за остановки.
+// Заказы, которые как раз опрашиваются, не трогаем
+func (w *Worker) releaseSkipped(ctx context.Context, orders []storage.Order) {
+	for _, order := range orders {
+		if !w.acquire(order.Number) {
+			continue
+		}
+		w.releaseLease(ctx, order.Number)
+		w.release(order.Number)
+	}
+}
+
+func (w *Worker) releaseLease(ctx context.Context, number string) {
+	if err := w.Storage.ReleaseOrderLease(ctx, w.InstanceID, number); err != nil {
+		w.Sugar.Errorf("Method ReleaseOrderLease has err for order %s: %v", number, err)
+	}
+}
+
+// fetch обрабатывает заказы из jobs, пока диспетчер не закроет канал. Отмена ctx останавливает
+// только новые запросы; сами запросы и запись результата идут с work
+func (w *Worker) fetch(ctx, work context.Context, jobs <-chan storage.Order) {
+	for order := range jobs {
+		w.processOrder(ctx, work, order)
+		w.release(order.Number)
+	}
+}
+
+// acquire помечает заказ как опрашиваемый; false - он уже в работе
+func (w *Worker) acquire(number string) bool {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if _, ok := w.inFlight[number]; ok {
+		return false
+	}
+	w.inFlight[number] = struct{}{}
+	return true
+}
+
+func (w *Worker) release(number string) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	delete(w.inFlight, number)
+}
+
+// processOrder запрашивает статус заказа в accrual и сохраняет его.
+// После 429 заказ не пропускается: запрос повторяется, когда ограничитель снова разрешит.
+// После остановки новый запрос не начинается, а аренда снимается, чтобы заказ сразу забрал другой экземпляр
+func (w *Worker) processOrder(ctx, work context.Context, order storage.Order) {
+	if time.Since(order.QueuedAt) > w.Config.MaxAge {
+		w.markStuck(work, order, "max age exceeded")
+		return
+	}
+	for {
+		if w.Breaker.Wait(ctx) != nil || w.Limiter.Wait(ctx) != nil || ctx.Err() != nil {
+			w.releaseLease(work, order.Number)
+			return
+		}
+		if !w.pollOrder(work, order) {
+			return
+		}
+	}
+}
+
+// pollOrder выполняет один запрос в accrual; true - получили 429 и запрос надо повторить
+func (w *Worker) pollOrder(ctx context.Context, order storage.Order) (retry bool) {
+	resp, err := w.Accrual.GetOrder(ctx, order.Number)
+	// Остановку сервиса отказом accrual не считаем
+	if ctx.Err() == nil {
+		if accrualDown(err) {
+			w.Breaker.Failure()
+		} else {
+			w.Breaker.Success()
+		}
+	}
+	var rateLimited *accrual.RateLimitError
+	switch {
+	case errors.As(err, &rateLimited):
+		// Ставим паузу из Retry-After всем обработчикам и подстраиваемся под лимит из ответа.
+		// Заказ тут ни при чем, поэтому попытку ему не засчитываем
+		w.Limiter.Throttle(rateLimited.RetryAfter, rateLimited.Limit)
+		return true
+	case errors.Is(err, accrual.ErrNotRegistered):
+		w.postpone(ctx, order)
+		return false
+	case errors.Is(err, accrual.ErrInvalidResponse):
+		w.quarantine(ctx, order, resp.Raw, err)
+		w.postpone(ctx, order)
+		return false
+	case err != nil:
+		w.Sugar.Errorf("Accrual request for order %s failed: %v", order.Number, err)
+		w.postpone(ctx, order)
+		return false
+	}
+	// Ответ про другой заказ или с неправдоподобным начислением не применяем
+	if err := resp.Validate(order.Number); err != nil {
+		w.quarantine(ctx, order, resp.Raw, err)
+		w.postpone(ctx, order)
+		return false
+	}
+	// Статус меняется только через автомат статусов
+	changed, err := w.Statuses.ApplyAccrual(ctx, order, string(resp.Status), resp.Accrual)
+	if err != nil {
+		w.Sugar.Errorf("Order %s status update failed: %v", order.Number, err)
+		return false
+	}
+	// accrual еще считает заказ: следующая проверка откладывается так же, как после 204
+	if !changed {
+		w.postpone(ctx, order)
+		return false
+	}
+	w.Sugar.Infof("Updated order %s to %s", order.Number, resp.Status)
+	return false
+}
+
+// accrualDown сообщает, что ошибка говорит о недоступности accrual: 5xx, таймаут или сетевая ошибка.
+// 204, 429 и неразборчивый ответ приходят от работающего сервиса
+func accrualDown(err error) bool {
+	var rateLimited *accrual.RateLimitError
+	switch {
+	case err == nil, errors.Is(err, accrual.ErrNotRegistered), errors.Is(err, accrual.ErrInvalidResponse),
+		errors.As(err, &rateLimited):
+		return false
+	}
+	return true
+}
+
+func (w *Worker) markStuck(ctx context.Context, order storage.Order, reason string) {
+	if err := w.Statuses.MarkStuck(ctx, order, reason); err != nil {
+		w.Sugar.Errorf("MarkStuck failed for order %s: %v", order.Number, err)
+	}
+}
+
+// quarantine сохраняет подозрительный ответ accrual для разбора оператором. Статус заказа не меняется
+func (w *Worker) quarantine(ctx context.Context, order storage.Order, raw []byte, reason error) {
+	w.Sugar.Warnw("Accrual response quarantined", "order", order.Number, "reason", reason)
+	q := models.QuarantinedResponse{
+		OrderNumber: order.Number,
+		Reason:      reason.Error(),
+		// TEXT в PostgreSQL не примет битый UTF-8
+		Response: strings.ToValidUTF8(string(raw), "\uFFFD"),
+	}
+	if err := w.Storage.QuarantineAccrualResponse(ctx, q); err != nil {
+		w.Sugar.Errorf("QuarantineAccrualResponse failed for order %s: %v", order.Number, err)
+	}
+}
+
+// postpone откладывает следующую проверку заказа с экспоненциально растущей паузой.
+// Последняя разрешенная попытка снимает заказ с опроса
+func (w *Worker) postpone(ctx context.Context, order storage.Order) {
+	if order.Attempts+1 >= w.Config.MaxAttempts {
+		w.markStuck(ctx, order, "max attempts exceeded")
+		return
+	}
+	delay := Backoff(order.Attempts, w.Config.Backoff, w.Config.MaxDelay)
+	if err := w.Storage.ScheduleNextCheck(ctx, order.Number, delay); err != nil {
+		w.Sugar.Errorf("ScheduleNextCheck failed for order %s: %v", order.Number, err)
+	}
+}
+
+// Health - состояние опроса accrual для проверки здоровья сервиса
+type Health struct {
+	Breaker   BreakerState `json:"breaker"`
+	RateLimit LimiterState `json:"rate_limit"`
+}
 
+func (w *Worker) Health() Health {
+	return Health{Breaker: w.Breaker.State(), RateLimit: w.Limiter.State()}
 }
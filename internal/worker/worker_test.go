@@ -0,0 +1,508 @@
+package worker_test
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/NailUsmanov/gophermart/internal/accrual"
+	"github.com/NailUsmanov/gophermart/internal/accrual/accrualtest"
+	"github.com/NailUsmanov/gophermart/internal/models"
+	"github.com/NailUsmanov/gophermart/internal/money"
+	"github.com/NailUsmanov/gophermart/internal/storage"
+	"github.com/NailUsmanov/gophermart/internal/worker"
+	"github.com/NailUsmanov/gophermart/pkg/config"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+)
+
+// withDefaults дополняет настройки теста значениями по умолчанию из pkg/config, как их передает приложение
+func withDefaults(c worker.Config) worker.Config {
+	var d config.Config
+	d.SetDefaults()
+	if c.PoolSize == 0 {
+		c.PoolSize = d.AccrualWorkers
+	}
+	if c.Interval == 0 {
+		c.Interval = d.AccrualPollInterval
+	}
+	if c.BatchSize == 0 {
+		c.BatchSize = d.AccrualBatchSize
+	}
+	if c.Backoff == 0 {
+		c.Backoff = d.AccrualBackoff
+	}
+	if c.MaxDelay == 0 {
+		c.MaxDelay = d.AccrualMaxDelay
+	}
+	if c.Lease == 0 {
+		c.Lease = d.AccrualLease
+	}
+	if c.BreakerThreshold == 0 {
+		c.BreakerThreshold = d.AccrualBreakerThreshold
+	}
+	if c.BreakerCooldown == 0 {
+		c.BreakerCooldown = d.AccrualBreakerCooldown
+	}
+	if c.MaxAttempts == 0 {
+		c.MaxAttempts = d.AccrualMaxAttempts
+	}
+	if c.MaxAge == 0 {
+		c.MaxAge = d.AccrualMaxAge
+	}
+	return c
+}
+
+// newOrders заводит пользователя с count новыми заказами и возвращает их номера
+func newOrders(t *testing.T, s storage.Storage, count int) (int, []string) {
+	t.Helper()
+	ctx := context.Background()
+	require.NoError(t, s.Registration(ctx, "user", "hash"))
+	userID, err := s.GetUserIDByLogin(ctx, "user")
+	require.NoError(t, err)
+	numbers := make([]string, 0, count)
+	for i := 0; i < count; i++ {
+		number := fmt.Sprintf("%d", 1000+i)
+		require.NoError(t, s.CreateNewOrder(ctx, userID, number, zap.NewNop().Sugar()))
+		numbers = append(numbers, number)
+	}
+	return userID, numbers
+}
+
+// waitProcessed ждет, пока все заказы пользователя не получат окончательный статус
+func waitProcessed(t *testing.T, s storage.Storage, userID int) {
+	t.Helper()
+	require.Eventually(t, func() bool {
+		orders, err := s.GetOrdersByUserID(context.Background(), userID)
+		if err != nil {
+			return false
+		}
+		for _, o := range orders {
+			if *o.Status != "PROCESSED" && *o.Status != "INVALID" {
+				return false
+			}
+		}
+		return true
+	}, 10*time.Second, 10*time.Millisecond)
+}
+
+func TestWorkerPool(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	s := storage.NewMemStorage()
+	userID, numbers := newOrders(t, s, 20)
+
+	srv := accrualtest.NewServer()
+	defer srv.Close()
+	srv.SetDelay(20 * time.Millisecond)
+	// Первые два опроса заказа отвечают PROCESSING, дальше - PROCESSED
+	for _, n := range numbers {
+		srv.SetOrder(n, accrualtest.Registered(), accrualtest.Processing(), accrualtest.Processed("1.5"))
+	}
+
+	const poolSize = 3
+	w := worker.NewWorker(s, zap.NewNop().Sugar(), accrual.NewClient(srv.URL, time.Second), withDefaults(worker.Config{
+		PoolSize:  poolSize,
+		Interval:  5 * time.Millisecond,
+		BatchSize: 7,
+	}))
+	w.Start(ctx)
+	waitProcessed(t, s, userID)
+
+	assert.Zero(t, srv.Overlaps(), "order polled twice at the same time")
+	assert.LessOrEqual(t, srv.MaxConcurrent(), poolSize)
+
+	current, _, err := s.GetUserBalance(ctx, userID)
+	require.NoError(t, err)
+	assert.Equal(t, money.Amount(len(numbers)*150), current)
+}
+
+func TestWorkerReplicas(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	s := storage.NewMemStorage()
+	userID, numbers := newOrders(t, s, 20)
+
+	srv := accrualtest.NewServer()
+	defer srv.Close()
+	srv.SetDelay(20 * time.Millisecond)
+	for _, n := range numbers {
+		srv.SetOrder(n, accrualtest.Processed("1"))
+	}
+
+	// Два экземпляра на одном хранилище делят заказы через аренду и не опрашивают один заказ дважды
+	for i := 0; i < 2; i++ {
+		w := worker.NewWorker(s, zap.NewNop().Sugar(), accrual.NewClient(srv.URL, time.Second), withDefaults(worker.Config{
+			Interval:  5 * time.Millisecond,
+			BatchSize: 3,
+		}))
+		w.Start(ctx)
+	}
+	waitProcessed(t, s, userID)
+
+	assert.Len(t, srv.Requests(), len(numbers))
+	assert.Zero(t, srv.Overlaps())
+}
+
+func TestWorkerNewOrderWakeup(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	s := storage.NewMemStorage()
+
+	srv := accrualtest.NewServer()
+	defer srv.Close()
+	srv.SetOrder("1000", accrualtest.Processing())
+
+	// Тикер не успеет сработать: заказ должен забрать уведомление о создании
+	w := worker.NewWorker(s, zap.NewNop().Sugar(), accrual.NewClient(srv.URL, time.Second), withDefaults(worker.Config{
+		Interval: time.Hour,
+	}))
+	w.Start(ctx)
+	userID, _ := newOrders(t, s, 1)
+
+	require.Eventually(t, func() bool {
+		orders, err := s.GetOrdersByUserID(ctx, userID)
+		return err == nil && len(orders) == 1 && *orders[0].Status == "PROCESSING"
+	}, 2*time.Second, 10*time.Millisecond)
+}
+
+func TestWorkerRetryAfter(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	s := storage.NewMemStorage()
+	userID, numbers := newOrders(t, s, 5)
+
+	srv := accrualtest.NewServer()
+	defer srv.Close()
+	for _, n := range numbers {
+		srv.SetOrder(n, accrualtest.Processed("1"))
+	}
+	srv.Throttle("1", 600)
+
+	w := worker.NewWorker(s, zap.NewNop().Sugar(), accrual.NewClient(srv.URL, time.Second), withDefaults(worker.Config{
+		PoolSize: 3,
+		Interval: 5 * time.Millisecond,
+	}))
+	w.Start(ctx)
+	waitProcessed(t, s, userID)
+
+	// Первый запрос получил 429: до конца паузы не должно прийти ни одного нового запроса.
+	// Запросы, отправленные одновременно с первым, уже в пути - их пропускаем
+	requests := srv.Requests()
+	require.NotEmpty(t, requests)
+	throttledAt := requests[0].At
+	for _, r := range requests[1:] {
+		if r.At.After(throttledAt.Add(200 * time.Millisecond)) {
+			assert.False(t, r.At.Before(throttledAt.Add(time.Second)), "request sent before Retry-After expired")
+		}
+	}
+	state := w.Limiter.State()
+	assert.Equal(t, 600, state.Limit)
+	assert.Equal(t, int64(1), state.Throttled)
+}
+
+func TestWorkerBackoff(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	s := storage.NewMemStorage()
+	_, numbers := newOrders(t, s, 2)
+
+	srv := accrualtest.NewServer()
+	defer srv.Close()
+	// Первый заказ accrual не знает (204), второй падает с 500
+	srv.SetOrder(numbers[1], accrualtest.Failure(http.StatusInternalServerError))
+
+	w := worker.NewWorker(s, zap.NewNop().Sugar(), accrual.NewClient(srv.URL, time.Second), withDefaults(worker.Config{
+		Interval: 5 * time.Millisecond,
+		Backoff:  time.Hour,
+	}))
+	w.Start(ctx)
+
+	// После неудачного опроса заказы откладываются и больше не запрашиваются
+	require.Eventually(t, func() bool { return len(srv.Requests()) >= 2 }, 10*time.Second, 10*time.Millisecond)
+	time.Sleep(50 * time.Millisecond)
+	assert.Len(t, srv.Requests(), 2)
+
+	orders, err := s.GetOrdersByUserID(ctx, 1)
+	require.NoError(t, err)
+	for _, o := range orders {
+		assert.Equal(t, "NEW", *o.Status)
+	}
+}
+
+func TestWorkerQuarantine(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	s := storage.NewMemStorage()
+	userID, numbers := newOrders(t, s, 3)
+
+	srv := accrualtest.NewServer()
+	defer srv.Close()
+	// Ответ про чужой заказ, отрицательное начисление и начисление у INVALID
+	srv.SetOrder(numbers[0], accrualtest.Response{Status: accrual.StatusProcessed, Accrual: "500", Number: "79927398713"})
+	srv.SetOrder(numbers[1], accrualtest.Processed("-10"))
+	srv.SetOrder(numbers[2], accrualtest.Response{Status: accrual.StatusInvalid, Accrual: "10"})
+
+	w := worker.NewWorker(s, zap.NewNop().Sugar(), accrual.NewClient(srv.URL, time.Second), withDefaults(worker.Config{
+		Interval: 5 * time.Millisecond,
+		Backoff:  time.Hour,
+	}))
+	w.Start(ctx)
+
+	var responses []models.QuarantinedResponse
+	require.Eventually(t, func() bool {
+		var err error
+		responses, err = s.ListQuarantinedResponses(ctx, 10)
+		return err == nil && len(responses) == len(numbers)
+	}, 5*time.Second, 10*time.Millisecond)
+	quarantined := make([]string, 0, len(responses))
+	for _, q := range responses {
+		quarantined = append(quarantined, q.OrderNumber)
+		assert.NotEmpty(t, q.Reason)
+		assert.Contains(t, q.Response, "status")
+	}
+	assert.ElementsMatch(t, numbers, quarantined)
+
+	// Ни один заказ не изменился, баллы не начислены
+	orders, err := s.GetOrdersByUserID(ctx, userID)
+	require.NoError(t, err)
+	for _, o := range orders {
+		assert.Equal(t, "NEW", *o.Status)
+	}
+	current, _, err := s.GetUserBalance(ctx, userID)
+	require.NoError(t, err)
+	assert.Zero(t, current)
+}
+
+func TestWorkerCircuitBreaker(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	s := storage.NewMemStorage()
+	userID, numbers := newOrders(t, s, 10)
+
+	srv := accrualtest.NewServer()
+	defer srv.Close()
+	for _, n := range numbers {
+		srv.SetOrder(n, accrualtest.Failure(http.StatusInternalServerError))
+	}
+
+	w := worker.NewWorker(s, zap.NewNop().Sugar(), accrual.NewClient(srv.URL, time.Second), withDefaults(worker.Config{
+		PoolSize:         1,
+		Interval:         5 * time.Millisecond,
+		Backoff:          time.Millisecond,
+		MaxDelay:         time.Millisecond,
+		BreakerThreshold: 3,
+		BreakerCooldown:  200 * time.Millisecond,
+	}))
+	w.Start(ctx)
+
+	// После трех отказов подряд опрос останавливается до конца cooldown
+	require.Eventually(t, func() bool {
+		return w.Health().Breaker.State == worker.BreakerOpen
+	}, 5*time.Second, time.Millisecond)
+	time.Sleep(100 * time.Millisecond)
+	assert.Len(t, srv.Requests(), 3)
+
+	// accrual поднялся: пробный запрос замыкает цепь, и заказы обрабатываются
+	for _, n := range numbers {
+		srv.SetOrder(n, accrualtest.Processed("1"))
+	}
+	waitProcessed(t, s, userID)
+	state := w.Health().Breaker
+	assert.Equal(t, worker.BreakerClosed, state.State)
+	assert.Equal(t, int64(1), state.Trips)
+}
+
+func TestWorkerStuck(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	s := storage.NewMemStorage()
+	userID, numbers := newOrders(t, s, 1)
+
+	// accrual так и не узнает о заказе
+	srv := accrualtest.NewServer()
+	defer srv.Close()
+
+	w := worker.NewWorker(s, zap.NewNop().Sugar(), accrual.NewClient(srv.URL, time.Second), withDefaults(worker.Config{
+		Interval:    5 * time.Millisecond,
+		Backoff:     time.Millisecond,
+		MaxDelay:    time.Millisecond,
+		MaxAttempts: 3,
+	}))
+	w.Start(ctx)
+
+	require.Eventually(t, func() bool {
+		stuck, err := s.ListStuckOrders(ctx, 10)
+		return err == nil && len(stuck) == 1
+	}, 5*time.Second, 5*time.Millisecond)
+	time.Sleep(50 * time.Millisecond)
+	assert.Len(t, srv.Requests(), 3)
+
+	// Заказ снят с опроса, начислений нет
+	orders, err := s.GetOrdersByUserID(ctx, userID)
+	require.NoError(t, err)
+	assert.Equal(t, "STUCK", *orders[0].Status)
+
+	// Оператор вернул заказ в опрос, и accrual наконец ответил
+	srv.SetOrder(numbers[0], accrualtest.Processed("5"))
+	require.NoError(t, w.Statuses.Requeue(ctx, numbers[0]))
+	waitProcessed(t, s, userID)
+}
+
+func TestWorkerStillProcessing(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	s := storage.NewMemStorage()
+	_, numbers := newOrders(t, s, 1)
+
+	// accrual считает заказ бесконечно долго
+	srv := accrualtest.NewServer()
+	defer srv.Close()
+	srv.SetOrder(numbers[0], accrualtest.Processing())
+
+	w := worker.NewWorker(s, zap.NewNop().Sugar(), accrual.NewClient(srv.URL, time.Second), withDefaults(worker.Config{
+		Interval:    5 * time.Millisecond,
+		Backoff:     time.Millisecond,
+		MaxDelay:    time.Millisecond,
+		MaxAttempts: 3,
+	}))
+	w.Start(ctx)
+
+	// Повторный PROCESSING не переход: опрос откладывается, попытки копятся, и заказ застревает
+	require.Eventually(t, func() bool {
+		stuck, err := s.ListStuckOrders(ctx, 10)
+		return err == nil && len(stuck) == 1
+	}, 5*time.Second, 5*time.Millisecond)
+	time.Sleep(50 * time.Millisecond)
+	assert.Len(t, srv.Requests(), 4)
+
+	history, err := s.GetOrderStatusHistory(ctx, numbers[0])
+	require.NoError(t, err)
+	require.Len(t, history, 2)
+	assert.Equal(t, [2]string{"NEW", "PROCESSING"}, [2]string{history[0].From, history[0].To})
+	assert.Equal(t, [2]string{"PROCESSING", "STUCK"}, [2]string{history[1].From, history[1].To})
+}
+
+func TestWorkerStuckMaxAge(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	s := storage.NewMemStorage()
+	userID, numbers := newOrders(t, s, 1)
+
+	srv := accrualtest.NewServer()
+	defer srv.Close()
+	srv.SetOrder(numbers[0], accrualtest.Processed("5"))
+
+	// Заказ пролежал дольше MaxAge до первого опроса
+	const maxAge = 200 * time.Millisecond
+	time.Sleep(maxAge + 50*time.Millisecond)
+	w := worker.NewWorker(s, zap.NewNop().Sugar(), accrual.NewClient(srv.URL, time.Second), withDefaults(worker.Config{
+		Interval: 5 * time.Millisecond,
+		MaxAge:   maxAge,
+	}))
+	w.Start(ctx)
+
+	require.Eventually(t, func() bool {
+		stuck, err := s.ListStuckOrders(ctx, 10)
+		return err == nil && len(stuck) == 1
+	}, 5*time.Second, 5*time.Millisecond)
+	assert.Empty(t, srv.Requests())
+
+	// После возврата в опрос возраст считается заново: заказ опрашивается, а не снова уходит в STUCK
+	require.NoError(t, w.Statuses.Requeue(ctx, numbers[0]))
+	waitProcessed(t, s, userID)
+	assert.Len(t, srv.Requests(), 1)
+}
+
+func TestWorkerDrain(t *testing.T) {
+	ctx := context.Background()
+	s := storage.NewMemStorage()
+	userID, numbers := newOrders(t, s, 1)
+
+	srv := accrualtest.NewServer()
+	defer srv.Close()
+	srv.SetDelay(200 * time.Millisecond)
+	srv.SetOrder(numbers[0], accrualtest.Processed("3"))
+
+	w := worker.NewWorker(s, zap.NewNop().Sugar(), accrual.NewClient(srv.URL, time.Second), withDefaults(worker.Config{
+		Interval: 5 * time.Millisecond,
+	}))
+	w.Start(ctx)
+	require.Eventually(t, func() bool { return len(srv.Requests()) == 1 }, 5*time.Second, time.Millisecond)
+
+	// Остановка во время запроса: ответ accrual дожидается и сохраняется
+	w.Stop()
+	drainCtx, cancel := context.WithTimeout(ctx, 5*time.Second)
+	defer cancel()
+	require.NoError(t, w.Wait(drainCtx))
+	orders, err := s.GetOrdersByUserID(ctx, userID)
+	require.NoError(t, err)
+	assert.Equal(t, "PROCESSED", *orders[0].Status)
+
+	// Остановленный воркер не берет новые заказы
+	require.NoError(t, s.CreateNewOrder(ctx, userID, "2000", zap.NewNop().Sugar()))
+	time.Sleep(50 * time.Millisecond)
+	assert.Len(t, srv.Requests(), 1)
+}
+
+func TestWorkerDrainReleasesLeases(t *testing.T) {
+	ctx := context.Background()
+	s := storage.NewMemStorage()
+	userID, numbers := newOrders(t, s, 3)
+
+	srv := accrualtest.NewServer()
+	defer srv.Close()
+	srv.SetDelay(200 * time.Millisecond)
+	for _, n := range numbers {
+		srv.SetOrder(n, accrualtest.Processed("1"))
+	}
+
+	// Один обработчик занят первым заказом, остальные заказы пачки ждут в диспетчере
+	cfg := withDefaults(worker.Config{PoolSize: 1, Interval: 5 * time.Millisecond, Lease: time.Hour})
+	first := worker.NewWorker(s, zap.NewNop().Sugar(), accrual.NewClient(srv.URL, time.Second), cfg)
+	first.Start(ctx)
+	require.Eventually(t, func() bool { return len(srv.Requests()) == 1 }, 5*time.Second, time.Millisecond)
+	first.Stop()
+	drainCtx, cancel := context.WithTimeout(ctx, 5*time.Second)
+	defer cancel()
+	require.NoError(t, first.Wait(drainCtx))
+	assert.Len(t, srv.Requests(), 1)
+
+	// Перезапущенный экземпляр забирает неопрошенные заказы сразу, а не через Lease
+	restarted := worker.NewWorker(s, zap.NewNop().Sugar(), accrual.NewClient(srv.URL, time.Second), cfg)
+	restarted.Start(ctx)
+	defer restarted.Stop()
+	waitProcessed(t, s, userID)
+	assert.Len(t, srv.Requests(), 3)
+}
+
+func TestWorkerDrainDeadline(t *testing.T) {
+	ctx := context.Background()
+	s := storage.NewMemStorage()
+	userID, numbers := newOrders(t, s, 1)
+
+	srv := accrualtest.NewServer()
+	defer srv.Close()
+	srv.SetDelay(500 * time.Millisecond)
+	srv.SetOrder(numbers[0], accrualtest.Processed("3"))
+
+	w := worker.NewWorker(s, zap.NewNop().Sugar(), accrual.NewClient(srv.URL, time.Second), withDefaults(worker.Config{
+		Interval: 5 * time.Millisecond,
+	}))
+	w.Start(ctx)
+	require.Eventually(t, func() bool { return len(srv.Requests()) == 1 }, 5*time.Second, time.Millisecond)
+
+	// Запрос не успевает до срока: он обрывается, заказ остается необработанным
+	w.Stop()
+	drainCtx, cancel := context.WithTimeout(ctx, 50*time.Millisecond)
+	defer cancel()
+	start := time.Now()
+	assert.ErrorIs(t, w.Wait(drainCtx), context.DeadlineExceeded)
+	assert.Less(t, time.Since(start), 400*time.Millisecond)
+	orders, err := s.GetOrdersByUserID(ctx, userID)
+	require.NoError(t, err)
+	assert.Equal(t, "NEW", *orders[0].Status)
+}
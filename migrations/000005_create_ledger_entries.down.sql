@@ -0,0 +1,8 @@
+-- Возвращаем списания в orders в старом формате
+INSERT INTO orders (user_id, order_number, accrual, status, uploaded_at)
+SELECT user_id, order_number, amount, 'WITHDRAWN', created_at
+FROM ledger_entries
+WHERE entry_type = 'DEBIT'
+ON CONFLICT (order_number) DO NOTHING;
+
+DROP TABLE IF EXISTS ledger_entries;
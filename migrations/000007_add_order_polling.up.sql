@@ -0,0 +1,6 @@
+-- Планирование опроса accrual: next_check_at - не проверять заказ раньше этого момента (NULL - проверить сразу),
+-- attempts - сколько раз подряд accrual не дал ответа по заказу (204, 5xx)
+ALTER TABLE orders ADD COLUMN next_check_at TIMESTAMP;
+ALTER TABLE orders ADD COLUMN attempts INTEGER NOT NULL DEFAULT 0;
+
+CREATE INDEX orders_poll_idx ON orders (uploaded_at) WHERE status IN ('NEW', 'PROCESSING', 'REGISTERED');
@@ -0,0 +1,4 @@
+-- Аренда заказа экземпляром воркера: пока lease_expires_at не наступил, другие экземпляры заказ не опрашивают.
+-- Аренда упавшего экземпляра истекает сама, и заказ забирает другой
+ALTER TABLE orders ADD COLUMN lease_owner TEXT;
+ALTER TABLE orders ADD COLUMN lease_expires_at TIMESTAMP;
@@ -0,0 +1,14 @@
+-- REGISTERED - статус accrual, у заказа он хранится как PROCESSING
+UPDATE orders SET status = 'PROCESSING' WHERE status = 'REGISTERED';
+
+-- История переходов заказа между статусами: кто и когда перевел
+CREATE TABLE order_status_history (
+    id BIGSERIAL PRIMARY KEY,
+    order_number TEXT NOT NULL REFERENCES orders(order_number),
+    from_status TEXT NOT NULL,
+    to_status TEXT NOT NULL,
+    source TEXT NOT NULL,
+    changed_at TIMESTAMP NOT NULL DEFAULT now()
+);
+
+CREATE INDEX order_status_history_order_idx ON order_status_history (order_number, id);
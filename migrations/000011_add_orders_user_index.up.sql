@@ -0,0 +1,2 @@
+-- Постраничный список заказов пользователя: от новых к старым, id различает заказы с одинаковым временем
+CREATE INDEX orders_user_uploaded_idx ON orders (user_id, uploaded_at DESC, id DESC);
@@ -0,0 +1,13 @@
+-- Ответы на запросы с Idempotency-Key: повтор запроса с тем же ключом получает сохраненный ответ.
+-- status IS NULL - первый запрос еще выполняется
+CREATE TABLE idempotency_keys (
+    user_id INTEGER NOT NULL REFERENCES personal_account(id),
+    idem_key TEXT NOT NULL,
+    fingerprint TEXT NOT NULL,
+    status INTEGER,
+    content_type TEXT,
+    body BYTEA,
+    created_at TIMESTAMP NOT NULL DEFAULT now(),
+    expires_at TIMESTAMP NOT NULL,
+    PRIMARY KEY (user_id, idem_key)
+);
@@ -0,0 +1,3 @@
+-- queued_at - когда оператор последний раз вернул заказ в опрос; NULL - заказ опрашивается с загрузки.
+-- От этого момента воркер отсчитывает ACCRUAL_MAX_AGE
+ALTER TABLE orders ADD COLUMN queued_at TIMESTAMP;
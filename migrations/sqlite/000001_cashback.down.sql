@@ -0,0 +1 @@
+DROP TABLE IF EXISTS personal_account;
@@ -0,0 +1,10 @@
+DROP TABLE IF EXISTS orders;
+
+CREATE TABLE orders (
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    order_number TEXT NOT NULL UNIQUE,
+    user_id INTEGER NOT NULL REFERENCES personal_account(id),
+    status TEXT,
+    accrual INTEGER,
+    uploaded_at TIMESTAMP DEFAULT (strftime('%Y-%m-%d %H:%M:%f', 'now'))
+);
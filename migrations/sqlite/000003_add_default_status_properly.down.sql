@@ -0,0 +1,12 @@
+CREATE TABLE orders_old (
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    order_number TEXT NOT NULL UNIQUE,
+    user_id INTEGER NOT NULL REFERENCES personal_account(id),
+    status TEXT,
+    accrual INTEGER,
+    uploaded_at TIMESTAMP DEFAULT (strftime('%Y-%m-%d %H:%M:%f', 'now'))
+);
+INSERT INTO orders_old (id, order_number, user_id, status, accrual, uploaded_at)
+SELECT id, order_number, user_id, status, accrual, uploaded_at FROM orders;
+DROP TABLE orders;
+ALTER TABLE orders_old RENAME TO orders;
@@ -0,0 +1,13 @@
+-- SQLite не умеет менять DEFAULT у колонки, поэтому пересоздаем таблицу
+CREATE TABLE orders_new (
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    order_number TEXT NOT NULL UNIQUE,
+    user_id INTEGER NOT NULL REFERENCES personal_account(id),
+    status TEXT DEFAULT 'NEW',
+    accrual INTEGER,
+    uploaded_at TIMESTAMP DEFAULT (strftime('%Y-%m-%d %H:%M:%f', 'now'))
+);
+INSERT INTO orders_new (id, order_number, user_id, status, accrual, uploaded_at)
+SELECT id, order_number, user_id, COALESCE(status, 'NEW'), accrual, uploaded_at FROM orders;
+DROP TABLE orders;
+ALTER TABLE orders_new RENAME TO orders;
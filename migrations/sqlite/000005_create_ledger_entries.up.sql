@@ -0,0 +1,29 @@
+-- Движения баллов: CREDIT - начисление за обработанный заказ, DEBIT - списание.
+-- source + order_number ссылаются на источник движения и не дают провести его дважды.
+-- amount - в сотых долях балла
+CREATE TABLE ledger_entries (
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    user_id INTEGER NOT NULL REFERENCES personal_account(id),
+    entry_type TEXT NOT NULL CHECK (entry_type IN ('CREDIT', 'DEBIT')),
+    source TEXT NOT NULL CHECK (source IN ('ACCRUAL', 'WITHDRAWAL')),
+    order_number TEXT NOT NULL,
+    amount INTEGER NOT NULL CHECK (amount >= 0),
+    created_at TIMESTAMP NOT NULL DEFAULT (strftime('%Y-%m-%d %H:%M:%f', 'now')),
+    UNIQUE (source, order_number)
+);
+
+CREATE INDEX ledger_entries_user_id_idx ON ledger_entries (user_id, entry_type, created_at DESC);
+
+-- Переносим уже начисленные баллы
+INSERT INTO ledger_entries (user_id, entry_type, source, order_number, amount, created_at)
+SELECT user_id, 'CREDIT', 'ACCRUAL', order_number, accrual, COALESCE(uploaded_at, strftime('%Y-%m-%d %H:%M:%f', 'now'))
+FROM orders
+WHERE status = 'PROCESSED' AND accrual > 0;
+
+-- Переносим списания, которые раньше хранились фиктивными заказами
+INSERT INTO ledger_entries (user_id, entry_type, source, order_number, amount, created_at)
+SELECT user_id, 'DEBIT', 'WITHDRAWAL', order_number, COALESCE(accrual, 0), COALESCE(uploaded_at, strftime('%Y-%m-%d %H:%M:%f', 'now'))
+FROM orders
+WHERE status = 'WITHDRAWN';
+
+DELETE FROM orders WHERE status = 'WITHDRAWN';
@@ -0,0 +1,17 @@
+-- Текущий баланс пользователя в сотых долях балла. Меняется в одной транзакции с записью в ledger_entries
+CREATE TABLE balances (
+    user_id INTEGER PRIMARY KEY REFERENCES personal_account(id),
+    current INTEGER NOT NULL DEFAULT 0,
+    withdrawn INTEGER NOT NULL DEFAULT 0,
+    updated_at TIMESTAMP NOT NULL DEFAULT (strftime('%Y-%m-%d %H:%M:%f', 'now'))
+);
+
+INSERT INTO balances (user_id, current, withdrawn)
+SELECT
+    p.id,
+    COALESCE(SUM(l.amount) FILTER (WHERE l.entry_type = 'CREDIT'), 0)
+        - COALESCE(SUM(l.amount) FILTER (WHERE l.entry_type = 'DEBIT'), 0),
+    COALESCE(SUM(l.amount) FILTER (WHERE l.entry_type = 'DEBIT'), 0)
+FROM personal_account p
+LEFT JOIN ledger_entries l ON l.user_id = p.id
+GROUP BY p.id;
@@ -0,0 +1,4 @@
+DROP INDEX IF EXISTS orders_poll_idx;
+
+ALTER TABLE orders DROP COLUMN attempts;
+ALTER TABLE orders DROP COLUMN next_check_at;
@@ -0,0 +1,8 @@
+-- Подозрительные ответы accrual: по ним статус заказа не менялся, их разбирает оператор
+CREATE TABLE accrual_quarantine (
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    order_number TEXT NOT NULL,
+    reason TEXT NOT NULL,
+    response TEXT NOT NULL,
+    received_at TIMESTAMP NOT NULL DEFAULT (strftime('%Y-%m-%d %H:%M:%f', 'now'))
+);
@@ -0,0 +1,8 @@
+// Package sqlite - миграции схемы для SQLite. Повторяют migrations/ для PostgreSQL,
+// но суммы хранятся целым числом сотых долей: NUMERIC в SQLite превращает их в REAL
+package sqlite
+
+import "embed"
+
+//go:embed *.sql
+var FS embed.FS
@@ -5,21 +5,53 @@ import (
 	"flag"
 	"fmt"
 	"strings"
+	"time"
 
 	env "github.com/caarlos0/env/v9"
 )
 
 type Config struct {
-	RunAddr         string `env:"RUN_ADDRESS"`
-	DataBaseURI     string `env:"DATABASE_URI"`
-	Accural         string `env:"ACCRUAL_SYSTEM_ADDRESS"`
-	CookieSecretKey []byte `env:"COOKIE_SECRET_KEY"`
+	RunAddr         string        `env:"RUN_ADDRESS"`
+	DataBaseURI     string        `env:"DATABASE_URI"`
+	Accural         string        `env:"ACCRUAL_SYSTEM_ADDRESS"`
+	CookieSecretKey []byte        `env:"COOKIE_SECRET_KEY"`
+	TokenTTL        time.Duration `env:"TOKEN_TTL"`
+	SessionTTL      time.Duration `env:"SESSION_IDLE_TTL"`
+	PasswordHash    string        `env:"PASSWORD_HASH_ALGORITHM"`
+	// Периодическая сверка балансов; 0 - выключена
+	ReconcileInterval time.Duration `env:"RECONCILE_INTERVAL"`
+	ReconcileRepair   bool          `env:"RECONCILE_REPAIR"`
+	// Хранилище в памяти вместо PostgreSQL; включается и при пустом DATABASE_URI
+	InMemory bool `env:"IN_MEMORY"`
+	// Опрос accrual: число параллельных запросов, период выборки заказов и размер пачки за тик
+	AccrualWorkers      int           `env:"ACCRUAL_WORKERS"`
+	AccrualPollInterval time.Duration `env:"ACCRUAL_POLL_INTERVAL"`
+	AccrualBatchSize    int           `env:"ACCRUAL_BATCH_SIZE"`
+	AccrualTimeout      time.Duration `env:"ACCRUAL_TIMEOUT"`
+	// Пауза перед повторным опросом заказа после 204/5xx: удваивается от AccrualBackoff до AccrualMaxDelay
+	AccrualBackoff  time.Duration `env:"ACCRUAL_BACKOFF"`
+	AccrualMaxDelay time.Duration `env:"ACCRUAL_MAX_DELAY"`
+	// На сколько заказ закрепляется за экземпляром сервиса, пока тот его опрашивает
+	AccrualLease time.Duration `env:"ACCRUAL_LEASE"`
+	// Предохранитель: после AccrualBreakerThreshold отказов accrual подряд опрос встает на AccrualBreakerCooldown
+	AccrualBreakerThreshold int           `env:"ACCRUAL_BREAKER_THRESHOLD"`
+	AccrualBreakerCooldown  time.Duration `env:"ACCRUAL_BREAKER_COOLDOWN"`
+	// Заказ снимается с опроса (STUCK) после AccrualMaxAttempts пустых ответов подряд или через AccrualMaxAge
+	AccrualMaxAttempts int           `env:"ACCRUAL_MAX_ATTEMPTS"`
+	AccrualMaxAge      time.Duration `env:"ACCRUAL_MAX_AGE"`
+	// Токен оператора для /api/admin; пустой - админский API выключен
+	AdminToken string `env:"ADMIN_TOKEN"`
+	// Сколько ждать каждую ступень остановки: HTTP-запросы, затем запросы воркера в accrual
+	ShutdownTimeout time.Duration `env:"SHUTDOWN_TIMEOUT"`
+	// Сколько хранится ответ на запрос с Idempotency-Key и повторяется клиенту вместо нового выполнения
+	IdempotencyTTL time.Duration `env:"IDEMPOTENCY_TTL"`
 }
 
 var (
 	flagRunAddr     = flag.String("a", "", "address and port to run server")
 	flagDataBaseURI = flag.String("d", "", "DSN to connect to the database")
 	flagAccural     = flag.String("r", "", "address of the system calculation calculations")
+	flagInMemory    = flag.Bool("m", false, "use in-memory storage instead of the database")
 )
 
 func NewConfig() (*Config, error) {
@@ -44,7 +76,17 @@ func NewConfig() (*Config, error) {
 		cfg.Accural = *flagAccural
 	}
 
-	// Устанавливаем значение по умолчанию
+	if *flagInMemory {
+		cfg.InMemory = true
+	}
+
+	cfg.SetDefaults()
+	return cfg, nil
+}
+
+// SetDefaults заполняет незаданные настройки значениями по умолчанию. Других копий этих значений в сервисе нет:
+// пакеты получают их только через Config
+func (cfg *Config) SetDefaults() {
 	if cfg.RunAddr == "" {
 		cfg.RunAddr = ":8080"
 	} else if !strings.Contains(cfg.RunAddr, ":") {
@@ -55,7 +97,55 @@ func NewConfig() (*Config, error) {
 	if len(cfg.CookieSecretKey) == 0 {
 		cfg.CookieSecretKey = GenerateKeyToken()
 	}
-	return cfg, nil
+
+	if cfg.TokenTTL <= 0 {
+		cfg.TokenTTL = 24 * time.Hour
+	}
+
+	// Сессия без активности дольше SessionTTL считается истекшей
+	if cfg.SessionTTL <= 0 {
+		cfg.SessionTTL = 2 * time.Hour
+	}
+
+	if cfg.AccrualWorkers <= 0 {
+		cfg.AccrualWorkers = 4
+	}
+	if cfg.AccrualPollInterval <= 0 {
+		cfg.AccrualPollInterval = 5 * time.Second
+	}
+	if cfg.AccrualBatchSize <= 0 {
+		cfg.AccrualBatchSize = 100
+	}
+	if cfg.AccrualTimeout <= 0 {
+		cfg.AccrualTimeout = 10 * time.Second
+	}
+	if cfg.AccrualBackoff <= 0 {
+		cfg.AccrualBackoff = 5 * time.Second
+	}
+	if cfg.AccrualMaxDelay <= 0 {
+		cfg.AccrualMaxDelay = 30 * time.Minute
+	}
+	if cfg.AccrualLease <= 0 {
+		cfg.AccrualLease = 5 * time.Minute
+	}
+	if cfg.AccrualBreakerThreshold <= 0 {
+		cfg.AccrualBreakerThreshold = 5
+	}
+	if cfg.AccrualBreakerCooldown <= 0 {
+		cfg.AccrualBreakerCooldown = 30 * time.Second
+	}
+	if cfg.AccrualMaxAttempts <= 0 {
+		cfg.AccrualMaxAttempts = 20
+	}
+	if cfg.AccrualMaxAge <= 0 {
+		cfg.AccrualMaxAge = 7 * 24 * time.Hour
+	}
+	if cfg.ShutdownTimeout <= 0 {
+		cfg.ShutdownTimeout = 30 * time.Second
+	}
+	if cfg.IdempotencyTTL <= 0 {
+		cfg.IdempotencyTTL = 24 * time.Hour
+	}
 }
 
 func GenerateKeyToken() []byte {
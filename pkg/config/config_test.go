@@ -4,6 +4,7 @@ import (
 	"flag"
 	"os"
 	"testing"
+	"time"
 )
 
 func TestMain(m *testing.M) {
@@ -72,4 +73,29 @@ func TestConfig(t *testing.T) {
 			t.Errorf("Expected Accural http://localhost:9999, got %s", cfg.Accural)
 		}
 	})
+
+	t.Run("Accrual worker settings", func(t *testing.T) {
+		os.Clearenv()
+		defer os.Clearenv()
+		*flagRunAddr = ""
+
+		cfg, err := NewConfig()
+		if err != nil {
+			t.Fatalf("Unexpected error: %v", err)
+		}
+		if cfg.AccrualWorkers != 4 || cfg.AccrualPollInterval != 5*time.Second || cfg.AccrualBatchSize != 100 {
+			t.Errorf("Unexpected defaults: workers=%d interval=%s batch=%d", cfg.AccrualWorkers, cfg.AccrualPollInterval, cfg.AccrualBatchSize)
+		}
+
+		os.Setenv("ACCRUAL_WORKERS", "16")
+		os.Setenv("ACCRUAL_POLL_INTERVAL", "1s")
+		os.Setenv("ACCRUAL_BATCH_SIZE", "500")
+		cfg, err = NewConfig()
+		if err != nil {
+			t.Fatalf("Unexpected error: %v", err)
+		}
+		if cfg.AccrualWorkers != 16 || cfg.AccrualPollInterval != time.Second || cfg.AccrualBatchSize != 500 {
+			t.Errorf("Unexpected settings: workers=%d interval=%s batch=%d", cfg.AccrualWorkers, cfg.AccrualPollInterval, cfg.AccrualBatchSize)
+		}
+	})
 }